@@ -0,0 +1,195 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timerAggregator holds timer observations made since they
+// were last sent.
+type timerAggregator struct {
+	percentiles []float64
+	values      map[timerKey][]timerSample
+	stop        chan struct{}
+}
+
+// timerSample holds a timer value and the number of
+// times it was observed.
+type timerSample struct {
+	value float64
+	n     int64
+}
+
+// timerKey identifies a set of aggregated timer values.
+type timerKey struct {
+	stat string
+	// tags holds the metric's tags joined with newlines,
+	// which cannot occur within a tag.
+	tags string
+}
+
+// add records n observations of a timer value for the given
+// stat and tags. They are held as a single sample, so the
+// memory used does not depend on n.
+func (a *timerAggregator) add(stat string, tags []string, value float64, n int) {
+	key := timerKey{
+		stat: stat,
+		tags: strings.Join(tags, "\n"),
+	}
+	a.values[key] = append(a.values[key], timerSample{value: value, n: int64(n)})
+}
+
+// setTimerAggregation enables or disables timer aggregation.
+// See SetTimerAggregation for details.
+func (c *client) setTimerAggregation(interval time.Duration, percentiles []float64) error {
+	if interval < 0 {
+		return fmt.Errorf("negative aggregation interval %v", interval)
+	}
+	for _, p := range percentiles {
+		if !(p > 0 && p <= 100) {
+			return fmt.Errorf("invalid percentile %v", p)
+		}
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	err := c.stopTimers()
+	if interval > 0 {
+		c.timers = &timerAggregator{
+			percentiles: append([]float64(nil), percentiles...),
+			values:      make(map[timerKey][]timerSample),
+			stop:        make(chan struct{}),
+		}
+		go c.aggregateTimers(c.timers, interval)
+	}
+	return err
+}
+
+// stopTimers stops any timer aggregation, adding summaries of the
+// values held to the buffer. Caller must hold the client mutex lock.
+func (c *client) stopTimers() error {
+	a := c.timers
+	if a == nil {
+		return nil
+	}
+	c.timers = nil
+	close(a.stop)
+	return c.appendTimers(a)
+}
+
+// aggregateTimers sends the timer summaries held in a every interval
+// until a is stopped.
+func (c *client) aggregateTimers(a *timerAggregator, interval time.Duration) {
+	for {
+		select {
+		case <-c.after(interval):
+		case <-a.stop:
+			return
+		}
+		c.m.Lock()
+		var err error
+		if c.timers == a {
+			err = c.appendTimers(a)
+		}
+		c.m.Unlock()
+		c.reportError(err)
+	}
+}
+
+// appendTimers adds summaries of all the observations held in a to the
+// buffer and resets a. Values whose stat name or tags cannot be sent
+// with the current settings, which may have changed since they were
+// recorded, are discarded and reported in the returned error. Caller
+// must hold the client mutex lock.
+func (c *client) appendTimers(a *timerAggregator) error {
+	keys := make([]timerKey, 0, len(a.values))
+	for key := range a.values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].stat != keys[j].stat {
+			return keys[i].stat < keys[j].stat
+		}
+		return keys[i].tags < keys[j].tags
+	})
+
+	var buf []byte
+	var tagErr error
+	for _, key := range keys {
+		values := a.values[key]
+		sort.Slice(values, func(i, j int) bool {
+			return values[i].value < values[j].value
+		})
+		var count int64
+		for _, v := range values {
+			count += v.n
+		}
+		var tags []string
+		if key.tags != "" {
+			tags = strings.Split(key.tags, "\n")
+		}
+		name := Metric{Stat: key.stat, Tags: tags}
+		if err := c.sanitize(&name); err != nil {
+			if tagErr == nil {
+				tagErr = fmt.Errorf("cannot send aggregated timer %q: %v", key.stat, err)
+			}
+			delete(a.values, key)
+			continue
+		}
+		stat, tags := name.Stat, name.Tags
+		metrics := make([]Metric, 0, len(a.percentiles)+2)
+		for _, p := range a.percentiles {
+			metrics = append(metrics, Metric{
+				Stat:  c.suffixStat(stat, percentileName(p)),
+				Value: formatFloat(percentile(values, count, p)),
+				Kind:  "g",
+				Tags:  tags,
+			})
+		}
+		metrics = append(metrics, Metric{
+			Stat:  c.suffixStat(stat, "max"),
+			Value: formatFloat(values[len(values)-1].value),
+			Kind:  "g",
+			Tags:  tags,
+		}, Metric{
+			Stat:  c.suffixStat(stat, "count"),
+			Value: strconv.FormatInt(count, 10),
+			Kind:  "c",
+			Tags:  tags,
+		})
+		for _, m := range metrics {
+			buf = c.appendTo(buf[:0], &m)
+			if err := c.append(buf); err != nil {
+				return err
+			}
+		}
+		delete(a.values, key)
+	}
+	return tagErr
+}
+
+// percentile returns the p'th percentile of the samples, sorted by
+// value, which hold count observations in all, using the nearest-rank
+// method with each sample weighted by its number of observations.
+func percentile(values []timerSample, count int64, p float64) float64 {
+	rank := int64(math.Ceil(p / 100 * float64(count)))
+	var seen int64
+	for _, v := range values {
+		seen += v.n
+		if seen >= rank {
+			return v.value
+		}
+	}
+	return values[len(values)-1].value
+}
+
+// percentileName returns the stat name suffix used for the p'th
+// percentile, for example "p99" or "p99_9".
+func percentileName(p float64) string {
+	return "p" + strings.Replace(formatFloat(p), ".", "_", -1)
+}
@@ -0,0 +1,251 @@
+package statsd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// samples returns a single observation of each of the given values.
+func samples(values ...float64) []timerSample {
+	s := make([]timerSample, len(values))
+	for i, v := range values {
+		s[i] = timerSample{value: v, n: 1}
+	}
+	return s
+}
+
+var percentileTests = []struct {
+	values  []timerSample
+	p       float64
+	control float64
+}{{
+	values:  samples(1),
+	p:       50,
+	control: 1,
+}, {
+	values:  samples(1, 2, 3, 4),
+	p:       50,
+	control: 2,
+}, {
+	values:  samples(1, 2, 3, 4),
+	p:       100,
+	control: 4,
+}, {
+	values:  samples(1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+	p:       95,
+	control: 10,
+}, {
+	values:  samples(1, 2, 3, 4, 5, 6, 7, 8, 9, 10),
+	p:       0.1,
+	control: 1,
+}, {
+	values:  []timerSample{{value: 1, n: 3}, {value: 2, n: 1}},
+	p:       75,
+	control: 1,
+}, {
+	values:  []timerSample{{value: 1, n: 3}, {value: 2, n: 1}},
+	p:       76,
+	control: 2,
+}, {
+	values:  []timerSample{{value: 1, n: 1}, {value: 2, n: 1 << 40}},
+	p:       0.1,
+	control: 2,
+}}
+
+func TestPercentile(t *testing.T) {
+	for i, pt := range percentileTests {
+		var count int64
+		for _, v := range pt.values {
+			count += v.n
+		}
+		value := percentile(pt.values, count, pt.p)
+		if value != pt.control {
+			t.Errorf("%d: incorrect value, want %v, got %v", i, pt.control, value)
+		}
+	}
+}
+
+func TestTimerAggregation(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, []float64{50, 99.9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		err := tc.client.duration("b", time.Duration(i)*time.Millisecond, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tc.client.durations("a", []time.Duration{time.Second, 3 * time.Second}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.timingFloat("a", 0.5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.increment("incr", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Disabling aggregation sends the summaries.
+	err = tc.client.setTimerAggregation(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.duration("b", time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c\n"+
+		"a.p50:1000|g\na.p99_9:3000|g\na.max:3000|g\na.count:3|c\n"+
+		"b.p50:5|g\nb.p99_9:10|g\nb.max:10|g\nb.count:10|c\n"+
+		"b:1000|ms")
+}
+
+func TestTimerAggregationInterval(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(10*time.Millisecond, []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.client.setTimerAggregation(0, nil)
+	err = tc.client.timing("t", 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.waitBuffered(t)
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "t.p50:20|g\nt.max:20|g\nt.count:1|c")
+}
+
+func TestTimerAggregationError(t *testing.T) {
+	tc := newTestClient(t)
+	for _, p := range []float64{0, -1, 101} {
+		err := tc.client.setTimerAggregation(time.Second, []float64{p})
+		if err == nil {
+			t.Errorf("no error for percentile %v", p)
+		}
+	}
+	err := tc.client.setTimerAggregation(-time.Second, nil)
+	if err == nil {
+		t.Errorf("no error for negative interval")
+	}
+}
+
+func TestErrorFunc(t *testing.T) {
+	c := newClient()
+	var got error
+	c.setErrorFunc(func(err error) {
+		got = err
+	})
+	c.reportError(nil)
+	if got != nil {
+		t.Fatalf("unexpected error %v", got)
+	}
+	want := errors.New("some error")
+	c.reportError(want)
+	if got != want {
+		t.Fatalf("unexpected error %v", got)
+	}
+}
+
+func TestTimerAggregationClose(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := tc.client.timers
+	err = tc.client.timing("t", 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing sends the values held and stops the aggregation.
+	if err := tc.client.close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, tc.buf.String(), "t.p50:20|g\nt.max:20|g\nt.count:1|c")
+	select {
+	case <-a.stop:
+	default:
+		t.Fatal("aggregation not stopped")
+	}
+	if tc.client.timers != nil {
+		t.Fatal("aggregator still set after close")
+	}
+}
+
+func TestTimerAggregationDurationN(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.durationN("n", 5*time.Millisecond, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.durationN("n", 9*time.Millisecond, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Names recorded through handles are checked too.
+	err = tc.client.timer("handle|x", 1).Observe(7 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.setTimerAggregation(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), ""+
+		"handle_x.p50:7|g\nhandle_x.max:7|g\nhandle_x.count:1|c\n"+
+		"n.p50:5|g\nn.max:9|g\nn.count:4|c")
+}
+
+func TestTimerAggregationBytes(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []time.Duration{3, 1, 2} {
+		err := tc.client.durationBytes("app.", []byte("b"), d*time.Millisecond, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tc.client.setTimerAggregation(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "app.b.p50:2|g\napp.b.max:3|g\napp.b.count:3|c")
+}
+
+func TestTimerAggregationLargeN(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recording many observations at once takes
+	// no more memory than recording one.
+	allocs := testing.AllocsPerRun(10, func() {
+		tc.client.durationN("n", 5*time.Millisecond, 1<<30, 1)
+	})
+	if allocs > 5 {
+		t.Fatalf("durationN made %v allocations", allocs)
+	}
+	err = tc.client.setTimerAggregation(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "n.p50:5|g\nn.max:5|g\nn.count:11811160064|c")
+}
@@ -1,64 +1,924 @@
 package statsd
 
-import "time"
-
-var (
-	defaultClient *client = newClient()
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
 )
 
-// SetAddr sets the network address that stats will be sent to.
+// defaultClient holds the client used by the package-level
+// functions, as returned by Default.
+var defaultClient atomic.Pointer[Client]
+
+func init() {
+	defaultClient.Store(newDefaultClient())
+}
+
+// newDefaultClient returns a client for the package-level functions
+// that discards metrics until an address is set.
+func newDefaultClient() *Client {
+	c := newClient()
+	c.noAddr = true
+	c.discard.Store(true)
+	return &Client{c: c}
+}
+
+// Default returns the client used by the package-level functions.
+// Configuring it, for example with SetErrorFunc or SetFlushInterval,
+// is equivalent to calling the package-level function of the same
+// name.
+func Default() *Client {
+	return defaultClient.Load()
+}
+
+// SetDefault makes the package-level functions use cl, so that code
+// that uses them, such as libraries, sends metrics through a client
+// configured by the application. Metrics are sent with the prefix and
+// default tags of cl. Any metrics buffered by the previous default
+// client are flushed, with errors passed to its error function, but
+// the client is not closed; use Default first to get it if it should
+// be closed. If cl is nil, a new client is used that discards metrics
+// until SetAddr is called.
+func SetDefault(cl *Client) {
+	if cl == nil {
+		cl = newDefaultClient()
+	}
+	old := defaultClient.Swap(cl)
+	if old != cl {
+		old.c.reportError(old.c.flushContext(context.Background()))
+	}
+}
+
+// SetAddr sets the network address that stats will be sent to. The
+// address may start with a scheme naming the network to use:
+//
+//	udp://localhost:8125           UDP; also udp4:// and udp6://
+//	tcp://localhost:8125           TCP; also tcp4:// and tcp6://
+//	unixgram:///var/run/statsd.sock  Unix datagram socket; also unix://
+//
+// An address without a scheme that starts with "/" or "." is the path
+// of a Unix datagram socket; any other address without a scheme, such
+// as "localhost:8125", is sent to with UDP. Over TCP, each write ends
+// with a newline. IPv6 addresses must be enclosed in brackets, as in
+// "[::1]:8125".
+//
+// The udp4 and udp6 schemes restrict the client to IPv4 or IPv6
+// addresses. When a UDP host name resolves to several addresses, the
+// client uses the first one to which it can send an empty packet, so
+// that an address family without a route is skipped.
+//
+// When a write to a TCP connection fails, the metrics written are
+// discarded and the connection is redialed in the background, as
+// described for SetReconnectBackoff.
+//
+// Metrics buffered when the address is changed are first flushed to
+// the old address. An error doing so is passed to the function set
+// with SetErrorFunc rather than returned.
+//
+// Until an address has been set successfully, the package-level
+// functions discard metrics without error, so that libraries can
+// record metrics whether or not the program sends them anywhere.
+//
+// Unless SetFlushInterval has been called, SetAddr makes buffered
+// metrics be flushed every 300ms in the background, so that metrics
+// are sent even if Flush is never called.
 func SetAddr(addr string) error {
-	return defaultClient.setAddr(addr)
+	cl := Default()
+	if err := cl.SetAddr(addr); err != nil {
+		return err
+	}
+	cl.c.startDefaultFlusher(defaultFlushInterval)
+	return nil
+}
+
+// Addr returns the address set with SetAddr, as it was given.
+// See RemoteAddr for the address that it resolved to.
+func Addr() string {
+	return Default().Addr()
+}
+
+// RemoteAddr returns the network address that the package-level
+// functions currently send metrics to, after resolving any host name
+// in Addr, or the empty string if the package is not connected.
+func RemoteAddr() string {
+	return Default().RemoteAddr()
+}
+
+// SetNegativeGaugeReset sets whether negative values passed to Gauge,
+// Gauge64 and GaugeFloat64 are preceded by a line setting the gauge
+// to zero. Stock statsd servers interpret a negative gauge value as a
+// decrement, so the reset is needed for the gauge to end up with the
+// given value. By default no reset line is sent.
+func SetNegativeGaugeReset(reset bool) {
+	Default().SetNegativeGaugeReset(reset)
+}
+
+// SetDropZeroCounts sets whether counter updates with a zero count,
+// which carry no information, are discarded rather than sent.
+// Other metrics with a zero value are not affected.
+func SetDropZeroCounts(drop bool) {
+	Default().SetDropZeroCounts(drop)
+}
+
+// SetPrefix sets a prefix to be added to the start of every bucket name
+// sent by the package-level functions, such as "myservice.prod.". No
+// separator is added, so the prefix should usually end with a dot. The
+// prefix counts towards the packet size. It may not contain any of the
+// characters '|', ':', ',', ';', '[' or newline. An empty prefix, the
+// default, adds nothing.
+func SetPrefix(prefix string) error {
+	return Default().SetPrefix(prefix)
+}
+
+// Prefix returns the prefix added to the start of every bucket name
+// sent by the package-level functions, as set with SetPrefix.
+func Prefix() string {
+	return Default().Prefix()
+}
+
+// SetEnabled enables or disables sending metrics with the package-level
+// functions, for example to silence a misbehaving service without
+// restarting it. While disabled, the functions and Flush do nothing and
+// return no error, without acquiring any lock. Disabling discards any
+// buffered metrics, so metrics recorded before then are not sent when
+// sending is enabled again. Metrics are enabled by default. It is safe
+// to call from any goroutine.
+func SetEnabled(enabled bool) {
+	Default().SetEnabled(enabled)
+}
+
+// Enabled reports whether the package-level functions send metrics,
+// as set by SetEnabled.
+func Enabled() bool {
+	return Default().Enabled()
+}
+
+// SetGlobalTags sets tags to be sent with every subsequent metric,
+// for example the host or service name. They are sent before any
+// tags given for an individual metric. Calling SetGlobalTags with
+// no arguments removes the global tags.
+func SetGlobalTags(tags ...string) error {
+	return Default().SetGlobalTags(tags...)
+}
+
+// GlobalTags returns the tags set with SetGlobalTags.
+func GlobalTags() []string {
+	return Default().GlobalTags()
+}
+
+// SetTagFormat sets the format in which tags, both global and
+// those given for an individual metric, are sent. The default is
+// TagFormatDogStatsD. An error is returned if the global tags cannot
+// be sent in the new format.
+//
+// Functions given tags that cannot be sent in the current format
+// return an error. Aggregated timer values whose tags became invalid
+// because the format changed after they were recorded are discarded
+// when they are sent, and the error is passed to the function set
+// with SetErrorFunc.
+func SetTagFormat(format TagFormat) error {
+	return Default().SetTagFormat(format)
+}
+
+// SetStrict sets whether metrics whose stat names or tags contain
+// characters that cannot be sent are rejected. By default, such
+// characters, for example '|' or newline, which would corrupt the
+// packet, or ',' in a DogStatsD tag, are replaced with '_' or the
+// character set with SetReplacementChar. In strict mode, the metric is
+// instead discarded and an error is returned, which is a *TagError for
+// an invalid tag. Tags that cannot be fixed by replacing characters,
+// such as empty tags, are always rejected. An error is returned if the
+// global tags cannot be sent in strict mode.
+func SetStrict(strict bool) error {
+	return Default().SetStrict(strict)
+}
+
+// SetReplacementChar sets the character used in place of characters
+// that cannot be sent in stat names and tags. It must be an ASCII
+// letter or digit, '_' or '-'. The default is '_'.
+func SetReplacementChar(r rune) error {
+	return Default().SetReplacementChar(r)
+}
+
+// SetMaxTagValues limits the number of distinct values sent for each tag
+// key, guarding against unbounded tag cardinality such as a tag holding
+// a user ID. Tags are taken to be of the form "key:value". Once perKey
+// distinct values have been sent for a key, any other value for that
+// key is replaced by "__overflow__", and the first such replacement for
+// each key is reported to the function set with SetErrorFunc. Values
+// already seen continue to be sent unchanged. At most 10000 values are
+// remembered in total across all keys; beyond that, new values for any
+// key are replaced. A perKey of zero removes the limit.
+func SetMaxTagValues(perKey int) error {
+	return Default().SetMaxTagValues(perKey)
+}
+
+// ResetTagValues forgets the tag values seen so far by the limit set
+// with SetMaxTagValues, so that new values can be sent again.
+func ResetTagValues() {
+	Default().ResetTagValues()
+}
+
+// SetResolveInterval makes the host name in the address set with SetAddr
+// be resolved again every interval in the background. When the address
+// that metrics are sent to is no longer among those the name resolves
+// to, any buffered metrics are flushed and the client reconnects to the
+// first address the name now resolves to. This is useful when a statsd
+// server moves, as the name is otherwise only resolved when connecting.
+// Resolution errors are passed to the function set with SetErrorFunc.
+// An interval of zero, the default, stops re-resolution.
+func SetResolveInterval(interval time.Duration) error {
+	return Default().SetResolveInterval(interval)
+}
+
+// FlushOnSignal makes the package-level functions flush any buffered
+// metrics whenever one of the given signals arrives, so that metrics
+// are not lost when a process is asked to stop. With no signals,
+// os.Interrupt and SIGTERM are used. It does not stop the process:
+// the application remains responsible for handling the signals, which
+// it may also do with signal.Notify. Note that, as with signal.Notify,
+// the default action of the signals, such as exiting, no longer
+// happens. Flush errors are passed to the function set with
+// SetErrorFunc. Calling the returned function stops the flushing and
+// restores the default action unless the signals are handled
+// elsewhere.
+func FlushOnSignal(signals ...os.Signal) (stop func()) {
+	return Default().FlushOnSignal(signals...)
+}
+
+// SetFlushInterval makes buffered metrics be flushed every interval in
+// the background, so that metrics recorded shortly before a quiet
+// period, or before the program exits without calling Flush, are not
+// held in the buffer indefinitely. Nothing is written when no metrics
+// are buffered. Flush errors are passed to the function set with
+// SetErrorFunc. Close stops the background flushing. An interval of
+// zero stops it too. By default, metrics are flushed every 300ms once
+// SetAddr has been called; see SetAddr.
+func SetFlushInterval(interval time.Duration) error {
+	return Default().SetFlushInterval(interval)
+}
+
+// FlushInterval returns the interval set with SetFlushInterval,
+// or zero if metrics are not flushed in the background.
+func FlushInterval() time.Duration {
+	return Default().FlushInterval()
+}
+
+// SetLinger makes buffered metrics be flushed in the background once
+// the first of them has waited for d, unless the buffer is flushed
+// before then, so that the delay before metrics are sent is bounded
+// without waking up periodically while no metrics are recorded. Flush
+// errors are passed to the function set with SetErrorFunc. A duration
+// of zero, the default, stops the background flushing.
+func SetLinger(d time.Duration) error {
+	return Default().SetLinger(d)
+}
+
+// SetCompression makes the client compress metrics sent over a TCP or
+// TLS connection with gzip at the given level, as defined by the
+// compress/gzip package, for servers that accept compressed streams.
+// Each connection carries a single gzip stream, which is flushed with
+// every write and ended when the connection is closed. Setting the
+// level flushes any buffered metrics and redials the connection; an
+// error flushing is passed to the error function. Use WithCompression
+// to compress the first connection without redialing. A level of
+// zero, the default, disables compression. An error is returned if
+// the client does not use a TCP or TLS connection.
+func SetCompression(level int) error {
+	return Default().SetCompression(level)
+}
+
+// SetUnconnected sets whether metrics sent over UDP use an unconnected
+// socket, with the destination given for each packet, rather than a
+// connected one. When nothing is listening at the destination, for
+// example while a server restarts, a connected socket receives ICMP
+// errors that make later writes fail, and on some platforms keep
+// failing; an unconnected socket ignores them. The destination address
+// is resolved when the client connects, which happens when the address
+// is set and as described for SetResolveInterval. The default is to
+// use a connected socket.
+func SetUnconnected(unconnected bool) error {
+	return Default().SetUnconnected(unconnected)
+}
+
+// SetWriteTimeout sets the maximum time that writing a packet of metrics
+// may take. Packets are written with the client lock held, so a server
+// that stops reading from a Unix socket or a stalled connection can
+// otherwise block every goroutine recording metrics. When a write times
+// out, the packet is discarded and the error, which satisfies
+// errors.Is(err, os.ErrDeadlineExceeded), is returned as for any other
+// write error. The timeout only applies to connections that support
+// write deadlines, which includes all network connections. A timeout of
+// zero, the default, means no limit.
+func SetWriteTimeout(timeout time.Duration) error {
+	return Default().SetWriteTimeout(timeout)
+}
+
+// SetReconnectBackoff sets the delay between attempts to redial a TCP
+// connection after a write fails. The first attempt is made after min,
+// and the delay doubles after each failed attempt up to max. Failed
+// attempts are passed to the function set with SetErrorFunc. Until the
+// connection is redialed, buffered metrics are discarded when they would
+// be written, and the error is returned as for a failed write; Stats
+// reports the number of metrics discarded. The defaults are 100ms and
+// 30s.
+func SetReconnectBackoff(min, max time.Duration) error {
+	return Default().SetReconnectBackoff(min, max)
+}
+
+// SetSpool makes the package-level functions write packets of metrics
+// that cannot be sent to the file at path, which is created if needed,
+// so that they are not lost while the network is unavailable. Spooled
+// packets are replayed, oldest first, when the client next flushes;
+// while any remain, new packets are added to the spool after them so
+// that order is preserved. Packets already in the file, for example
+// from a previous run, are also replayed. If the file holds invalid
+// data, the remainder of the file is discarded and an error is
+// passed to the function set with SetErrorFunc.
+//
+// The size of the file and the number of packets replayed by each
+// flush can be limited with SpoolMaxSize and SpoolReplayLimit.
+// An empty path stops spooling; the file is left as it is.
+func SetSpool(path string, opts ...SpoolOption) error {
+	return Default().SetSpool(path, opts...)
+}
+
+// SetAsync makes the package-level functions queue metrics of up to
+// queueLen entries instead of adding them to the buffer directly, so
+// that they never wait for the client lock or for a flush. A
+// background goroutine formats the queued metrics and sends them.
+// When the queue is full, metrics are discarded and counted in
+// ClientStats.Dropped. Because metrics are sent later, errors such
+// as invalid stat names are passed to the function set with
+// SetErrorFunc rather than returned. Flush and Close wait for the
+// queued metrics to be sent.
+//
+// A queueLen of zero makes the functions synchronous again, as they
+// are by default. Metrics recorded concurrently with the queue being
+// stopped, by SetAsync or Close, may be discarded and counted in
+// ClientStats.Dropped.
+func SetAsync(queueLen int) error {
+	return Default().SetAsync(queueLen)
+}
+
+// SetOverflowPolicy sets what happens to metrics that cannot be sent
+// by the package-level functions, for example because writes to the
+// connection fail. By default, with DropNewest, each packet that
+// cannot be written is discarded. With DropOldest, such packets are
+// kept in memory, up to maxBytes in total, and written before later
+// packets once writes succeed again; when the limit is exceeded, the
+// oldest are discarded. With Block, an asynchronous client set up
+// with SetAsync waits for room in its queue rather than discarding
+// metrics. A spool set with SetSpool takes precedence over the
+// policy. Discarded metrics are counted in ClientStats.Dropped.
+//
+// maxBytes is ignored unless policy is DropOldest.
+func SetOverflowPolicy(policy OverflowPolicy, maxBytes int) error {
+	return Default().SetOverflowPolicy(policy, maxBytes)
+}
+
+// SetDropReportInterval makes the package-level functions pass the
+// number of metrics dropped since the last report to the function set
+// with SetErrorFunc, at most once per interval d, as an error of the
+// form "n metrics dropped". Reports are made when packets are
+// flushed. A zero interval, the default, disables the reports.
+func SetDropReportInterval(d time.Duration) {
+	Default().SetDropReportInterval(d)
+}
+
+// Stats returns statistics about the metrics sent by the
+// package-level functions.
+func Stats() ClientStats {
+	return Default().Stats()
+}
+
+// DebugString returns a description of the configuration and state of
+// the package-level client, such as its address, packet size and
+// statistics, one "name: value" line for each item, to help diagnose
+// missing metrics. The names and their order are stable. Because
+// metrics may contain sensitive data, buffered metrics are only
+// included if includeBuffer is true.
+func DebugString(includeBuffer bool) string {
+	return Default().DebugString(includeBuffer)
+}
+
+// ResetStats sets the cumulative statistics returned by Stats to zero,
+// so that each call to Stats returns the counts since the previous
+// reset, for example when scraping them periodically.
+func ResetStats() {
+	Default().ResetStats()
+}
+
+// Pending returns the number of metrics buffered by the package-level
+// functions that have not yet been flushed, and their size in bytes.
+// Metrics queued by SetAsync are not counted until they are added to
+// the buffer.
+func Pending() (metrics, bytes int) {
+	return Default().Pending()
+}
+
+// SetSendBufferSize sets the size in bytes of the operating system's
+// send buffer for the connection, which is used whenever it is dialed.
+// A larger buffer means fewer packets are dropped by the kernel when
+// metrics are sent in bursts. An error is returned if the size cannot
+// be set on the current connection; when it cannot be set on a later
+// connection, the error is passed to the function set with
+// SetErrorFunc. A size of zero, the default, leaves the system default
+// for new connections.
+func SetSendBufferSize(bytes int) error {
+	return Default().SetSendBufferSize(bytes)
+}
+
+// SendBufferSize returns the size in bytes of the operating system's
+// send buffer for the connection as reported by the system, which may
+// differ from the size set with SetSendBufferSize; Linux, for example,
+// doubles it. If it cannot be found, the size set with
+// SetSendBufferSize is returned.
+func SendBufferSize() int {
+	return Default().SendBufferSize()
+}
+
+// SetPacketSize sets the maximum size in bytes of the packets that
+// metrics are sent in. The default is PacketSizeSafe, 512 bytes, which
+// is safe for UDP on any network; PacketSizeLAN and PacketSizeJumbo
+// suit networks with a known MTU, and Unix datagram sockets commonly
+// allow 8192. Metrics larger than the packet size are rejected. Any
+// buffered metrics that no longer fit are flushed first.
+func SetPacketSize(size int) error {
+	return Default().SetPacketSize(size)
+}
+
+// PacketSize returns the maximum size of a packet, as set with
+// SetPacketSize.
+func PacketSize() int {
+	return Default().PacketSize()
+}
+
+// SetOversizePolicy sets what happens to metrics sent by the
+// package-level functions that are larger than the packet size. By
+// default, with OversizeDrop, they are discarded and the call returns
+// an error. With OversizeTruncate, the stat name is shortened to fit;
+// with OversizeSend, the metric is sent in a packet of its own, which
+// suits TCP connections. Discarded and truncated metrics are counted
+// in ClientStats.
+func SetOversizePolicy(policy OversizePolicy) error {
+	return Default().SetOversizePolicy(policy)
+}
+
+// SetPacketHeader makes every packet sent by the package-level
+// functions begin with the given header line, as required by some
+// statsd relays to route packets, for example "#app=checkout". The
+// header and its newline count towards the packet size, so less room
+// is left for metrics, and metrics that do not fit after the header
+// are rejected as too big. The header may not contain a newline. Any
+// buffered metrics are flushed first. An empty header, the default,
+// sends packets without one.
+func SetPacketHeader(header []byte) error {
+	return Default().SetPacketHeader(header)
+}
+
+// SetMaxPacketsPerSecond limits the rate at which the package-level
+// functions write packets to n per second, so that a burst of metrics
+// does not overwhelm a statsd server that drops packets it cannot keep
+// up with. Writes are spaced out evenly, with the client lock held, so
+// recording a metric that fills the buffer waits until its packet may
+// be written, as do other goroutines recording metrics meanwhile. With
+// SetAsync, metrics are queued while the background goroutine waits.
+// A rate of zero, the default, means no limit.
+func SetMaxPacketsPerSecond(n int) error {
+	return Default().SetMaxPacketsPerSecond(n)
+}
+
+// SetFlushThreshold makes the client flush once n metrics are buffered,
+// even if the packet is not full, so that metrics recorded at a low
+// rate are not held back. Packets are still split when they reach the
+// packet size. A threshold of 1 sends each metric as soon as it is
+// recorded. A threshold of zero, the default, means that packets are
+// only flushed when full or when Flush is called.
+func SetFlushThreshold(n int) error {
+	return Default().SetFlushThreshold(n)
+}
+
+// SetUnbuffered makes the package-level functions write each metric in
+// its own packet as soon as it is recorded, which suits processes that
+// send few metrics and may exit without calling Flush. Errors writing
+// the packet are returned by the call that recorded the metric, and
+// Flush has nothing to do. This costs a packet, and for UDP a system
+// call, per metric, so it is unsuited to high rates. Any buffered
+// metrics are flushed first. Metrics larger than the packet size are
+// still rejected.
+func SetUnbuffered(unbuffered bool) error {
+	return Default().SetUnbuffered(unbuffered)
+}
+
+// SetContainerID sets the ID of the container that metrics originate
+// from, which DogStatsD agents use for origin detection. It is sent
+// with every metric as a "|c:" suffix after the tags, and is only sent
+// in TagFormatDogStatsD format. The ID may not contain the characters
+// '|', ',', '#', ':' or newline. An empty ID, the default, sends no
+// suffix. See DetectContainerID for a way to find the ID.
+func SetContainerID(id string) error {
+	return Default().SetContainerID(id)
+}
+
+// SetErrorFunc sets a function to be called with errors that happen
+// in the background, for example when sending aggregated timers,
+// and so cannot be returned to the caller. If the function panics,
+// the panic is recovered and counted in ClientStats.CallbackPanics.
+func SetErrorFunc(f func(error)) {
+	Default().SetErrorFunc(f)
+}
+
+// SetFlushFunc sets a function to be called after each packet of
+// metrics sent by the package-level functions is written successfully,
+// with the size of the packet in bytes and the number of metrics in
+// it, for example to log or monitor the client. It is called with the
+// client lock held, so it must return quickly and must not call any
+// of the package-level functions. It is not called for packets that
+// cannot be written, nor when there is nothing to flush. If the
+// function panics, the panic is recovered and counted in
+// ClientStats.CallbackPanics, and the first such panic is passed to
+// the error function.
+func SetFlushFunc(f func(bytes, metrics int)) {
+	Default().SetFlushFunc(f)
+}
+
+// SetTimerAggregation enables client-side aggregation of timers. When
+// enabled, values passed to Duration, Durations, Timing and the other
+// timer functions, including Timer handles, are held in memory rather
+// than sent, and every interval a summary of the values held for each
+// bucket is sent in their place: a gauge named after the bucket with a
+// suffix such as ".p95" for each of the given percentiles, a ".max"
+// gauge and a ".count" counter. For example, a percentile of 99.9 is
+// sent as "bucket.p99_9". Percentiles must be greater than 0 and no
+// greater than 100. Aggregated values are never sampled, and a value
+// passed to DurationN counts as n values.
+//
+// Calling SetTimerAggregation with a zero interval disables aggregation.
+// Any values held when aggregation is disabled or reconfigured, or
+// when the client is closed, are summarized immediately.
+func SetTimerAggregation(interval time.Duration, percentiles []float64) error {
+	return Default().SetTimerAggregation(interval, percentiles)
 }
 
 // Increment increments the counter for the given bucket.
 func Increment(stat string, count int, rate float64) error {
-	return defaultClient.increment(stat, count, rate)
+	return Default().Increment(stat, count, rate)
+}
+
+// Increment64 is like Increment but takes an int64 count, so large counts
+// are not truncated on 32-bit platforms.
+func Increment64(stat string, count int64, rate float64) error {
+	return Default().Increment64(stat, count, rate)
+}
+
+// IncrementBytes is like Increment but takes the bucket name as a byte
+// slice, avoiding the need to convert it to a string.
+func IncrementBytes(stat []byte, count int, rate float64) error {
+	return Default().IncrementBytes(stat, count, rate)
+}
+
+// IncrementFloat increments the counter for the given bucket by a
+// fractional amount.
+func IncrementFloat(stat string, delta float64, rate float64) error {
+	return Default().IncrementFloat(stat, delta, rate)
 }
 
 // Decrement decrements the counter for the given bucket.
 func Decrement(stat string, count int, rate float64) error {
-	return defaultClient.decrement(stat, count, rate)
+	return Default().Decrement(stat, count, rate)
 }
 
 // Duration records time spent for the given bucket with time.Duration.
 func Duration(stat string, duration time.Duration, rate float64) error {
-	return defaultClient.duration(stat, duration, rate)
+	return Default().Duration(stat, duration, rate)
+}
+
+// DurationBytes is like Duration but takes the bucket name as a byte
+// slice, avoiding the need to convert it to a string.
+func DurationBytes(stat []byte, duration time.Duration, rate float64) error {
+	return Default().DurationBytes(stat, duration, rate)
+}
+
+// DurationSince records the time elapsed since start for the given
+// bucket. Because the arguments of a deferred call are evaluated
+// when the defer statement executes, it can be used to time the
+// whole of the enclosing function:
+//
+//	defer statsd.DurationSince("handler", time.Now(), 1)
+func DurationSince(stat string, start time.Time, rate float64) error {
+	return Default().DurationSince(stat, start, rate)
+}
+
+// Durations records several durations for the given bucket at once.
+// It is more efficient than calling Duration for each one. If any of
+// the durations is negative, none are recorded and an error is
+// returned.
+func Durations(stat string, durations []time.Duration, rate float64) error {
+	return Default().Durations(stat, durations, rate)
+}
+
+// DurationN records n observations of the same duration for the given
+// bucket. Rather than sending n identical lines, it sends a single line
+// with its sample rate divided by n, so a statsd server will count it n
+// times. For example, DurationN("t", time.Second, 4, 1) sends
+// "t:1000|ms|@0.25".
+func DurationN(stat string, duration time.Duration, n int, rate float64) error {
+	return Default().DurationN(stat, duration, n, rate)
+}
+
+// DurationFloat is like Duration but records the time in fractional
+// milliseconds rather than truncating it to a whole number of milliseconds.
+func DurationFloat(stat string, duration time.Duration, rate float64) error {
+	return Default().DurationFloat(stat, duration, rate)
 }
 
 // Timing records time spent for the given bucket in milliseconds.
 func Timing(stat string, delta int, rate float64) error {
-	return defaultClient.timing(stat, delta, rate)
+	return Default().Timing(stat, delta, rate)
+}
+
+// TimingFloat records time spent for the given bucket in fractional
+// milliseconds. Negative, infinite and NaN values are rejected.
+func TimingFloat(stat string, delta float64, rate float64) error {
+	return Default().TimingFloat(stat, delta, rate)
 }
 
 // Time calculates time spent in given function and send it.
 func Time(stat string, rate float64, f func()) error {
-	return defaultClient.time(stat, rate, f)
+	return Default().Time(stat, rate, f)
+}
+
+// TimeDuration is like Time but also returns the time spent in f.
+// The function is always called, even if the stat is not sent
+// because of sampling.
+func TimeDuration(stat string, rate float64, f func()) (time.Duration, error) {
+	return Default().TimeDuration(stat, rate, f)
 }
 
-// Gauge records arbitrary values for the given bucket.
+// TimeErr is like Time but for functions that return an error.
+// The time is recorded regardless of whether f fails. If f returns
+// an error, that error is returned unchanged; otherwise the error
+// from sending the stat is returned.
+func TimeErr(stat string, rate float64, f func() error) error {
+	return Default().TimeErr(stat, rate, f)
+}
+
+// Gauge records arbitrary values for the given bucket. As with
+// counters, when rate is less than 1 the value is only sent with
+// that probability, and the rate is included in the metric.
 func Gauge(stat string, value int, rate float64) error {
-	return defaultClient.gauge(stat, value, rate)
+	return Default().Gauge(stat, value, rate)
+}
+
+// Gauge64 is like Gauge but takes an int64 value, so large values
+// are not truncated on 32-bit platforms.
+func Gauge64(stat string, value int64, rate float64) error {
+	return Default().Gauge64(stat, value, rate)
+}
+
+// GaugeBytes is like Gauge but takes the bucket name as a byte
+// slice, avoiding the need to convert it to a string.
+func GaugeBytes(stat []byte, value int, rate float64) error {
+	return Default().GaugeBytes(stat, value, rate)
+}
+
+// GaugeFloat64 is like Gauge but records a floating point value.
+func GaugeFloat64(stat string, value float64, rate float64) error {
+	return Default().GaugeFloat64(stat, value, rate)
+}
+
+// GaugeValue is like Gauge but accepts a value of any integer or
+// floating point type. Integer values are sent exactly as Gauge and
+// Gauge64 would send them, and floating point values as GaugeFloat64
+// would.
+func GaugeValue[T Number](stat string, value T, rate float64) error {
+	return ClientGaugeValue(Default(), stat, value, rate)
+}
+
+// GaugeAt is like Gauge but records the value as of the given time,
+// which is useful for backfilling values that could not be sent at
+// the time. The time is sent as a DogStatsD "|T" suffix holding the
+// Unix time in seconds, for example "gauge:30|g|T1700000000", so
+// this is only useful with servers that understand that format.
+// The value is never sampled.
+func GaugeAt(stat string, value int, t time.Time) error {
+	return Default().GaugeAt(stat, value, t)
+}
+
+// GaugeBool records a boolean state for the given bucket as a gauge
+// with value 1 for true and 0 for false.
+func GaugeBool(stat string, value bool, rate float64) error {
+	return Default().GaugeBool(stat, value, rate)
 }
 
 // IncrementGauge increments the value of the gauge.
+//
+// The value is always sent with an explicit sign, so that it is
+// interpreted by the server as a change to the gauge rather than an
+// absolute value. In particular, a zero value is sent as "+0", which
+// leaves the gauge unchanged, whereas Gauge with a zero value sends
+// "0", which sets the gauge to zero. A negative value decrements
+// the gauge.
 func IncrementGauge(stat string, value int, rate float64) error {
-	return defaultClient.incrementGauge(stat, value, rate)
+	return Default().IncrementGauge(stat, value, rate)
 }
 
 // DecrementGauge decrements the value of the gauge.
+// It is equivalent to IncrementGauge with the value negated.
 func DecrementGauge(stat string, value int, rate float64) error {
-	return defaultClient.decrementGauge(stat, value, rate)
+	return Default().DecrementGauge(stat, value, rate)
 }
 
 // Unique records unique occurences of events.
+//
+// Sampling with a rate less than 1 means that values seen only a
+// few times may never be sent, so the server will undercount the
+// number of distinct values. The undercount cannot be corrected
+// by scaling with the rate.
 func Unique(stat string, value int, rate float64) error {
-	return defaultClient.unique(stat, value, rate)
+	return Default().Unique(stat, value, rate)
+}
+
+// UniqueString is like Unique but records a string value. The value
+// must not contain the characters '|', ':' or newline.
+func UniqueString(stat string, value string, rate float64) error {
+	return Default().UniqueString(stat, value, rate)
+}
+
+// UniqueValue is like Unique but accepts a value of any integer or
+// string type, or any value implementing fmt.Stringer. String values
+// are subject to the same restrictions as for UniqueString.
+func UniqueValue(stat string, value interface{}, rate float64) error {
+	return Default().UniqueValue(stat, value, rate)
+}
+
+// Histogram records a value in the histogram for the given bucket.
+func Histogram(stat string, value float64, rate float64) error {
+	return Default().Histogram(stat, value, rate)
 }
 
-// Flush writes any buffered data to the network.
+// SetSizeUnit sets the unit in which values passed to Size are sent.
+// The default is Bytes.
+func SetSizeUnit(unit SizeUnit) error {
+	return Default().SetSizeUnit(unit)
+}
+
+// Size records a size in bytes, such as a payload size, in the
+// histogram for the given bucket. The size is sent as a whole number
+// of the unit set with SetSizeUnit, rounded to the nearest unit.
+func Size(stat string, bytes int64, rate float64) error {
+	return Default().Size(stat, bytes, rate)
+}
+
+// Distribution records a value in the global distribution for the given
+// bucket. Distributions are supported by DogStatsD-compatible servers.
+func Distribution(stat string, value float64, rate float64) error {
+	return Default().Distribution(stat, value, rate)
+}
+
+// KeyValue records a raw key/value pair, sent as "stat:value|kv".
+// This metric type is supported by statsite but not by stock statsd,
+// which will reject it.
+func KeyValue(stat string, value float64) error {
+	return Default().KeyValue(stat, value)
+}
+
+// Send records a metric of an arbitrary kind, sent as
+// "stat:value|kind". It can be used for metric types that are supported
+// by a server but have no dedicated function in this package. Neither
+// kind nor value may contain the characters '|' or newline.
+func Send(stat string, value string, kind string, rate float64) error {
+	return Default().Send(stat, value, kind, rate)
+}
+
+// NewCounter returns a handle that can be used to update the counter
+// for the given bucket with the given sample rate. Using a handle is
+// more efficient than calling Increment repeatedly.
+func NewCounter(stat string, rate float64) *Counter {
+	return Default().NewCounter(stat, rate)
+}
+
+// NewTimer returns a handle that can be used to record durations
+// for the given bucket with the given sample rate.
+func NewTimer(stat string, rate float64) *Timer {
+	return Default().NewTimer(stat, rate)
+}
+
+// NewGaugeHandle returns a handle that can be used to set the value
+// of the given gauge with the given sample rate.
+func NewGaugeHandle(stat string, rate float64) *GaugeHandle {
+	return Default().NewGaugeHandle(stat, rate)
+}
+
+// NewMeter returns a meter that reports events for the given bucket
+// every interval. It returns an error if the interval is not positive.
+// The meter should be closed when it is no longer needed; it is also
+// closed when the client is closed.
+func NewMeter(stat string, interval time.Duration) (*Meter, error) {
+	return Default().NewMeter(stat, interval)
+}
+
+// IncrementTagged is like Increment but also sends the given tags
+// with the metric, in the format set with SetTagFormat. Characters that
+// cannot be sent are replaced or rejected as described for SetStrict.
+// The other Tagged functions behave similarly.
+func IncrementTagged(stat string, count int, rate float64, tags ...string) error {
+	return Default().Increment(stat, count, rate, tags...)
+}
+
+// DecrementTagged is like Decrement but also sends the given tags.
+func DecrementTagged(stat string, count int, rate float64, tags ...string) error {
+	return Default().Decrement(stat, count, rate, tags...)
+}
+
+// DurationTagged is like Duration but also sends the given tags.
+func DurationTagged(stat string, duration time.Duration, rate float64, tags ...string) error {
+	return Default().Duration(stat, duration, rate, tags...)
+}
+
+// TimingTagged is like Timing but also sends the given tags.
+func TimingTagged(stat string, delta int, rate float64, tags ...string) error {
+	return Default().Timing(stat, delta, rate, tags...)
+}
+
+// GaugeTagged is like Gauge but also sends the given tags.
+func GaugeTagged(stat string, value int, rate float64, tags ...string) error {
+	return Default().Gauge(stat, value, rate, tags...)
+}
+
+// GaugeFloat64Tagged is like GaugeFloat64 but also sends the given tags.
+func GaugeFloat64Tagged(stat string, value float64, rate float64, tags ...string) error {
+	return Default().GaugeFloat64(stat, value, rate, tags...)
+}
+
+// IncrementGaugeTagged is like IncrementGauge but also sends the given tags.
+func IncrementGaugeTagged(stat string, value int, rate float64, tags ...string) error {
+	return Default().IncrementGauge(stat, value, rate, tags...)
+}
+
+// DecrementGaugeTagged is like DecrementGauge but also sends the given tags.
+func DecrementGaugeTagged(stat string, value int, rate float64, tags ...string) error {
+	return Default().DecrementGauge(stat, value, rate, tags...)
+}
+
+// UniqueTagged is like Unique but also sends the given tags.
+func UniqueTagged(stat string, value int, rate float64, tags ...string) error {
+	return Default().Unique(stat, value, rate, tags...)
+}
+
+// HistogramTagged is like Histogram but also sends the given tags.
+func HistogramTagged(stat string, value float64, rate float64, tags ...string) error {
+	return Default().Histogram(stat, value, rate, tags...)
+}
+
+// DistributionTagged is like Distribution but also sends the given tags.
+func DistributionTagged(stat string, value float64, rate float64, tags ...string) error {
+	return Default().Distribution(stat, value, rate, tags...)
+}
+
+// Flush writes any buffered data to the network and returns any
+// error from the write. Nothing is written if no data is buffered.
 func Flush() error {
-	defaultClient.m.Lock()
-	defer defaultClient.m.Unlock()
-	return defaultClient.flush()
+	return Default().Flush()
+}
+
+// WaitFlushed waits until all metrics recorded by the package-level
+// functions before it was called have been written to the connection,
+// flushing the buffer and waiting for any queued metrics if needed.
+// Unlike Flush, it also waits for writes already started by other
+// goroutines. Errors are passed to the function set with SetErrorFunc.
+// It is mainly useful in tests and when shutting down.
+//
+// Metrics recorded by a single goroutine are always written in the
+// order in which they were recorded, both when metrics are buffered
+// and when they are queued with SetAsync.
+func WaitFlushed() {
+	Default().WaitFlushed()
+}
+
+// FlushContext is like Flush but gives up when ctx is done, returning
+// ctx.Err(), so that a wedged connection cannot delay a shutdown
+// indefinitely. If ctx is done before the buffered metrics are
+// written, they are discarded or kept according to the overflow
+// policy set with SetOverflowPolicy, as if writing them had failed.
+// A write in progress is abandoned by setting a deadline on the
+// connection; when it does not support deadlines, as may be the case
+// for a writer passed to NewClientWriter, FlushContext waits for the
+// write to finish.
+func FlushContext(ctx context.Context) error {
+	return Default().FlushContext(ctx)
+}
+
+// Close flushes any metrics buffered by the package-level functions
+// and closes the connection, for example when the program shuts down.
+// It returns any error from flushing or closing. It may be called
+// before SetAddr, in which case it returns nil: metrics recorded
+// before SetAddr are discarded, so there is nothing to flush.
+// Metrics recorded by the package-level functions after Close are
+// also discarded, unless SetDefault is used to replace the closed
+// client.
+func Close() error {
+	return Default().Close()
 }
@@ -1,8 +1,11 @@
 package statsd
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -48,7 +51,8 @@ func TestSetAddr(t *testing.T) {
 	go func() {
 		n, _, err := ln.ReadFrom(out)
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 		out = out[:n]
 		close(ch)
@@ -72,7 +76,57 @@ func TestSetAddr(t *testing.T) {
 	}
 }
 
+func TestSetAddrFlushes(t *testing.T) {
+	var lns [2]net.PacketConn
+	for i := range lns {
+		ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+		lns[i] = ln
+	}
+	cl, err := NewClient(lns[0].LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	// Metrics buffered before the address changes
+	// are sent to the old address.
+	cl.Increment("old", 1, 1)
+	if err := cl.SetAddr(lns[1].LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("new", 1, 1)
+	cl.Flush()
+
+	out := make([]byte, 512)
+	for i, want := range []string{"old:1|c", "new:1|c"} {
+		n, _, err := lns[i].ReadFrom(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, string(out[:n]), want)
+	}
+}
+
 func TestReconnect(t *testing.T) {
+	t.Run("connected", func(t *testing.T) {
+		testReconnect(t, false)
+	})
+	t.Run("unconnected", func(t *testing.T) {
+		testReconnect(t, true)
+	})
+}
+
+func testReconnect(t *testing.T, unconnected bool) {
+	if err := SetUnconnected(unconnected); err != nil {
+		t.Fatal(err)
+	}
+	defer SetUnconnected(false)
+
 	// Acquire a port and close it, just to get an unused port.
 	ln, err := net.ListenPacket("udp", ":0")
 	if err != nil {
@@ -102,11 +156,19 @@ func TestReconnect(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Metrics get dropped on the floor.
+	// Metrics get dropped on the floor. An unconnected
+	// socket does not see the errors caused by nothing
+	// listening.
 	for i := 0; i < 1000; i++ {
-		Gauge("novelty", i, 1)
+		err := Gauge("novelty", i, 1)
+		if err != nil && unconnected {
+			t.Fatal(err)
+		}
+	}
+	err = Flush()
+	if err != nil && unconnected {
+		t.Fatal(err)
 	}
-	Flush()
 
 	// Now start a server that is listening on the configured port.
 	ln, err = net.ListenPacket("udp", fmt.Sprintf(":%d", port))
@@ -120,7 +182,8 @@ func TestReconnect(t *testing.T) {
 	go func() {
 		n, _, err := ln.ReadFrom(out)
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 		out = out[:n]
 		close(ch)
@@ -143,3 +206,444 @@ func TestReconnect(t *testing.T) {
 		t.Fatalf("unexpected output: %q", string(out))
 	}
 }
+
+func TestUnconnected(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// Both modes send the same packets.
+	for _, unconnected := range []bool{false, true} {
+		cl, err := NewClient(ln.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.SetUnconnected(unconnected); err != nil {
+			t.Fatal(err)
+		}
+		cl.Increment("incr", 1, 1)
+		cl.Gauge("gauge", 2, 1, "tag:x")
+		if err := cl.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+		out := make([]byte, 512)
+		n, _, err := ln.ReadFrom(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(out[:n]); got != "incr:1|c\ngauge:2|g|#tag:x" {
+			t.Fatalf("unexpected output with unconnected %v: %q", unconnected, got)
+		}
+	}
+}
+
+func TestPackageLevelMatchesClient(t *testing.T) {
+	send := func(increment func(string, int, float64) error, gauge func(string, int, float64) error, timing func(string, int, float64) error, histogram func(string, float64, float64) error) {
+		for i := 0; i < 50; i++ {
+			increment("incr", i, 1)
+			gauge("gauge", i-25, 1)
+			timing("timing", i, 0.75)
+			histogram("histogram", float64(i)/3, 1)
+		}
+	}
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	WithRandSource(halfSource{})(cl)
+	cl.c.setNegativeGaugeReset(true)
+	send(
+		func(stat string, count int, rate float64) error { return cl.Increment(stat, count, rate) },
+		func(stat string, value int, rate float64) error { return cl.Gauge(stat, value, rate) },
+		func(stat string, delta int, rate float64) error { return cl.Timing(stat, delta, rate) },
+		func(stat string, value float64, rate float64) error { return cl.Histogram(stat, value, rate) },
+	)
+	cl.Close()
+
+	defer SetDefault(Default())
+	var defaultPackets []string
+	defaultCl := NewClientWriter(packetWriter{&defaultPackets}, 0)
+	WithRandSource(halfSource{})(defaultCl)
+	SetDefault(defaultCl)
+	SetNegativeGaugeReset(true)
+	send(Increment, Gauge, Timing, Histogram)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != len(defaultPackets) {
+		t.Fatalf("got %d packets from the package-level functions, want %d", len(defaultPackets), len(packets))
+	}
+	for i := range packets {
+		assert(t, defaultPackets[i], packets[i])
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	defer SetDefault(Default())
+	var oldPackets []string
+	SetDefault(NewClientWriter(packetWriter{&oldPackets}, 0))
+	Increment("old", 1, 1)
+
+	// The previous client is flushed when it is replaced.
+	var packets []string
+	cl, err := NewClientWriter(packetWriter{&packets}, 0).Clone(WithPrefix("app."), WithTags("env:prod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefault(cl)
+	assert(t, strings.Join(oldPackets, " "), "old:1|c")
+	if Default() != cl {
+		t.Fatal("Default did not return the client set with SetDefault")
+	}
+
+	Increment("incr", 1, 1)
+	IncrementTagged("incr", 2, 1, "x:y")
+	IncrementBytes([]byte("bytes"), 3, 1)
+	Gauge64("gauge", 4, 1)
+	NewCounter("counter", 1).Add(5)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, " "), strings.Join([]string{
+		"app.incr:1|c|#env:prod",
+		"app.incr:2|c|#env:prod,x:y",
+		"app.bytes:3|c|#env:prod",
+		"app.gauge:4|g|#env:prod",
+		"app.counter:5|c|#env:prod",
+	}, "\n"))
+	assert(t, Prefix(), "app.")
+
+	// Resetting the default leaves a client with no address.
+	SetDefault(nil)
+	if Default() == cl || Addr() != "" {
+		t.Fatal("default client not reset")
+	}
+}
+
+func TestSetDefaultTags(t *testing.T) {
+	defer SetDefault(Default())
+	var packets []string
+	SetDefault(NewClientWriter(packetWriter{&packets}, 0).WithTags("env:prod"))
+
+	Increment64("incr64", 1, 1)
+	IncrementFloat("incrfloat", 0.5, 1)
+	GaugeValue("value", uint8(2), 1)
+	GaugeBool("bool", true, 1)
+	UniqueString("unique", "x", 1)
+	UniqueValue("uniquevalue", 3, 1)
+	KeyValue("kv", 4)
+	Send("raw", "5", "c", 1)
+	Durations("durations", []time.Duration{6 * time.Millisecond, 7 * time.Millisecond}, 1)
+	DurationN("durationn", 8*time.Millisecond, 2, 1)
+	Size("size", 9, 1)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, " "), strings.Join([]string{
+		"incr64:1|c|#env:prod",
+		"incrfloat:0.5|c|#env:prod",
+		"value:2|g|#env:prod",
+		"bool:1|g|#env:prod",
+		"unique:x|s|#env:prod",
+		"uniquevalue:3|s|#env:prod",
+		"kv:4|kv|#env:prod",
+		"raw:5|c|#env:prod",
+		"durations:6|ms|#env:prod",
+		"durations:7|ms|#env:prod",
+		"durationn:8|ms|@0.5|#env:prod",
+		"size:9|h|#env:prod",
+	}, "\n"))
+}
+
+func TestPackageLevelClose(t *testing.T) {
+	defer SetDefault(Default())
+
+	// Close may be called on an unconfigured client. Metrics
+	// recorded before SetAddr are discarded, so it succeeds.
+	SetDefault(nil)
+	Increment("incr", 1, 1)
+	if err := Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []string
+	closed := 0
+	SetDefault(NewClientWriter(closeCountWriter{packetWriter{&packets}, &closed}, 0))
+	SetPrefix("app.")
+	Increment("incr", 1, 1)
+	if err := Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, " "), "app.incr:1|c")
+	if closed != 1 {
+		t.Fatalf("connection closed %d times, want 1", closed)
+	}
+}
+
+func TestPackageLevelErrorFunc(t *testing.T) {
+	defer SetDefault(Default())
+	writes := 0
+	SetDefault(NewClientWriter(errWriter{&writes}, 0))
+	errs := make(chan error, 1)
+	SetErrorFunc(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	// Errors from background flushes are passed to the error function.
+	if err := SetFlushInterval(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer SetFlushInterval(0)
+	Increment("incr", 1, 1)
+	select {
+	case err := <-errs:
+		assert(t, err.Error(), "write failed")
+	case <-time.After(3 * time.Second):
+		t.Fatal("no error reported")
+	}
+}
+
+func TestDefaultBeforeSetAddr(t *testing.T) {
+	defer SetDefault(Default())
+	SetDefault(nil)
+
+	// Metrics are discarded without error before an address is set.
+	for i := 0; i < 1000; i++ {
+		if err := Increment("before", 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := Stats().Recorded; got != 0 {
+		t.Fatalf("got %d recorded metrics, want 0", got)
+	}
+	if SetAddr("udp://[::1") == nil {
+		t.Fatal("expected error for invalid address")
+	}
+	if err := Increment("before", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := SetAddr(ln.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	Increment("after", 1, 1)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "after:1|c")
+}
+
+func TestDefaultSetAddrConcurrent(t *testing.T) {
+	defer SetDefault(Default())
+	SetDefault(nil)
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := Increment("incr", 1, 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if err := SetAddr(ln.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	close(stop)
+	wg.Wait()
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if Stats().Recorded == 0 {
+		t.Fatal("no metrics recorded after the address was set")
+	}
+}
+
+func TestSetAddrFlushInterval(t *testing.T) {
+	defer SetDefault(Default())
+	SetDefault(nil)
+	clock := newFakeClock()
+	Default().c.clock = clock
+	listen := func() net.PacketConn {
+		ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return ln
+	}
+	read := func(ln net.PacketConn) string {
+		out := make([]byte, 512)
+		n, _, err := ln.ReadFrom(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out[:n])
+	}
+	ln1 := listen()
+	defer ln1.Close()
+	if err := SetAddr(ln1.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer Close()
+	if got := FlushInterval(); got != defaultFlushInterval {
+		t.Fatalf("got flush interval %v, want %v", got, defaultFlushInterval)
+	}
+	// Metrics arrive without a call to Flush, also
+	// after the address changes.
+	Increment("one", 1, 1)
+	clock.waitTimers(t, 1)
+	clock.Advance(defaultFlushInterval)
+	assert(t, read(ln1), "one:1|c")
+	ln2 := listen()
+	defer ln2.Close()
+	if err := SetAddr(ln2.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	Increment("two", 1, 1)
+	clock.waitTimers(t, 1)
+	clock.Advance(defaultFlushInterval)
+	assert(t, read(ln2), "two:1|c")
+
+	// An interval set explicitly is kept.
+	SetDefault(nil)
+	if err := SetFlushInterval(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAddr(ln1.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if got := FlushInterval(); got != 0 {
+		t.Fatalf("got flush interval %v, want 0", got)
+	}
+}
+
+func TestDecrementBothSurfaces(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	cl.Decrement("stat", 5, 1)
+	cl.DecrementGauge("stat", 4, 1)
+	cl.Flush()
+
+	defer SetDefault(Default())
+	var defaultPackets []string
+	SetDefault(NewClientWriter(packetWriter{&defaultPackets}, 0))
+	Decrement("stat", 5, 1)
+	DecrementGauge("stat", 4, 1)
+	Flush()
+
+	for _, got := range [][]string{packets, defaultPackets} {
+		if len(got) != 1 {
+			t.Fatalf("got packets %q, want 1", got)
+		}
+		assert(t, got[0], "stat:-5|c\nstat:-4|g")
+	}
+}
+
+func TestClientTime(t *testing.T) {
+	var packets []string
+	clock := newFakeClock()
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	WithClock(clock)(cl)
+	cl = cl.WithPrefix("app.").WithTags("env:test")
+
+	d, err := cl.TimeDuration("duration", 1, func() {
+		clock.Advance(20 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 20*time.Millisecond {
+		t.Fatalf("got duration %v, want 20ms", d)
+	}
+	cl.Time("time", 1, func() {
+		clock.Advance(30 * time.Millisecond)
+	}, "op:read")
+	ferr := errors.New("failed")
+	if err := cl.TimeErr("err", 1, func() error {
+		clock.Advance(40 * time.Millisecond)
+		return ferr
+	}); err != ferr {
+		t.Fatalf("got error %v, want %v", err, ferr)
+	}
+	cl.DurationSince("since", clock.Now().Add(-time.Second), 1)
+	cl.Flush()
+
+	if len(packets) != 1 {
+		t.Fatalf("got packets %q, want 1", packets)
+	}
+	assert(t, packets[0], "app.duration:20|ms|#env:test\napp.time:30|ms|#env:test,op:read\napp.err:40|ms|#env:test\napp.since:1000|ms|#env:test")
+}
+
+func TestPackageLevelGlobalTags(t *testing.T) {
+	defer SetDefault(Default())
+	SetDefault(nil)
+
+	// Tags can be configured before an address is set, and
+	// metrics sent meanwhile are discarded.
+	if err := SetGlobalTags("env:test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTagFormat(TagFormatInflux); err != nil {
+		t.Fatal(err)
+	}
+	if err := IncrementTagged("before", 1, 1, "op:read"); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := SetAddr(ln.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	Increment("plain", 1, 1)
+	IncrementTagged("tagged", 2, 1, "op:read")
+	GaugeTagged("gauge", 3, 1, "op:write")
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "plain,env=test:1|c\ntagged,env=test,op=read:2|c\ngauge,env=test,op=write:3|g")
+}
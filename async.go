@@ -0,0 +1,161 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// asyncQueue holds metrics waiting to be added to the buffer
+// by the background goroutine started by setAsync.
+type asyncQueue struct {
+	records chan metricRecord
+	done    chan struct{}
+
+	// mu guards stopped, which holds whether the queue has been
+	// stopped. Metrics are sent on records with mu held for
+	// reading, so that none can be sent after the queue is stopped.
+	mu      sync.RWMutex
+	stopped bool
+}
+
+// setAsync makes the client queue metrics to be sent in the
+// background. See SetAsync for details.
+func (c *client) setAsync(queueLen int) error {
+	if queueLen < 0 {
+		return fmt.Errorf("invalid queue length %d", queueLen)
+	}
+	c.stopAsync()
+	if queueLen == 0 {
+		return nil
+	}
+	q := &asyncQueue{
+		records: make(chan metricRecord, queueLen),
+		done:    make(chan struct{}),
+	}
+	go c.processQueue(q)
+	c.async.Store(q)
+	return nil
+}
+
+// stopAsync makes the client synchronous again after adding all
+// queued metrics to the buffer. Caller must not hold the client mutex
+// lock.
+func (c *client) stopAsync() {
+	q := c.async.Swap(nil)
+	if q == nil {
+		return
+	}
+	// A concurrent enqueue may have loaded q before it was
+	// swapped out, so mark it stopped to make such callers drop
+	// their metrics rather than queue them after the final
+	// record. Closing the channel could make them panic, so send
+	// a record telling the goroutine to stop instead.
+	q.mu.Lock()
+	q.stopped = true
+	q.mu.Unlock()
+	q.records <- metricRecord{op: opSync}
+	<-q.done
+}
+
+// syncAsync waits until all metrics queued so far have been
+// added to the buffer. Caller must not hold the client mutex lock.
+func (c *client) syncAsync() {
+	c.syncAsyncContext(context.Background())
+}
+
+// syncAsyncContext is like syncAsync but returns ctx.Err() if ctx is
+// done first. Caller must not hold the client mutex lock.
+func (c *client) syncAsyncContext(ctx context.Context) error {
+	q := c.async.Load()
+	if q == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	select {
+	case q.records <- metricRecord{op: opSync, done: done}:
+	case <-q.done:
+		// The queue was stopped concurrently.
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+	case <-q.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// enqueue queues r to be added to the buffer, dropping it if the
+// queue has been stopped, or if it is full unless the overflow policy
+// is Block.
+func (c *client) enqueue(q *asyncQueue, r metricRecord) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.stopped {
+		c.dropped.Add(1)
+		return nil
+	}
+	if c.blockWhenFull.Load() {
+		select {
+		case q.records <- r:
+		case <-q.done:
+			// The queue was stopped concurrently.
+			c.dropped.Add(1)
+		}
+		return nil
+	}
+	select {
+	case q.records <- r:
+	default:
+		c.dropped.Add(1)
+	}
+	return nil
+}
+
+// processQueue adds the metrics queued in q to the buffer until
+// it receives an opSync record without a done channel. Metrics
+// that are queued together are added under a single lock, and the
+// packets that they fill are written together by flushBatch.
+func (c *client) processQueue(q *asyncQueue) {
+	defer close(q.done)
+	var errs []error
+	for r := range q.records {
+		c.m.Lock()
+		c.batching = true
+		for n := 0; r.op != opSync; n++ {
+			if err := c.appendRecord(&r); err != nil {
+				errs = append(errs, err)
+			}
+			if n == cap(q.records) {
+				break
+			}
+			select {
+			case r = <-q.records:
+				continue
+			default:
+			}
+			break
+		}
+		c.batching = false
+		if err := c.flushBatch(); err != nil {
+			errs = append(errs, err)
+		}
+		c.m.Unlock()
+
+		for _, err := range errs {
+			c.reportError(err)
+		}
+		errs = errs[:0]
+		if r.op == opSync {
+			if r.done == nil {
+				return
+			}
+			close(r.done)
+		}
+	}
+}
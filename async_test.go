@@ -0,0 +1,247 @@
+package statsd
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsync(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	if err := cl.SetAsync(16); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("incr", 1, 1)
+	cl.Gauge("gauge", -2, 1)
+	cl.Timing("timing", 3, 1)
+	cl.c.incrementBytes("", []byte("bytes"), 4, 1)
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), "incr:1|c\ngauge:-2|g\ntiming:3|ms\nbytes:4|c")
+}
+
+func TestAsyncFlush(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	if err := cl.SetAsync(16); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	cl.Increment("incr", 1, 1)
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), "incr:1|c")
+}
+
+func TestAsyncDrops(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	if err := cl.SetAsync(2); err != nil {
+		t.Fatal(err)
+	}
+	q := cl.c.async.Load()
+
+	// Stall the consumer by holding the client lock
+	// while it processes the first metric.
+	cl.c.m.Lock()
+	cl.Increment("a", 1, 1)
+	for len(q.records) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, stat := range []string{"b", "c", "d", "e", "f"} {
+		if err := cl.Increment(stat, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cl.c.m.Unlock()
+
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), "a:1|c\nb:1|c\nc:1|c")
+	if got := cl.Stats().Dropped; got != 3 {
+		t.Fatalf("got %d dropped metrics, want 3", got)
+	}
+}
+
+func TestAsyncStoppedQueue(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	if err := cl.SetAsync(10); err != nil {
+		t.Fatal(err)
+	}
+	// A metric queued by a caller that loaded the queue
+	// before it was stopped is counted as dropped.
+	q := cl.c.async.Load()
+	cl.c.stopAsync()
+	r := metricRecord{op: opCounter, m: Metric{Stat: "late", Value: "1", Kind: "c", Rate: 1}}
+	if err := cl.c.enqueue(q, r); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(q.records); n != 0 {
+		t.Fatalf("got %d queued records, want 0", n)
+	}
+	if got := cl.Stats().Dropped; got != 1 {
+		t.Fatalf("got %d dropped metrics, want 1", got)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), "")
+}
+
+func TestAsyncError(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	var (
+		mu   sync.Mutex
+		errs []string
+	)
+	cl.SetErrorFunc(func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err.Error())
+	})
+	cl.SetStrict(true)
+	if err := cl.SetAsync(16); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Increment("bad|stat", 1, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cl.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 || !strings.Contains(errs[0], "bad|stat") {
+		t.Fatalf("unexpected errors %q", errs)
+	}
+}
+
+func TestSetAsyncDisable(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	if err := cl.SetAsync(16); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("async", 1, 1)
+	if err := cl.SetAsync(0); err != nil {
+		t.Fatal(err)
+	}
+	if cl.c.async.Load() != nil {
+		t.Fatal("client is still asynchronous")
+	}
+	cl.Increment("sync", 1, 1)
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), "async:1|c\nsync:1|c")
+
+	if err := cl.SetAsync(-1); err == nil {
+		t.Fatal("expected error for negative queue length")
+	}
+}
+
+func BenchmarkIncrementSync(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.increment("requests.total", 1, 1)
+		}
+	})
+}
+
+func BenchmarkIncrementAsync(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	c.setAsync(1024)
+	defer c.close()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.increment("requests.total", 1, 1)
+		}
+	})
+	b.ReportMetric(float64(c.dropped.Load())/float64(b.N), "drops/op")
+}
+
+func TestAsyncBatch(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 10)
+	if err := cl.SetAsync(100); err != nil {
+		t.Fatal(err)
+	}
+	// Stall the consumer so that the metrics are processed
+	// together; the packets must be the same as those
+	// written synchronously.
+	cl.c.m.Lock()
+	for _, stat := range []string{"a", "b", "c", "d", "e"} {
+		cl.Increment(stat, 1, 1)
+	}
+	cl.c.m.Unlock()
+	cl.Increment("f", 1, 1)
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), "a:1|c|b:1|c|c:1|c|d:1|c|e:1|c|f:1|c")
+}
+
+func TestAsyncOrder(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	if err := cl.SetAsync(16); err != nil {
+		t.Fatal(err)
+	}
+	ctr := cl.NewCounter("counter", 1)
+	timer := cl.NewTimer("timer", 1)
+	gauge := cl.NewGaugeHandle("handle", 1)
+
+	// While the client lock is held, metrics can only be queued,
+	// so recording them all without blocking shows that none
+	// are added to the buffer directly, ahead of those queued
+	// before them.
+	cl.c.m.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cl.Increment("incr", 1, 1)
+		ctr.Add(2)
+		timer.Observe(3 * time.Millisecond)
+		gauge.Set(4)
+		cl.Durations("durations", []time.Duration{5 * time.Millisecond, 6 * time.Millisecond}, 1)
+		cl.DurationN("durationn", 7*time.Millisecond, 1, 1)
+		cl.Size("size", 8, 1)
+		cl.Increment("incr", 9, 1)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("metrics not queued")
+	}
+	cl.c.m.Unlock()
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), strings.Join([]string{
+		"incr:1|c",
+		"counter:2|c",
+		"timer:3|ms",
+		"handle:4|g",
+		"durations:5|ms",
+		"durations:6|ms",
+		"durationn:7|ms",
+		"size:8|h",
+		"incr:9|c",
+	}, "\n"))
+}
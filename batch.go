@@ -0,0 +1,45 @@
+package statsd
+
+// addToBatch adds a copy of packet to the packets to be written
+// by flushBatch. Caller must hold the client mutex lock.
+func (c *client) addToBatch(packet []byte) {
+	n := len(c.batch)
+	if n < cap(c.batch) {
+		// Reuse the memory of an earlier batch.
+		c.batch = c.batch[:n+1]
+		c.batch[n] = append(c.batch[n][:0], packet...)
+		return
+	}
+	c.batch = append(c.batch, append([]byte(nil), packet...))
+}
+
+// flushBatch writes the packets added by addToBatch. Where supported,
+// datagram packets are written with a single system call; otherwise,
+// or if that fails, they are written one at a time as flush would.
+// Packets kept by the DropOldest policy are written first, so such
+// a client always writes one packet at a time. Caller must hold the
+// client mutex lock.
+func (c *client) flushBatch() error {
+	packets := c.batch
+	c.batch = c.batch[:0]
+	if len(packets) > 1 && !c.stream && c.spool == nil && c.overflow == nil && c.pacer == nil && c.reconnect == nil && c.conn != nil {
+		c.setWriteDeadline()
+		c.sendMu.Lock()
+		n := writeBatch(c.conn, packets)
+		c.sendMu.Unlock()
+		if n > 0 && c.failover != nil {
+			c.failover.record(c, nil)
+		}
+		for _, packet := range packets[:n] {
+			c.packetSent(packet)
+		}
+		packets = packets[n:]
+	}
+	var err error
+	for _, packet := range packets {
+		if perr := c.sendPacket(packet); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
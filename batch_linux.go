@@ -0,0 +1,63 @@
+//go:build amd64 || arm64
+
+package statsd
+
+import (
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+// mmsghdr mirrors struct mmsghdr as used by sendmmsg(2).
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+	_   [4]byte
+}
+
+// writeBatch writes packets to conn as separate datagrams with
+// sendmmsg(2), and returns how many were written. It returns zero
+// if conn is not a socket or if the first packet cannot be written.
+func writeBatch(conn io.WriteCloser, packets [][]byte) int {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	iovs := make([]syscall.Iovec, len(packets))
+	hdrs := make([]mmsghdr, len(packets))
+	for i, packet := range packets {
+		if len(packet) > 0 {
+			// An empty packet is sent as an empty
+			// datagram, as conn.Write would send it.
+			iovs[i].Base = &packet[0]
+		}
+		iovs[i].SetLen(len(packet))
+		hdrs[i].hdr.Iov = &iovs[i]
+		hdrs[i].hdr.Iovlen = 1
+	}
+	sent := 0
+	raw.Write(func(fd uintptr) bool {
+		for sent < len(hdrs) {
+			n, _, errno := syscall.Syscall6(sysSendmmsg, fd, uintptr(unsafe.Pointer(&hdrs[sent])), uintptr(len(hdrs)-sent), 0, 0, 0)
+			switch errno {
+			case 0:
+				sent += int(n)
+			case syscall.EINTR:
+			case syscall.EAGAIN:
+				// Wait until the socket is writable.
+				return false
+			default:
+				// Leave the remaining packets to be
+				// written, and the error reported, by
+				// the caller.
+				return true
+			}
+		}
+		return true
+	})
+	return sent
+}
@@ -0,0 +1,5 @@
+package statsd
+
+// sysSendmmsg is the number of the sendmmsg system call, which the
+// syscall package does not define on amd64.
+const sysSendmmsg = 307
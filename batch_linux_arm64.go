@@ -0,0 +1,5 @@
+package statsd
+
+import "syscall"
+
+const sysSendmmsg = syscall.SYS_SENDMMSG
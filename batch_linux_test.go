@@ -0,0 +1,175 @@
+//go:build amd64 || arm64
+
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func newUDPPair(t testing.TB) (server net.PacketConn, client net.Conn) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestWriteBatch(t *testing.T) {
+	server, conn := newUDPPair(t)
+	defer server.Close()
+	defer conn.Close()
+
+	packets := [][]byte{[]byte("a:1|c"), []byte("b:2|c\nc:3|c"), []byte("d:4|c")}
+	if n := writeBatch(conn, packets); n != len(packets) {
+		t.Fatalf("wrote %d packets, want %d", n, len(packets))
+	}
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for _, want := range packets {
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, string(buf[:n]), string(want))
+	}
+}
+
+func TestWriteBatchEmptyPacket(t *testing.T) {
+	server, conn := newUDPPair(t)
+	defer server.Close()
+	defer conn.Close()
+
+	packets := [][]byte{[]byte("a:1|c"), nil, []byte("b:2|c")}
+	if n := writeBatch(conn, packets); n != len(packets) {
+		t.Fatalf("wrote %d packets, want %d", n, len(packets))
+	}
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for _, want := range packets {
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, string(buf[:n]), string(want))
+	}
+}
+
+func TestWriteBatchNotSocket(t *testing.T) {
+	var packets []string
+	if n := writeBatch(writerConn{packetWriter{&packets}}, [][]byte{[]byte("a:1|c")}); n != 0 {
+		t.Fatalf("wrote %d packets, want 0", n)
+	}
+}
+
+func TestAsyncUDPBatch(t *testing.T) {
+	server, _ := newUDPPair(t)
+	defer server.Close()
+	cl, err := NewClient(server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.SetPacketSize(10)
+	if err := cl.SetAsync(100); err != nil {
+		t.Fatal(err)
+	}
+	// Stall the consumer so that the metrics are
+	// processed, and their packets written, together.
+	cl.c.m.Lock()
+	for i := 0; i < 10; i++ {
+		cl.Increment(fmt.Sprint("s", i), 1, 1)
+	}
+	cl.c.m.Unlock()
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, string(buf[:n]), fmt.Sprintf("s%d:1|c", i))
+	}
+}
+
+func TestAsyncUDPBatchDropOldest(t *testing.T) {
+	server, _ := newUDPPair(t)
+	defer server.Close()
+	cl, err := NewClient(server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.SetPacketSize(10)
+	if err := cl.SetOverflowPolicy(DropOldest, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.SetAsync(100); err != nil {
+		t.Fatal(err)
+	}
+	// Keep a packet as if an earlier write had failed, and stall
+	// the consumer so that later packets are written together.
+	cl.c.m.Lock()
+	cl.c.overflow.add(cl.c, []byte("old:1|c"))
+	for i := 0; i < 10; i++ {
+		cl.Increment(fmt.Sprint("s", i), 1, 1)
+	}
+	cl.c.m.Unlock()
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	// The kept packet is written before the newer ones.
+	for i := -1; i < 10; i++ {
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("s%d:1|c", i)
+		if i < 0 {
+			want = "old:1|c"
+		}
+		assert(t, string(buf[:n]), want)
+	}
+}
+
+var benchPackets = func() [][]byte {
+	packets := make([][]byte, 32)
+	for i := range packets {
+		packets[i] = []byte(fmt.Sprintf("requests.total:%d|c", i))
+	}
+	return packets
+}()
+
+// BenchmarkWritePackets and BenchmarkWriteBatch compare writing
+// 32 packets with one system call each and with a single sendmmsg.
+func BenchmarkWritePackets(b *testing.B) {
+	server, conn := newUDPPair(b)
+	defer server.Close()
+	defer conn.Close()
+	for i := 0; i < b.N; i++ {
+		for _, packet := range benchPackets {
+			conn.Write(packet)
+		}
+	}
+	b.ReportMetric(float64(len(benchPackets)), "syscalls/op")
+}
+
+func BenchmarkWriteBatch(b *testing.B) {
+	server, conn := newUDPPair(b)
+	defer server.Close()
+	defer conn.Close()
+	for i := 0; i < b.N; i++ {
+		writeBatch(conn, benchPackets)
+	}
+	b.ReportMetric(1, "syscalls/op")
+}
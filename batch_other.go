@@ -0,0 +1,11 @@
+//go:build !linux || !(amd64 || arm64)
+
+package statsd
+
+import "io"
+
+// writeBatch returns zero, as packets cannot be written
+// together on this platform.
+func writeBatch(conn io.WriteCloser, packets [][]byte) int {
+	return 0
+}
@@ -0,0 +1,34 @@
+package statsd
+
+import "fmt"
+
+// callErrorFunc calls the error function f with err, recovering from
+// any panic so that a faulty error function cannot crash the goroutine
+// that reported the error. Caller must not hold the client mutex lock.
+func (c *client) callErrorFunc(f func(error), err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			// The error function cannot be told about
+			// its own panic, so it is only counted.
+			c.callbackPanics.Add(1)
+		}
+	}()
+	f(err)
+}
+
+// callFlushFunc calls the flush function with the size of a packet and
+// the number of metrics in it, recovering from any panic. The first
+// panic is reported to the error function. Caller must hold the client
+// mutex lock.
+func (c *client) callFlushFunc(bytes, metrics int) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.callbackPanics.Add(1)
+			if !c.flushFuncPanicked {
+				c.flushFuncPanicked = true
+				c.reportErrorAsync(fmt.Errorf("flush function panicked: %v", r))
+			}
+		}
+	}()
+	c.flushFunc(bytes, metrics)
+}
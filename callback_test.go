@@ -0,0 +1,61 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorFuncPanic(t *testing.T) {
+	writes := 0
+	cl := NewClientWriter(errWriter{&writes}, 0)
+	defer cl.Close()
+	cl.SetUnbuffered(true)
+	if err := cl.SetAsync(10); err != nil {
+		t.Fatal(err)
+	}
+	cl.SetErrorFunc(func(err error) {
+		panic(err)
+	})
+	// The write errors are reported by the goroutine
+	// that sends the queued metrics, which survives.
+	cl.Increment("a", 1, 1)
+	cl.Flush()
+	cl.Increment("b", 1, 1)
+	cl.Flush()
+	if got := cl.Stats().CallbackPanics; got != 2 {
+		t.Fatalf("got %d callback panics, want 2", got)
+	}
+}
+
+func TestFlushFuncPanic(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	defer cl.Close()
+	errs := make(chan error, 10)
+	cl.SetErrorFunc(func(err error) {
+		errs <- err
+	})
+	cl.SetFlushFunc(func(bytes, metrics int) {
+		panic("boom")
+	})
+	cl.Increment("a", 1, 1)
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("b", 1, 1)
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, " "), "a:1|c b:1|c")
+	if got := cl.Stats().CallbackPanics; got != 2 {
+		t.Fatalf("got %d callback panics, want 2", got)
+	}
+	// Only the first panic is reported.
+	assert(t, (<-errs).Error(), "flush function panicked: boom")
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
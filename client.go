@@ -0,0 +1,724 @@
+package statsd
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Client sends metrics to a statsd server. Metrics are buffered and
+// sent in packets; call Flush to send any metrics still buffered.
+//
+// A Client may have a prefix, prepended to every bucket name, and
+// default tags, sent with every metric before any tags given in the
+// call. Clients derived with WithTags, WithPrefix or Clone share the
+// connection, buffer and settings of the client they were derived
+// from, so metrics from all of them are sent in the same packets.
+//
+// A Client may be used concurrently by multiple goroutines. Metrics
+// recorded by a single goroutine are sent in the order in which they
+// were recorded.
+type Client struct {
+	c *client
+
+	// derived holds whether the client was created by
+	// WithTags, WithPrefix or Clone.
+	derived bool
+
+	prefix string
+	tags   []string
+
+	// rate holds the sample rate set by WithDefaultRate,
+	// or zero if there is none.
+	rate float64
+}
+
+// NewClient returns a client that sends metrics to the given network
+// address, which is interpreted as described for SetAddr. It is
+// equivalent to New with no options.
+func NewClient(addr string) (*Client, error) {
+	return New(addr)
+}
+
+// NewClientWriter returns a client that writes each packet of metrics
+// to w, with at most packetSize bytes in each, rather than sending them
+// over the network. This is useful for writing metrics to a file, a pipe
+// or a buffer in tests. The client serializes its calls to w.Write, so w
+// need not be safe for concurrent use. Close closes w if it implements
+// io.Closer. A packetSize of zero or less means the default of 512.
+func NewClientWriter(w io.Writer, packetSize int) *Client {
+	if packetSize <= 0 {
+		packetSize = defaultBufSize
+	}
+	c := newClient()
+	c.size = packetSize
+	c.conn = writerConn{w}
+	c.writer = true
+	return &Client{c: c}
+}
+
+// writerConn adapts an io.Writer for use as a client connection.
+type writerConn struct {
+	io.Writer
+}
+
+func (w writerConn) Close() error {
+	if c, ok := w.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// WithTags returns a client that sends metrics through cl but adds the
+// given tags to every metric, after any default tags of cl. The tags
+// are checked when metrics are sent.
+func (cl *Client) WithTags(tags ...string) *Client {
+	cl1 := *cl
+	cl1.derived = true
+	cl1.tags = append(cl.tags[:len(cl.tags):len(cl.tags)], tags...)
+	return &cl1
+}
+
+// WithPrefix returns a client that sends metrics through cl but adds
+// prefix to the start of every bucket name, after any prefix of cl.
+// For example, cl.WithPrefix("api.").Increment("requests", 1, 1)
+// increments the "api.requests" counter.
+func (cl *Client) WithPrefix(prefix string) *Client {
+	cl1 := *cl
+	cl1.derived = true
+	cl1.prefix = cl.prefix + cl.c.sepPrefix(prefix)
+	return &cl1
+}
+
+// Clone returns a client that sends metrics through cl, sharing its
+// connection, buffer, settings and statistics, after applying the
+// given options to it in order. Only the options that apply to a
+// single client may be given: WithPrefix, WithPrefixTemplate, WithTags
+// and WithDefaultRate. The others change settings shared with cl, such
+// as the error function, and Clone returns an error for them; use the
+// corresponding methods to change those settings.
+//
+// As for clients returned by WithTags and WithPrefix, closing the
+// returned client only flushes it, and once cl is closed, metrics sent
+// through the returned client are discarded.
+func (cl *Client) Clone(opts ...Option) (*Client, error) {
+	cl1 := *cl
+	cl1.derived = true
+	for _, opt := range opts {
+		if err := opt(&cl1); err != nil {
+			return nil, err
+		}
+	}
+	return &cl1, nil
+}
+
+// stat returns the bucket name used for stat.
+func (cl *Client) stat(stat string) string {
+	if cl.prefix == "" {
+		return stat
+	}
+	return cl.prefix + stat
+}
+
+// metricTags returns the tags sent with a metric given
+// the tags passed in the call.
+func (cl *Client) metricTags(tags []string) []string {
+	if len(cl.tags) == 0 {
+		return tags
+	}
+	if len(tags) == 0 {
+		return cl.tags
+	}
+	return append(cl.tags[:len(cl.tags):len(cl.tags)], tags...)
+}
+
+// SetErrorFunc sets a function to be called with errors that happen
+// in the background. The setting is shared with all clients derived
+// from the same client. See SetErrorFunc for details.
+func (cl *Client) SetErrorFunc(f func(error)) {
+	cl.c.setErrorFunc(f)
+}
+
+// SetFlushFunc sets a function to be called after each packet of
+// metrics is written. It must not call methods of cl or of any client
+// derived from the same client. The setting is shared with all clients
+// derived from the same client. See SetFlushFunc for details.
+func (cl *Client) SetFlushFunc(f func(bytes, metrics int)) {
+	cl.c.setFlushFunc(f)
+}
+
+// SetPrefix sets a prefix to be added to the start of every bucket
+// name, before the prefix of cl and of any client derived from it with
+// WithPrefix. The setting is shared with all clients derived from the
+// same client. See SetPrefix for details.
+func (cl *Client) SetPrefix(prefix string) error {
+	return cl.c.setPrefix(prefix)
+}
+
+// Prefix returns the prefix added to the start of every bucket name
+// sent by cl: the prefix set with SetPrefix followed by any prefix of
+// cl.
+func (cl *Client) Prefix() string {
+	return cl.c.getPrefix() + cl.prefix
+}
+
+// SetEnabled enables or disables sending metrics. The setting is
+// shared with all clients derived from the same client. See SetEnabled
+// for details.
+func (cl *Client) SetEnabled(enabled bool) {
+	cl.c.setEnabled(enabled)
+}
+
+// Enabled reports whether cl sends metrics, as set by SetEnabled.
+func (cl *Client) Enabled() bool {
+	return cl.c.getEnabled()
+}
+
+// SetGlobalTags sets tags to be sent with every subsequent metric,
+// before the default tags of cl. The setting is shared with all
+// clients derived from the same client.
+func (cl *Client) SetGlobalTags(tags ...string) error {
+	return cl.c.setGlobalTags(tags)
+}
+
+// GlobalTags returns the tags set with SetGlobalTags.
+func (cl *Client) GlobalTags() []string {
+	return cl.c.getGlobalTags()
+}
+
+// Tags returns the default tags of cl, as added by WithTags.
+func (cl *Client) Tags() []string {
+	return append([]string(nil), cl.tags...)
+}
+
+// SetTagFormat sets the format in which tags are sent. The setting is
+// shared with all clients derived from the same client. See
+// SetTagFormat for details.
+func (cl *Client) SetTagFormat(format TagFormat) error {
+	return cl.c.setTagFormat(format)
+}
+
+// TagFormat returns the format set with SetTagFormat.
+func (cl *Client) TagFormat() TagFormat {
+	return cl.c.getTagFormat()
+}
+
+// SetStrict sets whether metrics whose stat names or tags contain
+// characters that cannot be sent are rejected rather than fixed. The
+// setting is shared with all clients derived from the same client. See
+// SetStrict for details.
+func (cl *Client) SetStrict(strict bool) error {
+	return cl.c.setStrict(strict)
+}
+
+// SetReplacementChar sets the character used in place of characters
+// that cannot be sent. The setting is shared with all clients derived
+// from the same client. See SetReplacementChar for details.
+func (cl *Client) SetReplacementChar(r rune) error {
+	return cl.c.setReplacementChar(r)
+}
+
+// SetMaxTagValues limits the number of distinct values sent for each tag
+// key. The limit is shared with all clients derived from the same
+// client. See SetMaxTagValues for details.
+func (cl *Client) SetMaxTagValues(perKey int) error {
+	return cl.c.setMaxTagValues(perKey)
+}
+
+// ResetTagValues forgets the tag values seen so far by the limit set
+// with SetMaxTagValues.
+func (cl *Client) ResetTagValues() {
+	cl.c.resetTagValues()
+}
+
+// SetResolveInterval makes the host name in the address of cl be
+// resolved again every interval in the background. The setting is shared
+// with all clients derived from the same client. Close stops the
+// re-resolution. See SetResolveInterval for details.
+func (cl *Client) SetResolveInterval(interval time.Duration) error {
+	return cl.c.setResolveInterval(interval)
+}
+
+// FlushOnSignal makes the client flush any buffered metrics whenever
+// one of the given signals arrives. See FlushOnSignal for details.
+func (cl *Client) FlushOnSignal(signals ...os.Signal) (stop func()) {
+	return cl.c.flushOnSignal(signals)
+}
+
+// SetFlushInterval makes buffered metrics be flushed every interval in
+// the background. The setting is shared with all clients derived from
+// the same client. See SetFlushInterval for details.
+func (cl *Client) SetFlushInterval(interval time.Duration) error {
+	return cl.c.setFlushInterval(interval)
+}
+
+// FlushInterval returns the interval set with SetFlushInterval,
+// or zero if metrics are not flushed in the background.
+func (cl *Client) FlushInterval() time.Duration {
+	return cl.c.getFlushInterval()
+}
+
+// SetLinger makes buffered metrics be flushed in the background once
+// the first of them has waited for d. The setting is shared with all
+// clients derived from the same client. See SetLinger for details.
+func (cl *Client) SetLinger(d time.Duration) error {
+	return cl.c.setLinger(d)
+}
+
+// SetCompression makes the client compress metrics sent over a TCP or
+// TLS connection with gzip at the given level. The setting is shared
+// with all clients derived from the same client. See SetCompression for
+// details.
+func (cl *Client) SetCompression(level int) error {
+	return cl.c.setCompression(level)
+}
+
+// SetUnconnected sets whether metrics sent over UDP use an unconnected
+// socket. The setting is shared with all clients derived from the same
+// client. See SetUnconnected for details.
+func (cl *Client) SetUnconnected(unconnected bool) error {
+	return cl.c.setUnconnected(unconnected)
+}
+
+// SetWriteTimeout sets the maximum time that writing a packet of metrics
+// may take. The setting is shared with all clients derived from the same
+// client. See SetWriteTimeout for details.
+func (cl *Client) SetWriteTimeout(timeout time.Duration) error {
+	return cl.c.setWriteTimeout(timeout)
+}
+
+// SetReconnectBackoff sets the delay between attempts to redial
+// a TCP connection after a write fails. The setting is shared with
+// all clients derived from the same client. See SetReconnectBackoff
+// for details.
+func (cl *Client) SetReconnectBackoff(min, max time.Duration) error {
+	return cl.c.setReconnectBackoff(min, max)
+}
+
+// SetSpool makes the client write packets of metrics that cannot be
+// sent to the file at path. The setting is shared with all clients
+// derived from the same client. See SetSpool for details.
+func (cl *Client) SetSpool(path string, opts ...SpoolOption) error {
+	return cl.c.setSpool(path, opts)
+}
+
+// SetAsync makes the client queue metrics of up to queueLen entries to
+// be sent in the background. The setting is shared with all clients
+// derived from the same client. See SetAsync for details.
+func (cl *Client) SetAsync(queueLen int) error {
+	return cl.c.setAsync(queueLen)
+}
+
+// SetOverflowPolicy sets what happens to metrics that cannot be sent.
+// The setting is shared with all clients derived from the same client.
+// See SetOverflowPolicy for details.
+func (cl *Client) SetOverflowPolicy(policy OverflowPolicy, maxBytes int) error {
+	return cl.c.setOverflowPolicy(policy, maxBytes)
+}
+
+// SetDropReportInterval sets the minimum interval between reports of
+// dropped metrics to the error function. The setting is shared with
+// all clients derived from the same client. See SetDropReportInterval
+// for details.
+func (cl *Client) SetDropReportInterval(d time.Duration) {
+	cl.c.setDropReportInterval(d)
+}
+
+// Stats returns statistics about the metrics sent by cl and
+// all clients derived from the same client.
+func (cl *Client) Stats() ClientStats {
+	return cl.c.stats()
+}
+
+// DebugString returns a description of the configuration and state of
+// cl, including the state shared with all clients derived from the same
+// client. See DebugString for details.
+func (cl *Client) DebugString(includeBuffer bool) string {
+	return cl.c.debugString(cl.prefix, includeBuffer)
+}
+
+// ResetStats sets the cumulative statistics returned by Stats to zero.
+// The statistics are shared with all clients derived from the same
+// client. See ResetStats for details.
+func (cl *Client) ResetStats() {
+	cl.c.resetStats()
+}
+
+// Pending returns the number of metrics buffered by cl and all clients
+// derived from the same client that have not yet been flushed, and
+// their size in bytes. See Pending for details.
+func (cl *Client) Pending() (metrics, bytes int) {
+	return cl.c.pending()
+}
+
+// SetSendBufferSize sets the size in bytes of the operating system's
+// send buffer for the connection. The setting is shared with all
+// clients derived from the same client. See SetSendBufferSize for
+// details.
+func (cl *Client) SetSendBufferSize(bytes int) error {
+	return cl.c.setSendBufferSize(bytes)
+}
+
+// SendBufferSize returns the size in bytes of the operating system's
+// send buffer for the connection. See SendBufferSize for details.
+func (cl *Client) SendBufferSize() int {
+	return cl.c.getSendBufferSize()
+}
+
+// SetMaxPacketsPerSecond limits the rate at which packets are written
+// to n per second. The setting is shared with all clients derived from
+// the same client. See SetMaxPacketsPerSecond for details.
+func (cl *Client) SetMaxPacketsPerSecond(n int) error {
+	return cl.c.setMaxPacketsPerSecond(n)
+}
+
+// SetFlushThreshold makes the client flush once n metrics are buffered.
+// The setting is shared with all clients derived from the same client.
+// See SetFlushThreshold for details.
+func (cl *Client) SetFlushThreshold(n int) error {
+	return cl.c.setFlushThreshold(n)
+}
+
+// SetUnbuffered makes the client write each metric in its own packet
+// as soon as it is recorded. The setting is shared with all clients
+// derived from the same client. See SetUnbuffered for details.
+func (cl *Client) SetUnbuffered(unbuffered bool) error {
+	return cl.c.setUnbuffered(unbuffered)
+}
+
+// SetPacketSize sets the maximum size in bytes of the packets that
+// metrics are sent in. The setting is shared with all clients derived
+// from the same client. See SetPacketSize for details.
+func (cl *Client) SetPacketSize(size int) error {
+	return cl.c.setPacketSize(size)
+}
+
+// PacketSize returns the maximum size of a packet, as set with
+// SetPacketSize.
+func (cl *Client) PacketSize() int {
+	return cl.c.getPacketSize()
+}
+
+// SetOversizePolicy sets what happens to metrics that are larger than
+// the packet size. The setting is shared with all clients derived from
+// the same client. See SetOversizePolicy for details.
+func (cl *Client) SetOversizePolicy(policy OversizePolicy) error {
+	return cl.c.setOversizePolicy(policy)
+}
+
+// OversizePolicy returns the policy set with SetOversizePolicy.
+func (cl *Client) OversizePolicy() OversizePolicy {
+	return cl.c.getOversizePolicy()
+}
+
+// SetPacketHeader makes every packet begin with the given header line.
+// The setting is shared with all clients derived from the same client.
+// See SetPacketHeader for details.
+func (cl *Client) SetPacketHeader(header []byte) error {
+	return cl.c.setPacketHeader(header)
+}
+
+// SetContainerID sets the ID of the container that metrics originate
+// from. The setting is shared with all clients derived from the same
+// client. See SetContainerID for details.
+func (cl *Client) SetContainerID(id string) error {
+	return cl.c.setContainerID(id)
+}
+
+// SetNegativeGaugeReset sets whether negative gauge values are sent
+// after a line setting the gauge to zero. The setting is shared with
+// all clients derived from the same client. See SetNegativeGaugeReset
+// for details.
+func (cl *Client) SetNegativeGaugeReset(reset bool) {
+	cl.c.setNegativeGaugeReset(reset)
+}
+
+// SetDropZeroCounts sets whether counter updates of zero are
+// discarded. The setting is shared with all clients derived from the
+// same client. See SetDropZeroCounts for details.
+func (cl *Client) SetDropZeroCounts(drop bool) {
+	cl.c.setDropZeroCounts(drop)
+}
+
+// SetSizeUnit sets the unit in which values passed to Size are sent.
+// The setting is shared with all clients derived from the same client.
+// See SetSizeUnit for details.
+func (cl *Client) SetSizeUnit(unit SizeUnit) error {
+	return cl.c.setSizeUnit(unit)
+}
+
+// SetTimerAggregation makes timer values be summarized on the client
+// rather than sent individually. The setting is shared with all
+// clients derived from the same client. See SetTimerAggregation for
+// details.
+func (cl *Client) SetTimerAggregation(interval time.Duration, percentiles []float64) error {
+	return cl.c.setTimerAggregation(interval, percentiles)
+}
+
+// Increment increments the counter for the given bucket.
+func (cl *Client) Increment(stat string, count int, rate float64, tags ...string) error {
+	return cl.c.increment(cl.stat(stat), count, rate, cl.metricTags(tags)...)
+}
+
+// Increment64 is like Increment but takes an int64 count.
+func (cl *Client) Increment64(stat string, count int64, rate float64, tags ...string) error {
+	return cl.c.increment64(cl.stat(stat), count, rate, cl.metricTags(tags)...)
+}
+
+// IncrementBytes is like Increment but takes the bucket name as a byte
+// slice, avoiding the need to convert it to a string.
+func (cl *Client) IncrementBytes(stat []byte, count int, rate float64, tags ...string) error {
+	return cl.c.incrementBytes(cl.prefix, stat, count, rate, cl.metricTags(tags)...)
+}
+
+// IncrementFloat increments the counter for the given bucket by a
+// fractional amount.
+func (cl *Client) IncrementFloat(stat string, delta float64, rate float64, tags ...string) error {
+	return cl.c.incrementFloat(cl.stat(stat), delta, rate, cl.metricTags(tags)...)
+}
+
+// Decrement decrements the counter for the given bucket.
+func (cl *Client) Decrement(stat string, count int, rate float64, tags ...string) error {
+	return cl.c.decrement(cl.stat(stat), count, rate, cl.metricTags(tags)...)
+}
+
+// Duration records time spent for the given bucket with time.Duration.
+func (cl *Client) Duration(stat string, duration time.Duration, rate float64, tags ...string) error {
+	return cl.c.duration(cl.stat(stat), duration, rate, cl.metricTags(tags)...)
+}
+
+// DurationBytes is like Duration but takes the bucket name as a byte
+// slice, avoiding the need to convert it to a string.
+func (cl *Client) DurationBytes(stat []byte, duration time.Duration, rate float64, tags ...string) error {
+	return cl.c.durationBytes(cl.prefix, stat, duration, rate, cl.metricTags(tags)...)
+}
+
+// Durations records several durations for the given bucket at once.
+// See Durations for details.
+func (cl *Client) Durations(stat string, durations []time.Duration, rate float64, tags ...string) error {
+	return cl.c.durations(cl.stat(stat), durations, rate, cl.metricTags(tags)...)
+}
+
+// DurationN records n observations of the same duration for the given
+// bucket. See DurationN for details.
+func (cl *Client) DurationN(stat string, duration time.Duration, n int, rate float64, tags ...string) error {
+	return cl.c.durationN(cl.stat(stat), duration, n, rate, cl.metricTags(tags)...)
+}
+
+// DurationFloat is like Duration but records the time in fractional
+// milliseconds.
+func (cl *Client) DurationFloat(stat string, duration time.Duration, rate float64, tags ...string) error {
+	return cl.c.durationFloat(cl.stat(stat), duration, rate, cl.metricTags(tags)...)
+}
+
+// Timing records time spent for the given bucket in milliseconds.
+func (cl *Client) Timing(stat string, delta int, rate float64, tags ...string) error {
+	return cl.c.timing(cl.stat(stat), delta, rate, cl.metricTags(tags)...)
+}
+
+// TimingFloat records time spent for the given bucket in fractional
+// milliseconds. Negative, infinite and NaN values are rejected.
+func (cl *Client) TimingFloat(stat string, delta float64, rate float64, tags ...string) error {
+	return cl.c.timingFloat(cl.stat(stat), delta, rate, cl.metricTags(tags)...)
+}
+
+// DurationSince records the time elapsed since start for the given
+// bucket. See the package-level DurationSince for details.
+func (cl *Client) DurationSince(stat string, start time.Time, rate float64, tags ...string) error {
+	return cl.c.durationSince(cl.stat(stat), start, rate, cl.metricTags(tags)...)
+}
+
+// Time calculates time spent in given function and send it.
+func (cl *Client) Time(stat string, rate float64, f func(), tags ...string) error {
+	return cl.c.time(cl.stat(stat), rate, f, cl.metricTags(tags)...)
+}
+
+// TimeDuration is like Time but also returns the time spent in f.
+// The function is always called, even if the stat is not sent
+// because of sampling.
+func (cl *Client) TimeDuration(stat string, rate float64, f func(), tags ...string) (time.Duration, error) {
+	return cl.c.timeDuration(cl.stat(stat), rate, f, cl.metricTags(tags)...)
+}
+
+// TimeErr is like Time but for functions that return an error.
+// If f returns an error, that error is returned unchanged; otherwise
+// the error from sending the stat is returned.
+func (cl *Client) TimeErr(stat string, rate float64, f func() error, tags ...string) error {
+	return cl.c.timeErr(cl.stat(stat), rate, f, cl.metricTags(tags)...)
+}
+
+// Gauge records arbitrary values for the given bucket.
+func (cl *Client) Gauge(stat string, value int, rate float64, tags ...string) error {
+	return cl.c.gauge(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// Gauge64 is like Gauge but takes an int64 value.
+func (cl *Client) Gauge64(stat string, value int64, rate float64, tags ...string) error {
+	return cl.c.gauge64(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// GaugeBytes is like Gauge but takes the bucket name as a byte
+// slice, avoiding the need to convert it to a string.
+func (cl *Client) GaugeBytes(stat []byte, value int, rate float64, tags ...string) error {
+	return cl.c.gaugeBytes(cl.prefix, stat, value, rate, cl.metricTags(tags)...)
+}
+
+// GaugeFloat64 is like Gauge but records a floating point value.
+func (cl *Client) GaugeFloat64(stat string, value float64, rate float64, tags ...string) error {
+	return cl.c.gaugeFloat64(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// ClientGaugeValue is like GaugeValue but sends the gauge through
+// cl with the given tags. It is a function rather than a method
+// because methods cannot have type parameters.
+func ClientGaugeValue[T Number](cl *Client, stat string, value T, rate float64, tags ...string) error {
+	return gaugeValue(cl.c, cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// GaugeAt is like Gauge but records the value as of the given time.
+// See GaugeAt for details.
+func (cl *Client) GaugeAt(stat string, value int, t time.Time, tags ...string) error {
+	return cl.c.gaugeAt(cl.stat(stat), value, t, cl.metricTags(tags)...)
+}
+
+// GaugeBool records a boolean state for the given bucket as a gauge
+// with value 1 for true and 0 for false.
+func (cl *Client) GaugeBool(stat string, value bool, rate float64, tags ...string) error {
+	return cl.c.gaugeBool(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// IncrementGauge increments the value of the gauge.
+func (cl *Client) IncrementGauge(stat string, value int, rate float64, tags ...string) error {
+	return cl.c.incrementGauge(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// DecrementGauge decrements the value of the gauge.
+func (cl *Client) DecrementGauge(stat string, value int, rate float64, tags ...string) error {
+	return cl.c.decrementGauge(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// Unique records unique occurences of events.
+func (cl *Client) Unique(stat string, value int, rate float64, tags ...string) error {
+	return cl.c.unique(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// UniqueString is like Unique but records a string value. The value
+// must not contain the characters '|', ':' or newline.
+func (cl *Client) UniqueString(stat string, value string, rate float64, tags ...string) error {
+	return cl.c.uniqueString(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// UniqueValue is like Unique but accepts a value of any integer or
+// string type, or any value implementing fmt.Stringer.
+func (cl *Client) UniqueValue(stat string, value interface{}, rate float64, tags ...string) error {
+	return cl.c.uniqueValue(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// Histogram records a value in the histogram for the given bucket.
+func (cl *Client) Histogram(stat string, value float64, rate float64, tags ...string) error {
+	return cl.c.histogram(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// Size records a size in bytes in the histogram for the given bucket,
+// in the unit set with SetSizeUnit.
+func (cl *Client) Size(stat string, bytes int64, rate float64, tags ...string) error {
+	return cl.c.byteSize(cl.stat(stat), bytes, rate, cl.metricTags(tags)...)
+}
+
+// Distribution records a value in the global distribution for the given
+// bucket. Distributions are supported by DogStatsD-compatible servers.
+func (cl *Client) Distribution(stat string, value float64, rate float64, tags ...string) error {
+	return cl.c.distribution(cl.stat(stat), value, rate, cl.metricTags(tags)...)
+}
+
+// KeyValue records a raw key/value pair, sent as "stat:value|kv".
+// See KeyValue for details.
+func (cl *Client) KeyValue(stat string, value float64, tags ...string) error {
+	return cl.c.keyValue(cl.stat(stat), value, cl.metricTags(tags)...)
+}
+
+// Send records a metric of an arbitrary kind, sent as
+// "stat:value|kind". See Send for details.
+func (cl *Client) Send(stat string, value string, kind string, rate float64, tags ...string) error {
+	return cl.c.sendRaw(cl.stat(stat), value, kind, rate, cl.metricTags(tags)...)
+}
+
+// NewCounter returns a handle that can be used to update the counter
+// for the given bucket with the given sample rate and tags.
+func (cl *Client) NewCounter(stat string, rate float64, tags ...string) *Counter {
+	return cl.c.counter(cl.stat(stat), rate, cl.metricTags(tags)...)
+}
+
+// NewTimer returns a handle that can be used to record durations
+// for the given bucket with the given sample rate and tags.
+func (cl *Client) NewTimer(stat string, rate float64, tags ...string) *Timer {
+	return cl.c.timer(cl.stat(stat), rate, cl.metricTags(tags)...)
+}
+
+// NewGaugeHandle returns a handle that can be used to set the value
+// of the given gauge with the given sample rate and tags.
+func (cl *Client) NewGaugeHandle(stat string, rate float64, tags ...string) *GaugeHandle {
+	return cl.c.gaugeHandle(cl.stat(stat), rate, cl.metricTags(tags)...)
+}
+
+// NewMeter returns a meter that reports events for the given bucket
+// every interval. See NewMeter for details.
+func (cl *Client) NewMeter(stat string, interval time.Duration, tags ...string) (*Meter, error) {
+	return cl.c.meter(cl.stat(stat), interval, cl.metricTags(tags)...)
+}
+
+// Flush writes any buffered metrics to the network and returns
+// any error from the write. Nothing is written if no metrics
+// are buffered.
+func (cl *Client) Flush() error {
+	return cl.c.flushContext(context.Background())
+}
+
+// WaitFlushed waits until all metrics recorded by cl and the clients
+// derived from the same client before it was called have been written
+// to the connection. See WaitFlushed for details.
+func (cl *Client) WaitFlushed() {
+	cl.c.waitFlushed()
+}
+
+// FlushContext is like Flush but gives up when ctx is done, returning
+// ctx.Err(). See FlushContext for details.
+func (cl *Client) FlushContext(ctx context.Context) error {
+	return cl.c.flushContext(ctx)
+}
+
+// SetAddr sets the network address that metrics are sent to. The
+// setting is shared with all clients derived from the same client.
+// See SetAddr for details.
+func (cl *Client) SetAddr(addr string) error {
+	return cl.c.setAddr(addr)
+}
+
+// Addr returns the address that cl sends metrics to, as it was given
+// when cl was created or to SetAddr, or the empty string for a client
+// created by NewClientWriter. See RemoteAddr for the address that it resolved to.
+func (cl *Client) Addr() string {
+	return cl.c.getAddr()
+}
+
+// RemoteAddr returns the network address that cl currently sends
+// metrics to, after resolving any host name in Addr, or the empty
+// string if it is not connected or the connection has no remote
+// address.
+func (cl *Client) RemoteAddr() string {
+	return cl.c.getRemoteAddr()
+}
+
+// Close flushes any buffered metrics. If cl was not derived from
+// another client with WithTags or WithPrefix, it also closes the
+// connection, which is shared with any clients derived from cl, and
+// stops any background activity. Metrics sent after that are discarded
+// without error; the first time, this is reported to the function set
+// with SetErrorFunc. Calling Close more than once is safe.
+func (cl *Client) Close() error {
+	if cl.derived {
+		return cl.Flush()
+	}
+	return cl.c.close()
+}
@@ -0,0 +1,1191 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cl, err := NewClient(ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Increment("incr", 1, 1, "a:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 512)
+	n, _, err := ln.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(buf[:n]), "incr:1|c|#a:b")
+}
+
+var newClientErrorTests = []struct {
+	addr string
+	err  string
+}{
+	{"localhost", "dial udp: address localhost: missing port in address"},
+	{"udp://localhost", "dial udp: address localhost: missing port in address"},
+	{"tcp://localhost", "dial tcp: address localhost: missing port in address"},
+	{"foo://localhost:8125", `unknown scheme "foo" in address "foo://localhost:8125"`},
+}
+
+func TestNewClientError(t *testing.T) {
+	for _, test := range newClientErrorTests {
+		_, err := NewClient(test.addr)
+		if err == nil {
+			t.Errorf("no error from NewClient(%q)", test.addr)
+			continue
+		}
+		assert(t, err.Error(), test.err)
+		err = newClient().setAddr(test.addr)
+		if err == nil {
+			t.Errorf("no error from setAddr(%q)", test.addr)
+			continue
+		}
+		assert(t, err.Error(), test.err)
+	}
+}
+
+func TestNewClientWriter(t *testing.T) {
+	var packets []string
+	closed := 0
+	cl := NewClientWriter(closeCountWriter{packetWriter{&packets}, &closed}, 20)
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(cl.Increment("incr", 1, 1))
+	checkErr(cl.Increment("incr", 2, 1))
+	checkErr(cl.Increment("incr", 3, 1))
+	if err := cl.Increment(strings.Repeat("x", 20), 1, 1); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	checkErr(cl.Close())
+	if closed != 1 {
+		t.Fatalf("writer closed %d times, want 1", closed)
+	}
+	assert(t, strings.Join(packets, "\n--\n"), "incr:1|c\nincr:2|c\n--\nincr:3|c")
+}
+
+// errWriter is an io.Writer that fails every write.
+type errWriter struct {
+	writes *int
+}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	*w.writes++
+	return 0, fmt.Errorf("write failed")
+}
+
+func TestSetErrorFuncConcurrent(t *testing.T) {
+	writes := 0
+	cl := NewClientWriter(errWriter{&writes}, 0)
+	defer cl.Close()
+	// Errors from an asynchronous client are reported
+	// in the background while the error function changes.
+	cl.SetUnbuffered(true)
+	if err := cl.SetAsync(10); err != nil {
+		t.Fatal(err)
+	}
+	var reported atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if i%2 == 0 {
+				cl.SetErrorFunc(func(error) {
+					reported.Add(1)
+				})
+			} else {
+				cl.SetErrorFunc(nil)
+			}
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		cl.Increment("a", 1, 1)
+	}
+	<-done
+	cl.SetErrorFunc(func(error) {
+		reported.Add(1)
+	})
+	n := reported.Load()
+	cl.Increment("a", 1, 1)
+	cl.Flush()
+	if got := reported.Load(); got == n {
+		t.Fatal("error not reported")
+	}
+}
+
+func TestNewClientWriterError(t *testing.T) {
+	writes := 0
+	cl := NewClientWriter(errWriter{&writes}, 0)
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err == nil || err.Error() != "write failed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if writes != 1 {
+		t.Fatalf("got %d writes, want 1", writes)
+	}
+	// The writer is not replaced after an error.
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err == nil || err.Error() != "write failed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if writes != 2 {
+		t.Fatalf("got %d writes, want 2", writes)
+	}
+	// Flushing an empty buffer writes nothing.
+	if err := cl.Flush(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if writes != 2 {
+		t.Fatalf("got %d writes, want 2", writes)
+	}
+	// Close returns the error from the final flush.
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Close(); err == nil || err.Error() != "write failed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// blockingWriter is a packetWriter that signals on started
+// when a write starts and waits until release is closed.
+type blockingWriter struct {
+	packetWriter
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.release
+	return w.packetWriter.Write(p)
+}
+
+func TestWriteUnlocked(t *testing.T) {
+	var packets []string
+	w := blockingWriter{
+		packetWriter: packetWriter{&packets},
+		started:      make(chan struct{}, 10),
+		release:      make(chan struct{}),
+	}
+	cl := NewClientWriter(w, 0)
+	cl.Increment("a", 1, 1)
+	flushed := make(chan error)
+	go func() {
+		flushed <- cl.Flush()
+	}()
+	<-w.started
+
+	// Metrics can be recorded while the write is blocked.
+	done := make(chan struct{})
+	go func() {
+		cl.Increment("b", 1, 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Increment blocked by write in progress")
+	}
+	close(w.release)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "|"), "a:1|c|b:1|c")
+}
+
+func TestWriteUnlockedOrder(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cl.Increment("incr", 1, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, p := range packets {
+		if len(p) > 20 {
+			t.Fatalf("packet too big: %q", p)
+		}
+		n += strings.Count(p, "incr:1|c")
+	}
+	if n != 400 {
+		t.Fatalf("got %d metrics, want 400", n)
+	}
+}
+
+// wedgedConn is a connection whose writes block
+// until its write deadline passes.
+type wedgedConn struct {
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{}
+}
+
+func newWedgedConn() *wedgedConn {
+	return &wedgedConn{changed: make(chan struct{}, 1)}
+}
+
+func (c *wedgedConn) Write(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.deadline
+		c.mu.Unlock()
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		<-c.changed
+	}
+}
+
+func (c *wedgedConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *wedgedConn) Close() error {
+	return nil
+}
+
+func TestFlushContextCancelled(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	defer cl.Close()
+	conn := newWedgedConn()
+	cl.c.conn = conn
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cl.Increment("a", 1, 1)
+	if err := cl.FlushContext(ctx); err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := cl.Stats().Dropped; got != 1 {
+		t.Fatalf("got %d dropped metrics, want 1", got)
+	}
+
+	// With DropOldest, the abandoned metrics are kept
+	// and written by a later flush.
+	if err := cl.SetOverflowPolicy(DropOldest, 100); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("b", 1, 1)
+	if err := cl.FlushContext(ctx); err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	cl.c.conn = writerConn{packetWriter{&packets}}
+	cl.Increment("c", 1, 1)
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, " "), "b:1|c c:1|c")
+	if got := cl.Stats().Dropped; got != 1 {
+		t.Fatalf("got %d dropped metrics, want 1", got)
+	}
+}
+
+func TestFlushContextDeadline(t *testing.T) {
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	conn := newWedgedConn()
+	cl.c.conn = conn
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cl.Increment("a", 1, 1)
+	if err := cl.FlushContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := cl.Stats().Dropped; got != 1 {
+		t.Fatalf("got %d dropped metrics, want 1", got)
+	}
+	// The deadline set to abandon the write is cleared.
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.deadline.IsZero() {
+		t.Fatalf("deadline not cleared: %v", conn.deadline)
+	}
+}
+
+func TestPending(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 20)
+	defer cl.Close()
+	assertPending := func(metrics, bytes int) {
+		t.Helper()
+		gotMetrics, gotBytes := cl.Pending()
+		if gotMetrics != metrics || gotBytes != bytes {
+			t.Fatalf("got %d metrics in %d bytes, want %d in %d", gotMetrics, gotBytes, metrics, bytes)
+		}
+		stats := cl.Stats()
+		if stats.PendingMetrics != metrics || stats.PendingBytes != bytes {
+			t.Fatalf("got stats %+v, want %d metrics in %d bytes", stats, metrics, bytes)
+		}
+	}
+	assertPending(0, 0)
+	cl.Increment("a", 1, 1)
+	assertPending(1, len("a:1|c"))
+	cl.Increment("b", 1, 1)
+	assertPending(2, len("a:1|c\nb:1|c"))
+
+	// A metric that does not fit flushes the buffer first.
+	cl.Increment("cccccccc", 1, 1)
+	assert(t, strings.Join(packets, " "), "a:1|c\nb:1|c")
+	assertPending(1, len("cccccccc:1|c"))
+
+	cl.Flush()
+	assertPending(0, 0)
+}
+
+func TestStatsCounts(t *testing.T) {
+	var packets []string
+	down := false
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 20)
+	defer cl.Close()
+	WithRandSource(halfSource{})(cl)
+	cl.SetErrorFunc(func(error) {})
+
+	cl.Increment("a", 1, 1)
+	cl.Increment("b", 1, 0.1)
+	cl.Increment(strings.Repeat("x", 20), 1, 1)
+	cl.Flush()
+	down = true
+	cl.Increment("c", 1, 1)
+	cl.Flush()
+	assertStats(t, cl.Stats(), ClientStats{
+		Recorded:       2,
+		SampledOut:     1,
+		PacketsWritten: 1,
+		BytesWritten:   int64(len("a:1|c")),
+		WriteErrors:    1,
+		Dropped:        1,
+		TooBig:         1,
+	})
+
+	cl.ResetStats()
+	cl.Increment("d", 1, 1)
+	assertStats(t, cl.Stats(), ClientStats{
+		Recorded:       1,
+		PendingMetrics: 1,
+		PendingBytes:   len("d:1|c"),
+	})
+}
+
+func assertStats(t *testing.T, got, want ClientStats) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestUnbuffered(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	defer cl.Close()
+	cl.Increment("a", 1, 1)
+	if err := cl.SetUnbuffered(true); err != nil {
+		t.Fatal(err)
+	}
+	// Buffered metrics are flushed first.
+	assert(t, strings.Join(packets, " "), "a:1|c")
+
+	cl.Increment("b", 1, 1)
+	cl.Gauge("c", 2, 1)
+	assert(t, strings.Join(packets, " "), "a:1|c b:1|c c:2|g")
+	packets = nil
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 0 {
+		t.Fatalf("unexpected writes from Flush: %q", packets)
+	}
+	if err := cl.Increment(strings.Repeat("x", 600), 1, 1); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Write errors are returned by the call.
+	cl.c.conn = writerConn{errWriter{new(int)}}
+	if err := cl.Increment("d", 1, 1); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestFlushCoalesced(t *testing.T) {
+	var packets []string
+	w := blockingWriter{
+		packetWriter: packetWriter{&packets},
+		started:      make(chan struct{}, 10),
+		release:      make(chan struct{}),
+	}
+	cl := NewClientWriter(w, 0)
+	cl.Increment("a", 1, 1)
+	errc := make(chan error)
+	go func() {
+		errc <- cl.Flush()
+	}()
+	<-w.started
+
+	// Calls made while the first flush is writing wait for it,
+	// and then for a single flush of the metrics buffered since.
+	cl.Increment("b", 1, 1)
+	const n = 10
+	for i := 0; i < n; i++ {
+		go func() {
+			errc <- cl.Flush()
+		}()
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("Flush returned %v before the write finished", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	w.release <- struct{}{}
+	<-w.started
+	w.release <- struct{}{}
+	for i := 0; i < n+1; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert(t, strings.Join(packets, " "), "a:1|c b:1|c")
+	select {
+	case <-w.started:
+		t.Fatal("unexpected write")
+	default:
+	}
+}
+
+func TestFlushFunc(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 20)
+	defer cl.Close()
+	var flushes []string
+	cl.SetFlushFunc(func(bytes, metrics int) {
+		flushes = append(flushes, fmt.Sprintf("%d/%d", bytes, metrics))
+	})
+	cl.Flush()
+	cl.Increment("a", 1, 1)
+	cl.Flush()
+	cl.Increment("a", 1, 1)
+	cl.Increment("b", 1, 1)
+	cl.Flush()
+	// Packets flushed because the buffer is full are reported too.
+	cl.Increment("cccccc", 1, 1)
+	cl.Increment("dddddd", 1, 1)
+	cl.Flush()
+	assert(t, strings.Join(flushes, " "), "5/1 11/2 10/1 10/1")
+
+	// Failed writes are not reported.
+	flushes = nil
+	cl.c.conn = writerConn{errWriter{new(int)}}
+	cl.Increment("a", 1, 1)
+	cl.Flush()
+	if len(flushes) != 0 {
+		t.Fatalf("unexpected flushes %q", flushes)
+	}
+}
+
+func TestOrdering(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		t.Run(fmt.Sprintf("async=%v", async), func(t *testing.T) {
+			testOrdering(t, async)
+		})
+	}
+}
+
+func testOrdering(t *testing.T, async bool) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ln.(*net.UDPConn).SetReadBuffer(1 << 20)
+	cl, err := NewClient(ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if async {
+		if err := cl.SetAsync(1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each producer sends counters with increasing values.
+	const producers, n = 4, 200
+	received := make(chan []string)
+	go func() {
+		var lines []string
+		buf := make([]byte, 1024)
+		for {
+			ln.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			m, _, err := ln.ReadFrom(buf)
+			if err != nil {
+				received <- lines
+				return
+			}
+			lines = append(lines, strings.Split(string(buf[:m]), "\n")...)
+		}
+	}()
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= n; i++ {
+				cl.Increment(fmt.Sprintf("p%d", p), i, 1)
+				if i%50 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	cl.WaitFlushed()
+
+	last := make(map[string]int)
+	for _, line := range <-received {
+		var stat string
+		var value int
+		if _, err := fmt.Sscanf(strings.Replace(line, ":", " ", 1), "%s %d|c", &stat, &value); err != nil {
+			t.Fatalf("cannot parse %q: %v", line, err)
+		}
+		if value <= last[stat] {
+			t.Fatalf("%s: got %d after %d", stat, value, last[stat])
+		}
+		last[stat] = value
+	}
+	if async && cl.Stats().Dropped > 0 {
+		return
+	}
+	for p := 0; p < producers; p++ {
+		if got := last[fmt.Sprintf("p%d", p)]; got != n {
+			t.Errorf("p%d: last value %d, want %d", p, got, n)
+		}
+	}
+}
+
+func TestSetPrefix(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 100)
+	defer cl.Close()
+	if err := cl.SetPrefix("a|b."); err == nil {
+		t.Fatal("expected error for invalid prefix")
+	}
+	cl.c.setNegativeGaugeReset(true)
+	for _, prefix := range []string{"svc.prod.", "svc"} {
+		packets = nil
+		if err := cl.SetPrefix(prefix); err != nil {
+			t.Fatal(err)
+		}
+		cl.Increment("a", 1, 1)
+		cl.Gauge("g", -1, 1)
+		// Derived clients add their prefix after it.
+		cl.WithPrefix("api.").Increment("b", 1, 1)
+		cl.Flush()
+		assert(t, strings.Join(packets, " "), fmt.Sprintf("%[1]sa:1|c\n%[1]sg:0|g\n%[1]sg:-1|g\n%[1]sapi.b:1|c", prefix))
+	}
+
+	// The prefix counts towards the packet size.
+	if err := cl.SetPrefix("svc.prod."); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Increment(strings.Repeat("x", 90), 1, 1); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	cl.SetPrefix("")
+	if err := cl.Increment(strings.Repeat("x", 90), 1, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	defer cl.Close()
+	if !cl.Enabled() {
+		t.Fatal("client not enabled by default")
+	}
+	cl.Increment("a", 1, 1)
+	cl.SetEnabled(false)
+	if cl.Enabled() {
+		t.Fatal("client enabled after SetEnabled(false)")
+	}
+	// Nothing recorded while disabled is sent, nor
+	// what was buffered before.
+	cl.Increment("b", 1, 1)
+	cl.c.incrementBytes("", []byte("c"), 1, 1)
+	cl.c.counter("d", 1).Add(1)
+	if err := cl.Increment("e|f", 1, 1); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 0 {
+		t.Fatalf("unexpected packets %q", packets)
+	}
+
+	cl.SetEnabled(true)
+	cl.Increment("g", 1, 1)
+	cl.Flush()
+	assert(t, strings.Join(packets, " "), "g:1|c")
+}
+
+func TestNewClientUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statsd.sock")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	for _, addr := range []string{path, "unix://" + path} {
+		cl, err := NewClient(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.SetPacketSize(8192); err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for i := 0; i < 100; i++ {
+			if err := cl.Increment("incr", i+1, 1, "a:b"); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, fmt.Sprintf("incr:%d|c|#a:b", i+1))
+		}
+		if err := cl.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+		buf := make([]byte, 8192)
+		n, _, err := ln.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n <= defaultBufSize {
+			t.Errorf("packet of %d bytes is no larger than the default size", n)
+		}
+		assert(t, string(buf[:n]), strings.Join(want, "\n"))
+	}
+}
+
+func TestWithTags(t *testing.T) {
+	var packets []string
+	closed := 0
+	cl := NewClientWriter(closeCountWriter{packetWriter{&packets}, &closed}, 0)
+	if err := cl.SetGlobalTags("host:a"); err != nil {
+		t.Fatal(err)
+	}
+	req := cl.WithTags("endpoint:/users").WithPrefix("api.")
+	sub := req.WithTags("code:200").WithPrefix("http.")
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(cl.Increment("incr", 1, 1))
+	checkErr(req.Increment("incr", 1, 1))
+	checkErr(sub.Increment("incr", 1, 1, "x"))
+	checkErr(req.Gauge("gauge", 3, 1))
+	checkErr(cl.Increment("incr", 2, 1))
+	checkErr(sub.Close())
+	checkErr(req.Close())
+	checkErr(req.Timing("timing", 5, 1))
+	if closed != 0 {
+		t.Fatalf("derived client closed the connection")
+	}
+	checkErr(cl.Close())
+	if closed != 1 {
+		t.Fatalf("connection closed %d times, want 1", closed)
+	}
+	assert(t, strings.Join(packets, "\n--\n"), strings.Join([]string{
+		"incr:1|c|#host:a",
+		"api.incr:1|c|#host:a,endpoint:/users",
+		"api.http.incr:1|c|#host:a,endpoint:/users,code:200,x",
+		"api.gauge:3|g|#host:a,endpoint:/users",
+		"incr:2|c|#host:a",
+		"--",
+		"api.timing:5|ms|#host:a,endpoint:/users",
+	}, "\n"))
+}
+
+func TestClone(t *testing.T) {
+	var packets []string
+	closed := 0
+	cl := NewClientWriter(closeCountWriter{packetWriter{&packets}, &closed}, 0)
+	if err := cl.SetGlobalTags("host:a"); err != nil {
+		t.Fatal(err)
+	}
+	api, err := cl.Clone(WithPrefix("api."), WithTags("endpoint:/users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := cl.Clone(WithPrefix("db."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(cl.Increment("incr", 1, 1))
+	checkErr(api.Increment("incr", 1, 1, "x"))
+	checkErr(db.Timing("query", 5, 1))
+	checkErr(cl.Increment("incr", 2, 1))
+	checkErr(api.Close())
+	if closed != 0 {
+		t.Fatalf("clone closed the connection")
+	}
+	assert(t, strings.Join(packets, "\n--\n"), strings.Join([]string{
+		"incr:1|c|#host:a",
+		"api.incr:1|c|#host:a,endpoint:/users,x",
+		"db.query:5|ms|#host:a",
+		"incr:2|c|#host:a",
+	}, "\n"))
+	if got := cl.Stats().Recorded; got != 4 {
+		t.Fatalf("got %d recorded metrics, want 4", got)
+	}
+
+	// Once the parent is closed, metrics sent
+	// through the clones are discarded.
+	cl.SetErrorFunc(func(error) {})
+	checkErr(cl.Close())
+	if closed != 1 {
+		t.Fatalf("connection closed %d times, want 1", closed)
+	}
+	checkErr(db.Increment("incr", 1, 1))
+	checkErr(db.Close())
+	if len(packets) != 1 {
+		t.Fatalf("unexpected packets %q", packets)
+	}
+
+	if _, err := cl.Clone(WithDefaultRate(0)); err == nil {
+		t.Fatal("expected error for invalid option")
+	}
+}
+
+func TestCloneDefaultRate(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	WithRandSource(halfSource{})(cl)
+	kept, err := cl.Clone(WithDefaultRate(0.75))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := cl.Clone(WithDefaultRate(0.25))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept.IncrementOpt("kept", 1)
+	dropped.IncrementOpt("dropped", 1)
+	dropped.IncrementOpt("explicit", 1, Rate(1))
+	cl.IncrementOpt("parent", 1)
+	cl.Flush()
+	assert(t, strings.Join(packets, " "), "kept:1|c|@0.75\nexplicit:1|c\nparent:1|c")
+}
+
+func TestCloneSharedOptions(t *testing.T) {
+	cl := NewClientWriter(&strings.Builder{}, 0)
+	for _, opt := range []Option{
+		WithPacketSize(100),
+		WithErrorFunc(func(error) {}),
+		WithFlushInterval(time.Second),
+		WithGlobalTags("x:y"),
+		WithAsync(10),
+		WithUnbuffered(),
+		WithTLS(nil),
+		WithRandSource(halfSource{}),
+		WithClock(newFakeClock()),
+		WithHostname("host"),
+		WithSeparator('_'),
+	} {
+		if _, err := cl.Clone(opt); err == nil || !strings.HasSuffix(err.Error(), "cannot be used with Clone") {
+			t.Errorf("got error %v, want error for option used with Clone", err)
+		}
+	}
+	if cl.PacketSize() != defaultBufSize || len(cl.GlobalTags()) != 0 {
+		t.Fatal("shared settings changed by Clone")
+	}
+}
+
+func TestGetters(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := strconv.Itoa(assertUDPPort(t, ln))
+	cl, err := NewClient("localhost:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	assert(t, cl.Addr(), "localhost:"+port)
+	if got := cl.RemoteAddr(); got != "127.0.0.1:"+port && got != "[::1]:"+port {
+		t.Fatalf("unexpected remote address %q", got)
+	}
+
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(cl.SetPacketSize(PacketSizeLAN))
+	checkErr(cl.SetPrefix("app."))
+	checkErr(cl.SetGlobalTags("a:1", "b:2"))
+	checkErr(cl.SetTagFormat(TagFormatInflux))
+	checkErr(cl.SetFlushInterval(time.Hour))
+	if got := cl.PacketSize(); got != PacketSizeLAN {
+		t.Fatalf("got packet size %d", got)
+	}
+	assert(t, cl.Prefix(), "app.")
+	assert(t, cl.WithPrefix("api.").Prefix(), "app.api.")
+	assert(t, strings.Join(cl.GlobalTags(), ","), "a:1,b:2")
+	assert(t, strings.Join(cl.WithTags("c:3").Tags(), ","), "c:3")
+	if got := cl.TagFormat(); got != TagFormatInflux {
+		t.Fatalf("got tag format %v", got)
+	}
+	if got := cl.FlushInterval(); got != time.Hour {
+		t.Fatalf("got flush interval %v", got)
+	}
+	checkErr(cl.SetFlushInterval(0))
+	if got := cl.FlushInterval(); got != 0 {
+		t.Fatalf("got flush interval %v", got)
+	}
+
+	// The address is kept as given when it changes.
+	checkErr(cl.c.setAddr("udp://127.0.0.1:" + port))
+	assert(t, cl.Addr(), "udp://127.0.0.1:"+port)
+	assert(t, cl.RemoteAddr(), "127.0.0.1:"+port)
+
+	w := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer w.Close()
+	assert(t, w.Addr(), "")
+	assert(t, w.RemoteAddr(), "")
+}
+
+func TestWithTagsDoesNotShareBackingArray(t *testing.T) {
+	tc := newTestClient(t)
+	cl := (&Client{c: tc.client}).WithTags("a", "b", "c")
+	cl1 := cl.WithTags("x")
+	cl2 := cl.WithTags("y")
+	if err := cl1.Increment("one", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl2.Increment("two", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "one:1|c|#a,b,c,x\ntwo:1|c|#a,b,c,y")
+}
+
+// packetWriter is an io.Writer that records each packet written to it.
+type packetWriter struct {
+	packets *[]string
+}
+
+func (w packetWriter) Write(p []byte) (int, error) {
+	*w.packets = append(*w.packets, string(p))
+	return len(p), nil
+}
+
+// closeCountWriter is a packetWriter that records how many times
+// it has been closed.
+type closeCountWriter struct {
+	packetWriter
+	closed *int
+}
+
+func (c closeCountWriter) Close() error {
+	*c.closed++
+	return nil
+}
+
+func TestWithTagsConcurrent(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	const (
+		clients = 5
+		count   = 100
+	)
+	var wg sync.WaitGroup
+	for i := 0; i <= clients; i++ {
+		c := cl
+		if i > 0 {
+			c = cl.WithTags(fmt.Sprintf("client:%d", i))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				if err := c.Increment("incr", j, 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for i := 0; i <= clients; i++ {
+		for j := 0; j < count; j++ {
+			line := fmt.Sprintf("incr:%d|c", j)
+			if i > 0 {
+				line += fmt.Sprintf("|#client:%d", i)
+			}
+			want = append(want, line)
+		}
+	}
+	var got []string
+	for _, p := range packets {
+		if len(p) > defaultBufSize {
+			t.Errorf("packet too big (%d bytes)", len(p))
+		}
+		got = append(got, strings.Split(p, "\n")...)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	assert(t, strings.Join(got, "\n"), strings.Join(want, "\n"))
+}
+
+func TestCloseTwice(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cl, err := NewClient(ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 10)
+	cl.SetErrorFunc(func(err error) {
+		errc <- err
+	})
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The final flush reaches the server.
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "incr:1|c")
+
+	if err := cl.Close(); err != nil {
+		t.Fatalf("second Close returned %v", err)
+	}
+	// Metrics sent after Close are discarded, which is
+	// reported once.
+	for i := 0; i < 3; i++ {
+		if err := cl.Increment("incr", 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		cl.Timing("timing", 1, 1)
+		if err := cl.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-errc:
+		if !errors.Is(err, errClosed) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("discarded metrics not reported")
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("unexpected second error %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	ln.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, _, err := ln.ReadFrom(out); err == nil {
+		t.Fatalf("unexpected packet %q after Close", out[:n])
+	}
+}
+
+func TestClientMethods(t *testing.T) {
+	var packets []string
+	base := NewClientWriter(packetWriter{&packets}, 0)
+	base.SetNegativeGaugeReset(true)
+	base.SetDropZeroCounts(true)
+	if err := base.SetSizeUnit(KiB); err != nil {
+		t.Fatal(err)
+	}
+	cl := base.WithPrefix("app.").WithTags("env:test")
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(cl.Increment64("incr64", 1, 1))
+	checkErr(cl.Increment64("incr64", 0, 1))
+	checkErr(cl.IncrementBytes([]byte("bytes"), 2, 1))
+	checkErr(cl.IncrementFloat("float", 0.5, 1))
+	checkErr(cl.DurationBytes([]byte("bytes"), 3*time.Millisecond, 1))
+	checkErr(cl.Durations("durations", []time.Duration{time.Millisecond, 2 * time.Millisecond}, 1))
+	checkErr(cl.DurationN("durationn", 4*time.Millisecond, 2, 1))
+	checkErr(cl.DurationFloat("durationfloat", 1500*time.Microsecond, 1))
+	checkErr(cl.Gauge64("gauge64", -5, 1))
+	checkErr(cl.GaugeBytes([]byte("bytes"), 6, 1))
+	checkErr(ClientGaugeValue(cl, "value", 7.5, 1))
+	checkErr(cl.GaugeAt("at", 8, time.Unix(1700000000, 0)))
+	checkErr(cl.GaugeBool("bool", true, 1))
+	checkErr(cl.UniqueString("unique", "x", 1))
+	checkErr(cl.UniqueValue("uniquevalue", uint(9), 1))
+	checkErr(cl.Size("size", 2048, 1))
+	checkErr(cl.KeyValue("kv", 10))
+	checkErr(cl.Send("raw", "11", "c", 1, "x:y"))
+	checkErr(cl.NewCounter("counter", 1).Add(12))
+	checkErr(cl.NewTimer("timer", 1).Observe(13 * time.Millisecond))
+	checkErr(cl.NewGaugeHandle("handle", 1).Set(14))
+	checkErr(cl.Flush())
+	assert(t, strings.Join(packets, "\n"), strings.Join([]string{
+		"app.incr64:1|c|#env:test",
+		"app.bytes:2|c|#env:test",
+		"app.float:0.5|c|#env:test",
+		"app.bytes:3|ms|#env:test",
+		"app.durations:1|ms|#env:test",
+		"app.durations:2|ms|#env:test",
+		"app.durationn:4|ms|@0.5|#env:test",
+		"app.durationfloat:1.5|ms|#env:test",
+		"app.gauge64:0|g|#env:test",
+		"app.gauge64:-5|g|#env:test",
+		"app.bytes:6|g|#env:test",
+		"app.value:7.5|g|#env:test",
+		"app.at:8|g|#env:test|T1700000000",
+		"app.bool:1|g|#env:test",
+		"app.unique:x|s|#env:test",
+		"app.uniquevalue:9|s|#env:test",
+		"app.size:2|h|#env:test",
+		"app.kv:10|kv|#env:test",
+		"app.raw:11|c|#env:test,x:y",
+		"app.counter:12|c|#env:test",
+		"app.timer:13|ms|#env:test",
+		"app.handle:14|g|#env:test",
+	}, "\n"))
+}
+
+func TestClientSetAddr(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	defer cl.Close()
+	cl.Increment("before", 1, 1)
+
+	// Metrics buffered before the address changes
+	// are sent to the old destination.
+	if err := cl.SetAddr(ln.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, " "), "before:1|c")
+	assert(t, cl.Addr(), ln.LocalAddr().String())
+	cl.Increment("after", 1, 1)
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "after:1|c")
+}
+
+func TestBytesPrefix(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0).WithPrefix("app.")
+	cl.IncrementBytes([]byte("count"), 1, 1)
+	cl.DurationBytes([]byte("time"), time.Millisecond, 1)
+	cl.GaugeBytes([]byte("gauge"), 3, 1)
+	cl.IncrementBytes([]byte("tagged"), 1, 1, "env:prod")
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, " "), "app.count:1|c\napp.time:1|ms\napp.gauge:3|g\napp.tagged:1|c|#env:prod")
+}
+
+func TestBytesPrefixAllocs(t *testing.T) {
+	cl := (&Client{c: &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}}).WithPrefix("app.")
+	stat := []byte("requests.total")
+	allocs := testing.AllocsPerRun(100, func() {
+		cl.IncrementBytes(stat, 1, 1)
+		cl.DurationBytes(stat, time.Millisecond, 1)
+		cl.GaugeBytes(stat, 1, 1)
+	})
+	if allocs != 0 {
+		t.Fatalf("bytes variants with a prefix made %v allocations", allocs)
+	}
+}
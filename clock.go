@@ -0,0 +1,88 @@
+package statsd
+
+import "time"
+
+// Clock provides the current time and timers to a client, so that
+// the passage of time can be controlled, for example in tests.
+// See WithClock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the current
+	// time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+
+	// AfterFunc calls f in its own goroutine once d has
+	// elapsed, unless the returned timer is stopped first.
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer started by Clock.AfterFunc.
+type ClockTimer interface {
+	// Stop prevents the timer from firing. It reports
+	// whether the timer was stopped before it fired.
+	Stop() bool
+}
+
+// WithClock returns an option that makes the client take the time
+// from clock when timing functions, measuring durations and flushing
+// in the background. By default the system clock is used.
+func WithClock(clock Clock) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithClock"); err != nil {
+			return err
+		}
+		cl.c.setClock(clock)
+		return nil
+	}
+}
+
+// setClock sets the clock used by the client. Any background
+// flushing is restarted so that it uses the new clock.
+func (c *client) setClock(clock Clock) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.clock = clock
+	if c.flusher != nil {
+		interval := c.flusher.interval
+		c.stopFlusher()
+		c.startFlusher(interval)
+	}
+}
+
+// now returns the current time according to the client clock.
+func (c *client) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// since returns the time elapsed since t according
+// to the client clock.
+func (c *client) since(t time.Time) time.Duration {
+	if c.clock == nil {
+		return time.Since(t)
+	}
+	return c.clock.Now().Sub(t)
+}
+
+// after returns a channel that receives the current time once d
+// has elapsed according to the client clock.
+func (c *client) after(d time.Duration) <-chan time.Time {
+	if c.clock == nil {
+		return time.After(d)
+	}
+	return c.clock.After(d)
+}
+
+// afterFunc calls f in its own goroutine once d has elapsed
+// according to the client clock.
+func (c *client) afterFunc(d time.Duration, f func()) ClockTimer {
+	if c.clock == nil {
+		return time.AfterFunc(d, f)
+	}
+	return c.clock.AfterFunc(d, f)
+}
@@ -0,0 +1,149 @@
+package statsd
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when advanced.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	when  time.Time
+	fire  func(time.Time)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.addTimer(d, func(now time.Time) {
+		ch <- now
+	})
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return c.addTimer(d, func(time.Time) {
+		go f()
+	})
+}
+
+func (c *fakeClock) addTimer(d time.Duration, fire func(time.Time)) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{
+		clock: c,
+		when:  c.now.Add(d),
+		fire:  fire,
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t1 := range c.timers {
+		if t1 == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the time on by d, firing any timers that
+// become due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*fakeTimer
+	kept := c.timers[:0]
+	for _, t := range c.timers {
+		if t.when.After(now) {
+			kept = append(kept, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = kept
+	c.mu.Unlock()
+	for _, t := range due {
+		t.fire(now)
+	}
+}
+
+// waitTimers waits until at least n timers are pending,
+// so that advancing the clock fires them.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		c.mu.Lock()
+		pending := len(c.timers)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d pending timers, want %d", pending, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	clock := newFakeClock()
+	// The flushing started by WithFlushInterval uses
+	// the clock even though it is given later.
+	cl, err := New(ln.LocalAddr().String(), WithFlushInterval(time.Minute), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	d, err := cl.c.timeDuration("time", 1, func() {
+		clock.Advance(1500 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 1500*time.Millisecond {
+		t.Fatalf("got duration %v, want 1.5s", d)
+	}
+	cl.c.durationSince("since", clock.Now().Add(-time.Second), 1)
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute)
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "time:1500|ms\nsince:1000|ms")
+}
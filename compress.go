@@ -0,0 +1,78 @@
+package statsd
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"time"
+)
+
+// setCompression sets the gzip compression level used for stream
+// connections. See SetCompression for details.
+func (c *client) setCompression(level int) error {
+	if level != 0 {
+		if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+			return err
+		}
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !c.stream {
+		return errors.New("compression requires a TCP or TLS connection")
+	}
+	if level == c.compression {
+		return nil
+	}
+	// Send buffered metrics with the old setting, then redial,
+	// as the server expects a connection to be compressed from
+	// its start or not at all. An error flushing is passed to the
+	// error function.
+	if c.buf.Len() > 0 {
+		if err := c.flush(); err != nil {
+			c.reportErrorAsync(err)
+		}
+	}
+	c.compression = level
+	return c.connect()
+}
+
+// gzipConn compresses the data written to a stream connection.
+type gzipConn struct {
+	conn io.WriteCloser
+	zw   *gzip.Writer
+}
+
+func newGzipConn(conn io.WriteCloser, level int) *gzipConn {
+	// The level has been checked by setCompression.
+	zw, _ := gzip.NewWriterLevel(conn, level)
+	return &gzipConn{conn, zw}
+}
+
+// Write compresses p and writes it to the connection
+// immediately, so that it is not held in the compressor.
+func (c *gzipConn) Write(p []byte) (int, error) {
+	n, err := c.zw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.zw.Flush()
+}
+
+// Close ends the compressed stream and closes the connection.
+func (c *gzipConn) Close() error {
+	err := c.zw.Close()
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SetWriteDeadline sets the write deadline of the
+// connection, if it supports deadlines.
+func (c *gzipConn) SetWriteDeadline(t time.Time) error {
+	if conn, ok := c.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return conn.SetWriteDeadline(t)
+	}
+	return nil
+}
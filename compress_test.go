@@ -0,0 +1,251 @@
+package statsd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveGzipLines accepts a single connection from ln and sends
+// the lines decompressed from it on the returned channel, which
+// is closed when the compressed stream ends.
+func serveGzipLines(t *testing.T, ln net.Listener) <-chan string {
+	lines := make(chan string, 100)
+	go func() {
+		defer close(lines)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		zr, err := gzip.NewReader(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		scanner := bufio.NewScanner(zr)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			t.Error(err)
+		}
+	}()
+	return lines
+}
+
+func receiveLine(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for line")
+	}
+	panic("unreachable")
+}
+
+func TestCompression(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cl, err := NewClient("tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setting the compression redials, so the server
+	// only sees the compressed connection.
+	if err := cl.SetCompression(gzip.BestCompression); err != nil {
+		t.Fatal(err)
+	}
+	(<-acceptOne(t, ln)).Close()
+	lines := serveGzipLines(t, ln)
+
+	cl.Increment("incr", 1, 1)
+	cl.Gauge("gauge", 2, 1)
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Flushed metrics arrive without waiting for Close.
+	assert(t, receiveLine(t, lines), "incr:1|c")
+	assert(t, receiveLine(t, lines), "gauge:2|g")
+
+	cl.Increment("incr", 3, 1)
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, receiveLine(t, lines), "incr:3|c")
+	// Close ends the gzip stream cleanly.
+	if line, ok := <-lines; ok {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
+
+// acceptOne returns a channel receiving the next
+// connection accepted by ln.
+func acceptOne(t *testing.T, ln net.Listener) <-chan net.Conn {
+	c := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Error(err)
+			close(c)
+			return
+		}
+		c <- conn
+	}()
+	return c
+}
+
+func TestWithCompression(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// The server expects the first connection it
+	// accepts to be compressed.
+	lines := serveGzipLines(t, ln)
+	cl, err := New("tcp://"+ln.Addr().String(), WithCompression(gzip.BestSpeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("incr", 1, 1)
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, receiveLine(t, lines), "incr:1|c")
+	if _, ok := <-lines; ok {
+		t.Fatal("unexpected line")
+	}
+}
+
+func TestWithCompressionErrors(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8125", "udp://127.0.0.1:8125"} {
+		_, err := New(addr, WithCompression(gzip.DefaultCompression))
+		if err == nil {
+			t.Fatalf("no error for %q", addr)
+		}
+		assert(t, err.Error(), "compression requires a TCP or TLS connection")
+	}
+	if _, err := New("tcp://127.0.0.1:8125", WithCompression(42)); err == nil {
+		t.Fatal("no error for invalid level")
+	}
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	_, err := cl.Clone(WithCompression(gzip.DefaultCompression))
+	if err == nil {
+		t.Fatal("no error from Clone")
+	}
+	assert(t, err.Error(), "WithCompression cannot be used with Clone")
+}
+
+func TestCompressionUDP(t *testing.T) {
+	cl, err := NewClient("127.0.0.1:8125")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	err = cl.SetCompression(gzip.DefaultCompression)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	assert(t, err.Error(), "compression requires a TCP or TLS connection")
+}
+
+func TestCompressionInvalidLevel(t *testing.T) {
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	if err := cl.SetCompression(42); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCompressionFlushError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cl, err := NewClient("tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	errs := make(chan error, 1)
+	cl.SetErrorFunc(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	writes := 0
+	cl.c.m.Lock()
+	cl.c.closeConn()
+	cl.c.conn = writerConn{errWriter{&writes}}
+	cl.c.m.Unlock()
+
+	// The compression is still changed when buffered
+	// metrics cannot be sent, but the error is reported.
+	cl.Increment("incr", 1, 1)
+	if err := cl.SetCompression(gzip.BestSpeed); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		assert(t, err.Error(), "write failed")
+	case <-time.After(3 * time.Second):
+		t.Fatal("no error reported")
+	}
+}
+
+func TestCompressionSetAddrConcurrent(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	addr := "tcp://" + ln.Addr().String()
+	cl, err := NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if err := cl.SetCompression(gzip.BestSpeed); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.SetFlushThreshold(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections closed by SetAddr must not be
+	// written to by flushes in progress.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			cl.Increment("incr", 1, 1)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		cl.c.setAddr(addr)
+	}
+}
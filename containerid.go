@@ -0,0 +1,68 @@
+package statsd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// cgroupPath holds the file read by DetectContainerID. It is a
+// variable so that tests can use another file.
+var cgroupPath = "/proc/self/cgroup"
+
+// containerIDPattern matches the container IDs used by Docker,
+// containerd and similar runtimes, and by ECS tasks, as found at the
+// end of a cgroup path.
+var containerIDPattern = regexp.MustCompile(`(?:^|[/-])([0-9a-f]{64}|[0-9a-f]{32}-[0-9]+|[0-9a-f]{8}(?:-[0-9a-f]{4}){4})(?:\.scope)?$`)
+
+// DetectContainerID returns the ID of the container the current
+// process runs in, as found in /proc/self/cgroup, for use with
+// SetContainerID. It returns an empty string and no error if the
+// process does not appear to run in a container. It only works on
+// Linux, and not with cgroup namespaces that hide the container's
+// cgroup path.
+func DetectContainerID() (string, error) {
+	f, err := os.Open(cgroupPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+	return parseContainerID(f)
+}
+
+// parseContainerID returns the container ID found in r, which
+// holds the contents of a /proc/<pid>/cgroup file, or an empty
+// string if there is none.
+func parseContainerID(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Each line is of the form "hierarchy:controllers:path".
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		if m := containerIDPattern.FindStringSubmatch(fields[2]); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// setContainerID sets the container ID sent with every metric.
+// See SetContainerID for details.
+func (c *client) setContainerID(id string) error {
+	if strings.ContainsAny(id, "|,#:\n") {
+		return fmt.Errorf("invalid container ID %q", id)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.containerID = id
+	return nil
+}
@@ -0,0 +1,144 @@
+package statsd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainerID(t *testing.T) {
+	tc := newTestClient(t)
+	tc.client.setNegativeGaugeReset(true)
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(tc.client.setContainerID("abc123"))
+	checkErr(tc.client.increment("incr", 1, 1))
+	checkErr(tc.client.increment("incr", 1, 0.99, "x"))
+	checkErr(tc.client.gauge("gauge", -1, 1))
+	checkErr(tc.client.incrementBytes("", []byte("bytes"), 2, 1))
+	checkErr(tc.client.counter("ctr", 1).Add(3))
+	checkErr(tc.client.send(Metric{Stat: "ts", Value: "1", Kind: "g", Rate: 1, Tags: []string{"x"}, Timestamp: time.Unix(1700000000, 0)}))
+	checkErr(tc.client.setGlobalTags([]string{"env:prod"}))
+	checkErr(tc.client.increment("incr", 1, 1))
+	checkErr(tc.client.setContainerID(""))
+	checkErr(tc.client.increment("incr", 1, 1))
+	checkErr(tc.client.counter("ctr", 1).Add(3))
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr:1|c|c:abc123",
+		"incr:1|c|@0.99|#x|c:abc123",
+		"gauge:0|g|c:abc123",
+		"gauge:-1|g|c:abc123",
+		"bytes:2|c|c:abc123",
+		"ctr:3|c|c:abc123",
+		"ts:1|g|#x|c:abc123|T1700000000",
+		"incr:1|c|#env:prod|c:abc123",
+		"incr:1|c|#env:prod",
+		"ctr:3|c|#env:prod",
+	}, "\n"))
+}
+
+func TestContainerIDOtherFormat(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setTagFormat(TagFormatInflux); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setContainerID("abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.increment("incr", 1, 1, "a:b"); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr,a=b:1|c")
+}
+
+func TestContainerIDTooBig(t *testing.T) {
+	tc := newTestClient(t)
+	id := strings.Repeat("a", defaultBufSize-len("incr:1|c|c:"))
+	if err := tc.client.setContainerID(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.increment("incr", 10, 1); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c|c:"+id)
+}
+
+func TestInvalidContainerID(t *testing.T) {
+	tc := newTestClient(t)
+	for _, id := range []string{"a|b", "a,b", "a#b", "a:b", "a\nb"} {
+		if err := tc.client.setContainerID(id); err == nil {
+			t.Errorf("no error from invalid container ID %q", id)
+		}
+	}
+	if err := tc.client.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c")
+}
+
+var parseContainerIDTests = []struct {
+	cgroup string
+	want   string
+}{{
+	cgroup: "12:memory:/docker/3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860\n",
+	want:   "3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860",
+}, {
+	cgroup: "0::/system.slice/docker-3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860.scope\n",
+	want:   "3726184226f5d3147c25fdeab5b60097e378e8a720503a5e19ecfdf29f869860",
+}, {
+	cgroup: "1:name=systemd:/kubepods/besteffort/pod3d274242-8ee0-11e9-a8a6-1e68d864ef1a/3e74d3fd9db4c9dd921ae05c2502fb984d0cde1b36e581b13f79c639da4518a1\n",
+	want:   "3e74d3fd9db4c9dd921ae05c2502fb984d0cde1b36e581b13f79c639da4518a1",
+}, {
+	cgroup: "3:cpu:/ecs/55091c13-b8cf-4801-b527-f4601742204d/34dc0b5e626f2c5c4c5170e34b10e765-1234567890\n",
+	want:   "34dc0b5e626f2c5c4c5170e34b10e765-1234567890",
+}, {
+	cgroup: "1:name=systemd:/user.slice/user-1000.slice/session-2.scope\n0::/init.scope\n",
+	want:   "",
+}, {
+	cgroup: "",
+	want:   "",
+}}
+
+func TestParseContainerID(t *testing.T) {
+	for i, test := range parseContainerIDTests {
+		got, err := parseContainerID(strings.NewReader(test.cgroup))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%d: got %q, want %q", i, got, test.want)
+		}
+	}
+}
+
+func TestDetectContainerID(t *testing.T) {
+	old := cgroupPath
+	defer func() {
+		cgroupPath = old
+	}()
+	cgroupPath = filepath.Join(t.TempDir(), "cgroup")
+	id, err := DetectContainerID()
+	if err != nil || id != "" {
+		t.Fatalf("got %q, %v from missing file", id, err)
+	}
+	if err := os.WriteFile(cgroupPath, []byte(parseContainerIDTests[0].cgroup), 0666); err != nil {
+		t.Fatal(err)
+	}
+	id, err = DetectContainerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, id, parseContainerIDTests[0].want)
+}
@@ -0,0 +1,106 @@
+package statsd
+
+import (
+	"context"
+	"time"
+)
+
+// contextTagsKey is the context key for tags added by NewContext.
+type contextTagsKey struct{}
+
+// NewContext returns a copy of ctx that carries the given tags in
+// addition to any tags already carried by ctx. The tags are sent with
+// metrics recorded by the Client methods with a Ctx suffix, such as
+// IncrementCtx.
+func NewContext(ctx context.Context, tags ...string) context.Context {
+	parent := ContextTags(ctx)
+	return context.WithValue(ctx, contextTagsKey{}, append(parent[:len(parent):len(parent)], tags...))
+}
+
+// ContextTags returns the tags carried by ctx, outermost first,
+// or nil if there are none.
+func ContextTags(ctx context.Context) []string {
+	tags, _ := ctx.Value(contextTagsKey{}).([]string)
+	return tags
+}
+
+// contextTags returns the tags sent with a metric given the context and
+// the tags passed in the call. In order, these are the default tags of
+// cl, the tags carried by ctx and the tags passed in the call.
+func (cl *Client) contextTags(ctx context.Context, tags []string) []string {
+	ctxTags := ContextTags(ctx)
+	if len(ctxTags) == 0 {
+		return cl.metricTags(tags)
+	}
+	if len(cl.tags) == 0 && len(tags) == 0 {
+		return ctxTags
+	}
+	all := make([]string, 0, len(cl.tags)+len(ctxTags)+len(tags))
+	all = append(all, cl.tags...)
+	all = append(all, ctxTags...)
+	return append(all, tags...)
+}
+
+// IncrementCtx is like Increment but also sends the tags carried by
+// ctx. Tags are always sent in this order: the global tags set with
+// SetGlobalTags, the default tags of cl, the tags carried by ctx and
+// then the tags passed in the call. The other Ctx methods behave
+// similarly.
+func (cl *Client) IncrementCtx(ctx context.Context, stat string, count int, rate float64, tags ...string) error {
+	return cl.c.increment(cl.stat(stat), count, rate, cl.contextTags(ctx, tags)...)
+}
+
+// DecrementCtx is like Decrement but also sends the tags carried by ctx.
+func (cl *Client) DecrementCtx(ctx context.Context, stat string, count int, rate float64, tags ...string) error {
+	return cl.c.decrement(cl.stat(stat), count, rate, cl.contextTags(ctx, tags)...)
+}
+
+// DurationCtx is like Duration but also sends the tags carried by ctx.
+func (cl *Client) DurationCtx(ctx context.Context, stat string, duration time.Duration, rate float64, tags ...string) error {
+	return cl.c.duration(cl.stat(stat), duration, rate, cl.contextTags(ctx, tags)...)
+}
+
+// TimingCtx is like Timing but also sends the tags carried by ctx.
+func (cl *Client) TimingCtx(ctx context.Context, stat string, delta int, rate float64, tags ...string) error {
+	return cl.c.timing(cl.stat(stat), delta, rate, cl.contextTags(ctx, tags)...)
+}
+
+// TimingFloatCtx is like TimingFloat but also sends the tags carried by ctx.
+func (cl *Client) TimingFloatCtx(ctx context.Context, stat string, delta float64, rate float64, tags ...string) error {
+	return cl.c.timingFloat(cl.stat(stat), delta, rate, cl.contextTags(ctx, tags)...)
+}
+
+// GaugeCtx is like Gauge but also sends the tags carried by ctx.
+func (cl *Client) GaugeCtx(ctx context.Context, stat string, value int, rate float64, tags ...string) error {
+	return cl.c.gauge(cl.stat(stat), value, rate, cl.contextTags(ctx, tags)...)
+}
+
+// GaugeFloat64Ctx is like GaugeFloat64 but also sends the tags carried by ctx.
+func (cl *Client) GaugeFloat64Ctx(ctx context.Context, stat string, value float64, rate float64, tags ...string) error {
+	return cl.c.gaugeFloat64(cl.stat(stat), value, rate, cl.contextTags(ctx, tags)...)
+}
+
+// IncrementGaugeCtx is like IncrementGauge but also sends the tags carried by ctx.
+func (cl *Client) IncrementGaugeCtx(ctx context.Context, stat string, value int, rate float64, tags ...string) error {
+	return cl.c.incrementGauge(cl.stat(stat), value, rate, cl.contextTags(ctx, tags)...)
+}
+
+// DecrementGaugeCtx is like DecrementGauge but also sends the tags carried by ctx.
+func (cl *Client) DecrementGaugeCtx(ctx context.Context, stat string, value int, rate float64, tags ...string) error {
+	return cl.c.decrementGauge(cl.stat(stat), value, rate, cl.contextTags(ctx, tags)...)
+}
+
+// UniqueCtx is like Unique but also sends the tags carried by ctx.
+func (cl *Client) UniqueCtx(ctx context.Context, stat string, value int, rate float64, tags ...string) error {
+	return cl.c.unique(cl.stat(stat), value, rate, cl.contextTags(ctx, tags)...)
+}
+
+// HistogramCtx is like Histogram but also sends the tags carried by ctx.
+func (cl *Client) HistogramCtx(ctx context.Context, stat string, value float64, rate float64, tags ...string) error {
+	return cl.c.histogram(cl.stat(stat), value, rate, cl.contextTags(ctx, tags)...)
+}
+
+// DistributionCtx is like Distribution but also sends the tags carried by ctx.
+func (cl *Client) DistributionCtx(ctx context.Context, stat string, value float64, rate float64, tags ...string) error {
+	return cl.c.distribution(cl.stat(stat), value, rate, cl.contextTags(ctx, tags)...)
+}
@@ -0,0 +1,88 @@
+package statsd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextTags(t *testing.T) {
+	ctx := context.Background()
+	if tags := ContextTags(ctx); tags != nil {
+		t.Fatalf("unexpected tags %q", tags)
+	}
+	ctx1 := NewContext(ctx, "route:/users", "tenant:x")
+	ctx2 := NewContext(ctx1, "region:eu")
+	ctx3 := NewContext(ctx1, "region:us")
+	assert(t, strings.Join(ContextTags(ctx1), ","), "route:/users,tenant:x")
+	assert(t, strings.Join(ContextTags(ctx2), ","), "route:/users,tenant:x,region:eu")
+	assert(t, strings.Join(ContextTags(ctx3), ","), "route:/users,tenant:x,region:us")
+}
+
+func TestClientCtx(t *testing.T) {
+	tc := newTestClient(t)
+	// Keep all the metrics in a single packet.
+	tc.client.size = 4096
+	cl := &Client{c: tc.client}
+	if err := cl.SetGlobalTags("host:a"); err != nil {
+		t.Fatal(err)
+	}
+	sub := cl.WithTags("client:1")
+	ctx := NewContext(context.Background(), "route:/users")
+	nested := NewContext(ctx, "region:eu")
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(cl.IncrementCtx(context.Background(), "incr", 1, 1))
+	checkErr(cl.IncrementCtx(context.Background(), "incr", 1, 1, "x"))
+	checkErr(cl.IncrementCtx(ctx, "incr", 1, 1))
+	checkErr(cl.IncrementCtx(nested, "incr", 1, 1, "x"))
+	checkErr(sub.IncrementCtx(nested, "incr", 1, 1, "x"))
+	checkErr(sub.DecrementCtx(ctx, "decr", 1, 1))
+	checkErr(sub.DurationCtx(ctx, "timing", time.Second, 1))
+	checkErr(sub.TimingCtx(ctx, "timing", 5, 1))
+	checkErr(sub.TimingFloatCtx(ctx, "timing", 0.5, 1))
+	checkErr(sub.GaugeCtx(ctx, "gauge", 3, 1))
+	checkErr(sub.GaugeFloat64Ctx(ctx, "gauge", 0.5, 1))
+	checkErr(sub.IncrementGaugeCtx(ctx, "gauge", 3, 1))
+	checkErr(sub.DecrementGaugeCtx(ctx, "gauge", 3, 1))
+	checkErr(sub.UniqueCtx(ctx, "unique", 765, 1))
+	checkErr(sub.HistogramCtx(ctx, "hist", 1.5, 1))
+	checkErr(sub.DistributionCtx(ctx, "dist", 1.5, 1))
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr:1|c|#host:a",
+		"incr:1|c|#host:a,x",
+		"incr:1|c|#host:a,route:/users",
+		"incr:1|c|#host:a,route:/users,region:eu,x",
+		"incr:1|c|#host:a,client:1,route:/users,region:eu,x",
+		"decr:-1|c|#host:a,client:1,route:/users",
+		"timing:1000|ms|#host:a,client:1,route:/users",
+		"timing:5|ms|#host:a,client:1,route:/users",
+		"timing:0.5|ms|#host:a,client:1,route:/users",
+		"gauge:3|g|#host:a,client:1,route:/users",
+		"gauge:0.5|g|#host:a,client:1,route:/users",
+		"gauge:+3|g|#host:a,client:1,route:/users",
+		"gauge:-3|g|#host:a,client:1,route:/users",
+		"unique:765|s|#host:a,client:1,route:/users",
+		"hist:1.5|h|#host:a,client:1,route:/users",
+		"dist:1.5|d|#host:a,client:1,route:/users",
+	}, "\n"))
+}
+
+func TestInvalidContextTags(t *testing.T) {
+	tc := newTestClient(t)
+	cl := &Client{c: tc.client}
+	if err := cl.SetStrict(true); err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewContext(context.Background(), "a|b")
+	if err := cl.IncrementCtx(ctx, "incr", 1, 1); err == nil {
+		t.Fatal("no error from invalid context tag")
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
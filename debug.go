@@ -0,0 +1,66 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// debugString returns a description of the configuration and state of
+// the client, one "name: value" line for each item, for diagnosing
+// problems. The prefix of the calling client is given by clientPrefix.
+// Buffered metrics are only included if includeBuffer is true.
+func (c *client) debugString(clientPrefix string, includeBuffer bool) string {
+	stats := c.stats()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var b strings.Builder
+	line := func(name string, value any) {
+		fmt.Fprintf(&b, "%s: %v\n", name, value)
+	}
+	line("addr", fmt.Sprintf("%q", c.addr))
+	remote, transport := c.remoteAddr()
+	switch {
+	case c.writer:
+		transport = "writer"
+	case c.conn == nil:
+		transport = "none"
+	case c.tlsConfig != nil:
+		transport += "+tls"
+	}
+	if c.compression != 0 && c.stream {
+		transport += "+gzip"
+	}
+	line("remote addr", fmt.Sprintf("%q", remote))
+	line("transport", transport)
+	line("packet size", c.size)
+	line("prefix", fmt.Sprintf("%q", c.prefix+clientPrefix))
+	line("separator", fmt.Sprintf("%q", c.separator))
+	line("tag format", c.tagFormat)
+	var flushInterval time.Duration
+	if c.flusher != nil {
+		flushInterval = c.flusher.interval
+	}
+	line("flush interval", flushInterval)
+	line("linger", c.linger)
+	line("async", c.async.Load() != nil)
+	line("enabled", !c.disabled)
+	line("closed", c.closed)
+	line("recorded", stats.Recorded)
+	line("sampled out", stats.SampledOut)
+	line("packets written", stats.PacketsWritten)
+	line("bytes written", stats.BytesWritten)
+	line("write errors", stats.WriteErrors)
+	line("callback panics", stats.CallbackPanics)
+	line("dropped", stats.Dropped)
+	line("too big", stats.TooBig)
+	line("truncated", stats.Truncated)
+	line("pending metrics", c.buffered)
+	line("pending bytes", c.buf.Len())
+	if includeBuffer {
+		line("buffer", fmt.Sprintf("%q", c.buf.Bytes()))
+	}
+	return b.String()
+}
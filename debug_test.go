@@ -0,0 +1,65 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugString(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	addr := ln.LocalAddr().String()
+	cl, err := New(addr, WithPrefix("app."), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	cl.Increment("secret", 1, 1)
+	cl.Flush()
+	cl.Increment("secret", 2, 1)
+
+	s := cl.WithPrefix("api.").DebugString(false)
+	for _, want := range []string{
+		"addr: \"" + addr + "\"\n",
+		"remote addr: \"" + addr + "\"\n",
+		"transport: udp\n",
+		"packet size: 512\n",
+		"prefix: \"app.api.\"\n",
+		"tag format: DogStatsD\n",
+		"flush interval: 1h0m0s\n",
+		"enabled: true\n",
+		"recorded: 2\n",
+		"packets written: 1\n",
+		"bytes written: 14\n",
+		"pending metrics: 1\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("%q not found in debug string:\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, "secret") {
+		t.Errorf("debug string includes buffered metrics:\n%s", s)
+	}
+
+	s = cl.DebugString(true)
+	if want := "buffer: \"app.secret:2|c\"\n"; !strings.HasSuffix(s, want) {
+		t.Errorf("%q not found at end of debug string:\n%s", want, s)
+	}
+}
+
+func TestDebugStringWriter(t *testing.T) {
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	cl.SetEnabled(false)
+	s := cl.DebugString(false)
+	for _, want := range []string{"transport: writer\n", "enabled: false\n"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("%q not found in debug string:\n%s", want, s)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package statsd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewFromEnv returns a client configured from the environment
+// variables below, which suits deployments configured through the
+// environment. Only STATSD_ADDR is required; if it is unset or empty,
+// a client that discards all metrics is returned, so that programs
+// run without a statsd server, for example in development.
+//
+//	STATSD_ADDR            address, as for SetAddr
+//	STATSD_PREFIX          prefix of every bucket name, as for WithPrefix
+//	STATSD_PACKET_SIZE     packet size in bytes, as for SetPacketSize
+//	STATSD_FLUSH_INTERVAL  duration such as "1s", as for SetFlushInterval
+//	STATSD_TAGS            comma-separated tags, as for SetGlobalTags
+//
+// An error naming the variable is returned if a value is invalid.
+func NewFromEnv() (*Client, error) {
+	addr := os.Getenv("STATSD_ADDR")
+	if addr == "" {
+		return NewClientWriter(io.Discard, 0), nil
+	}
+	if _, _, err := parseAddr(addr); err != nil {
+		return nil, fmt.Errorf("invalid STATSD_ADDR: %v", err)
+	}
+	var opts []Option
+	if v := os.Getenv("STATSD_PREFIX"); v != "" {
+		opts = append(opts, WithPrefix(v))
+	}
+	if v := os.Getenv("STATSD_PACKET_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATSD_PACKET_SIZE %q: not an integer", v)
+		}
+		opts = append(opts, envOption("STATSD_PACKET_SIZE", WithPacketSize(size)))
+	}
+	if v := os.Getenv("STATSD_FLUSH_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid STATSD_FLUSH_INTERVAL %q: %v", v, err)
+		}
+		opts = append(opts, envOption("STATSD_FLUSH_INTERVAL", WithFlushInterval(interval)))
+	}
+	if v := os.Getenv("STATSD_TAGS"); v != "" {
+		opts = append(opts, envOption("STATSD_TAGS", WithGlobalTags(strings.Split(v, ",")...)))
+	}
+	return New(addr, opts...)
+}
+
+// envOption returns an option that applies opt, adding the name
+// of the environment variable that it came from to any error.
+func envOption(name string, opt Option) Option {
+	return func(cl *Client) error {
+		if err := opt(cl); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return nil
+	}
+}
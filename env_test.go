@@ -0,0 +1,80 @@
+package statsd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewFromEnv(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	t.Setenv("STATSD_ADDR", ln.LocalAddr().String())
+	t.Setenv("STATSD_PREFIX", "app.")
+	t.Setenv("STATSD_PACKET_SIZE", "1024")
+	t.Setenv("STATSD_FLUSH_INTERVAL", "10ms")
+	t.Setenv("STATSD_TAGS", "env:test,region:eu")
+	cl, err := NewFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if cl.c.size != 1024 {
+		t.Errorf("got packet size %d, want 1024", cl.c.size)
+	}
+	// The metric is flushed in the background.
+	cl.Increment("requests", 1, 1)
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 1024)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "app.requests:1|c|#env:test,region:eu")
+}
+
+func TestNewFromEnvUnset(t *testing.T) {
+	t.Setenv("STATSD_ADDR", "")
+	// Other variables are ignored.
+	t.Setenv("STATSD_PACKET_SIZE", "x")
+	cl, err := NewFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Increment("a", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewFromEnvInvalid(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{"STATSD_ADDR", "::1:8125", "invalid STATSD_ADDR"},
+		{"STATSD_PACKET_SIZE", "big", `invalid STATSD_PACKET_SIZE "big"`},
+		{"STATSD_PACKET_SIZE", "-1", "invalid STATSD_PACKET_SIZE: invalid packet size -1"},
+		{"STATSD_FLUSH_INTERVAL", "1", `invalid STATSD_FLUSH_INTERVAL "1"`},
+		{"STATSD_FLUSH_INTERVAL", "-1s", "invalid STATSD_FLUSH_INTERVAL: negative flush interval"},
+	}
+	for _, test := range tests {
+		t.Run(test.name+"="+test.value, func(t *testing.T) {
+			t.Setenv("STATSD_ADDR", "127.0.0.1:8125")
+			t.Setenv(test.name, test.value)
+			cl, err := NewFromEnv()
+			if err == nil {
+				cl.Close()
+				t.Fatalf("no error, want %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got error %q, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFailoverThreshold     = 3
+	defaultFailoverProbeInterval = 30 * time.Second
+)
+
+// FailoverOption configures a client created by NewClientFailover.
+type FailoverOption func(*failover)
+
+// FailoverThreshold sets the number of consecutive failed flushes after
+// which the client switches to the next address. The default is 3.
+func FailoverThreshold(n int) FailoverOption {
+	return func(f *failover) {
+		f.threshold = n
+	}
+}
+
+// FailoverProbeInterval sets how often a client that is not using the
+// first address tries to switch back to it. The default is 30 seconds.
+func FailoverProbeInterval(d time.Duration) FailoverOption {
+	return func(f *failover) {
+		f.probeInterval = d
+	}
+}
+
+// failover holds the state of a client that switches between
+// several addresses.
+type failover struct {
+	addrs         []string
+	threshold     int
+	probeInterval time.Duration
+
+	// current holds the index in addrs of the address in use.
+	current int
+
+	// failures holds the number of consecutive failed flushes
+	// to the current address.
+	failures int
+
+	// events holds state transitions to be passed to the error
+	// function, which cannot be called with the client mutex
+	// lock held.
+	events chan error
+
+	stop chan struct{}
+}
+
+// NewClientFailover returns a client that sends metrics to the first of
+// the given addresses, which are interpreted as described for SetAddr,
+// that it can connect to. After a number of consecutive flushes to an
+// address fail, as set by FailoverThreshold, the client switches to the
+// next address in the list, wrapping around at the end. While it is not
+// using the first address, the client periodically tries to connect to
+// it, as set by FailoverProbeInterval, and switches back if it can.
+// Each switch is reported to the function set with SetErrorFunc as a
+// *FailoverError.
+//
+// Because UDP is connectionless, a successful write does not mean that
+// a server received the metrics. Failures are mostly detected when a
+// hostname cannot be resolved, or when the operating system reports
+// an earlier write as refused because nothing was listening, which
+// does not happen on all networks. For the same reason, switching back
+// to the first address only checks that its hostname resolves.
+func NewClientFailover(addrs []string, opts ...FailoverOption) (*Client, error) {
+	c := newClient()
+	if err := c.setFailover(addrs, opts); err != nil {
+		return nil, err
+	}
+	return &Client{c: c}, nil
+}
+
+// FailoverError describes a switch between addresses made by
+// a client created with NewClientFailover.
+type FailoverError struct {
+	// From and To hold the addresses switched from and to.
+	From, To string
+
+	// Err holds the error that caused the switch, or nil when
+	// switching back to the first address.
+	Err error
+}
+
+func (e *FailoverError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("statsd failing back from %s to %s", e.From, e.To)
+	}
+	return fmt.Sprintf("statsd failing over from %s to %s: %v", e.From, e.To, e.Err)
+}
+
+func (e *FailoverError) Unwrap() error {
+	return e.Err
+}
+
+// setFailover makes c switch between the given addresses
+// and connects to the first one possible.
+func (c *client) setFailover(addrs []string, opts []FailoverOption) error {
+	if len(addrs) == 0 {
+		return errors.New("no addresses")
+	}
+	f := &failover{
+		addrs:         append([]string(nil), addrs...),
+		threshold:     defaultFailoverThreshold,
+		probeInterval: defaultFailoverProbeInterval,
+		events:        make(chan error, 16),
+		stop:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.threshold < 1 {
+		return fmt.Errorf("invalid failover threshold %d", f.threshold)
+	}
+	if f.probeInterval <= 0 {
+		return fmt.Errorf("invalid failover probe interval %v", f.probeInterval)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var errs []string
+	for i, addr := range f.addrs {
+		c.addr = addr
+		err := c.connect()
+		if err == nil {
+			f.current = i
+			c.failover = f
+			go c.probeFailover(f)
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("cannot connect to any address: %s", strings.Join(errs, "; "))
+}
+
+// stopFailover stops switching between addresses. Caller must hold
+// the client mutex lock.
+func (c *client) stopFailover() {
+	if c.failover != nil {
+		close(c.failover.stop)
+		c.failover = nil
+	}
+}
+
+// record records the result of a flush, switching to the next address
+// if there have been too many consecutive failures. Caller must hold
+// the client mutex lock.
+func (f *failover) record(c *client, err error) {
+	if err == nil {
+		f.failures = 0
+		return
+	}
+	f.failures++
+	if f.failures < f.threshold || len(f.addrs) == 1 {
+		return
+	}
+	from := f.addrs[f.current]
+	f.switchTo(c, (f.current+1)%len(f.addrs))
+	f.notify(&FailoverError{
+		From: from,
+		To:   f.addrs[f.current],
+		Err:  err,
+	})
+}
+
+// switchTo makes the client use the address with the given index,
+// connecting to it on the next flush. Caller must hold the client mutex
+// lock.
+func (f *failover) switchTo(c *client, i int) {
+	f.current = i
+	f.failures = 0
+	c.addr = f.addrs[i]
+	c.remoteIP = ""
+	c.stopReconnect()
+	c.closeConn()
+}
+
+// notify queues err to be passed to the error function. If too many
+// errors are queued, err is discarded.
+func (f *failover) notify(err error) {
+	select {
+	case f.events <- err:
+	default:
+	}
+}
+
+// probeFailover passes queued state transitions to the error function
+// and periodically tries to switch back to the first address, until f
+// is stopped.
+func (c *client) probeFailover(f *failover) {
+	ticker := time.NewTicker(f.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-f.events:
+			c.reportError(err)
+			continue
+		case <-ticker.C:
+		case <-f.stop:
+			for {
+				select {
+				case err := <-f.events:
+					c.reportError(err)
+				default:
+					return
+				}
+			}
+		}
+		c.m.Lock()
+		probe := c.failover == f && f.current != 0
+		c.m.Unlock()
+		if !probe {
+			continue
+		}
+		// Dial without the lock held, as resolving
+		// the address may take a while.
+		conn, err := c.dialAddr(f.addrs[0])
+		if err != nil {
+			continue
+		}
+		c.m.Lock()
+		if c.failover != f || f.current == 0 {
+			c.m.Unlock()
+			conn.Close()
+			continue
+		}
+		if c.buf.Len() > 0 {
+			// Send any buffered metrics to the address they
+			// were recorded for. A failure is recorded by
+			// flush, and may itself switch addresses.
+			c.flush()
+		}
+		if f.current == 0 {
+			c.m.Unlock()
+			conn.Close()
+			continue
+		}
+		from := f.addrs[f.current]
+		f.switchTo(c, 0)
+		c.setConn(conn)
+		f.notify(&FailoverError{
+			From: from,
+			To:   f.addrs[0],
+		})
+		c.m.Unlock()
+	}
+}
@@ -0,0 +1,247 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNet simulates a set of statsd servers for failover tests.
+type fakeNet struct {
+	mu sync.Mutex
+
+	// down holds addresses that cannot be resolved.
+	down map[string]bool
+
+	// failing holds addresses to which writes fail.
+	failing map[string]bool
+
+	// packets holds the packets received by each address.
+	packets map[string][]string
+}
+
+func newFakeNet() *fakeNet {
+	return &fakeNet{
+		down:    make(map[string]bool),
+		failing: make(map[string]bool),
+		packets: make(map[string][]string),
+	}
+}
+
+func (n *fakeNet) dial(network, address string) (io.WriteCloser, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.down[address] {
+		return nil, fmt.Errorf("lookup %s: no such host", address)
+	}
+	return fakeNetConn{n, address}, nil
+}
+
+func (n *fakeNet) set(m map[string]bool, addr string, v bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	m[addr] = v
+}
+
+func (n *fakeNet) received(addr string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return strings.Join(n.packets[addr], "\n--\n")
+}
+
+type fakeNetConn struct {
+	n    *fakeNet
+	addr string
+}
+
+func (c fakeNetConn) Write(p []byte) (int, error) {
+	c.n.mu.Lock()
+	defer c.n.mu.Unlock()
+	if c.n.failing[c.addr] {
+		return 0, errors.New("connection refused")
+	}
+	c.n.packets[c.addr] = append(c.n.packets[c.addr], string(p))
+	return len(p), nil
+}
+
+func (c fakeNetConn) Close() error {
+	return nil
+}
+
+// newFailoverClient returns a failover client using the given fake network
+// and a channel receiving errors passed to the error function.
+func newFailoverClient(t *testing.T, n *fakeNet, addrs []string, opts ...FailoverOption) (*Client, <-chan error) {
+	c := newClient()
+	c.dial = n.dial
+	errc := make(chan error, 10)
+	c.setErrorFunc(func(err error) {
+		errc <- err
+	})
+	if err := c.setFailover(addrs, opts); err != nil {
+		t.Fatal(err)
+	}
+	return &Client{c: c}, errc
+}
+
+func receiveError(t *testing.T, errc <-chan error) error {
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for error")
+		return nil
+	}
+}
+
+func TestFailover(t *testing.T) {
+	n := newFakeNet()
+	cl, errc := newFailoverClient(t, n, []string{"a:8125", "b:8125"}, FailoverThreshold(2))
+	defer cl.Close()
+	n.set(n.failing, "a:8125", true)
+	for i := 0; i < 2; i++ {
+		if err := cl.Increment("incr", i, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Flush(); err == nil {
+			t.Fatalf("no error from failed flush")
+		}
+	}
+	err := receiveError(t, errc)
+	var ferr *FailoverError
+	if !errors.As(err, &ferr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if ferr.From != "a:8125" || ferr.To != "b:8125" || ferr.Err == nil {
+		t.Fatalf("unexpected failover %#v", ferr)
+	}
+	assert(t, err.Error(), "statsd failing over from a:8125 to b:8125: connection refused")
+	if err := cl.Increment("incr", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, n.received("a:8125"), "")
+	assert(t, n.received("b:8125"), "incr:2|c")
+}
+
+func TestFailoverWrapsAround(t *testing.T) {
+	n := newFakeNet()
+	cl, errc := newFailoverClient(t, n, []string{"a:8125", "b:8125"}, FailoverThreshold(1))
+	defer cl.Close()
+	n.set(n.failing, "a:8125", true)
+	n.set(n.failing, "b:8125", true)
+	for _, want := range []string{"a:8125 to b:8125", "b:8125 to a:8125"} {
+		if err := cl.Increment("incr", 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Flush(); err == nil {
+			t.Fatalf("no error from failed flush")
+		}
+		err := receiveError(t, errc)
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestFailoverResolveError(t *testing.T) {
+	n := newFakeNet()
+	cl, errc := newFailoverClient(t, n, []string{"a:8125", "b:8125"}, FailoverThreshold(1))
+	defer cl.Close()
+	n.set(n.failing, "a:8125", true)
+	n.set(n.down, "a:8125", true)
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err == nil || !strings.Contains(err.Error(), "no such host") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err := receiveError(t, errc)
+	if !strings.Contains(err.Error(), "no such host") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := cl.Increment("incr", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, n.received("b:8125"), "incr:2|c")
+}
+
+func TestFailback(t *testing.T) {
+	n := newFakeNet()
+	n.set(n.down, "a:8125", true)
+	cl, errc := newFailoverClient(t, n, []string{"a:8125", "b:8125"}, FailoverProbeInterval(time.Millisecond))
+	defer cl.Close()
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Increment("incr", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	n.set(n.down, "a:8125", false)
+	err := receiveError(t, errc)
+	assert(t, err.Error(), "statsd failing back from b:8125 to a:8125")
+	if err := cl.Increment("incr", 3, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Metrics buffered before failing back are sent to the
+	// address in use when they were recorded.
+	assert(t, n.received("b:8125"), "incr:1|c\n--\nincr:2|c")
+	assert(t, n.received("a:8125"), "incr:3|c")
+}
+
+func TestFailoverNoAddress(t *testing.T) {
+	n := newFakeNet()
+	n.set(n.down, "a:8125", true)
+	n.set(n.down, "b:8125", true)
+	c := newClient()
+	c.dial = n.dial
+	err := c.setFailover([]string{"a:8125", "b:8125"}, nil)
+	assert(t, fmt.Sprint(err), "cannot connect to any address: lookup a:8125: no such host; lookup b:8125: no such host")
+	if _, err := NewClientFailover(nil); err == nil {
+		t.Errorf("no error with no addresses")
+	}
+}
+
+func TestFailoverInvalidOptions(t *testing.T) {
+	if _, err := NewClientFailover([]string{"localhost:8125"}, FailoverThreshold(0)); err == nil {
+		t.Errorf("no error for zero threshold")
+	}
+	if _, err := NewClientFailover([]string{"localhost:8125"}, FailoverProbeInterval(0)); err == nil {
+		t.Errorf("no error for zero probe interval")
+	}
+}
+
+func TestFailoverSetAddr(t *testing.T) {
+	n := newFakeNet()
+	cl, _ := newFailoverClient(t, n, []string{"a:8125", "b:8125"}, FailoverThreshold(1))
+	defer cl.Close()
+	if err := cl.c.setAddr("c:8125"); err != nil {
+		t.Fatal(err)
+	}
+	n.set(n.failing, "c:8125", true)
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err == nil {
+		t.Fatalf("no error from failed flush")
+	}
+	// SetAddr turns off failover.
+	cl.c.m.Lock()
+	addr := cl.c.addr
+	cl.c.m.Unlock()
+	assert(t, addr, "c:8125")
+}
@@ -0,0 +1,117 @@
+package statsd
+
+import (
+	"context"
+	"time"
+)
+
+// flushCall holds the state of a flush made by flushContext,
+// which concurrent calls wait for rather than writing again.
+type flushCall struct {
+	done chan struct{}
+	err  error
+}
+
+// flushContext writes any buffered metrics after adding any queued
+// ones, giving up when ctx is done. See FlushContext for details.
+//
+// When called while another call is flushing, it waits for that flush
+// to finish, as metrics may have been buffered after it started. If
+// yet another call started flushing meanwhile, that flush includes
+// the metrics buffered before this call, so it waits for that one and
+// returns its result instead of writing.
+func (c *client) flushContext(ctx context.Context) error {
+	if c.discard.Load() {
+		return nil
+	}
+	if err := c.syncAsyncContext(ctx); err != nil {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for waited := false; c.flushing != nil; waited = true {
+		f := c.flushing
+		c.m.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			c.m.Lock()
+			return ctx.Err()
+		}
+		c.m.Lock()
+		if waited {
+			return f.err
+		}
+	}
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		c.abandonBuffer()
+		return err
+	}
+	if ctx.Done() != nil {
+		// Abandon the write when ctx is done by making
+		// it time out, then clear the deadline again.
+		conn := c.conn
+		expired := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			setWriteDeadline(conn, time.Now())
+			close(expired)
+		})
+		defer func() {
+			if !stop() {
+				<-expired
+				setWriteDeadline(conn, time.Time{})
+			}
+		}()
+	}
+	f := &flushCall{
+		done: make(chan struct{}),
+	}
+	c.flushing = f
+	c.unlockWrites = true
+	err := c.flush()
+	c.unlockWrites = false
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	f.err = err
+	c.flushing = nil
+	close(f.done)
+	return err
+}
+
+// abandonBuffer empties the buffer without writing it, spooling,
+// keeping or discarding the metrics as if writing them had failed.
+// Caller must hold the client mutex lock.
+func (c *client) abandonBuffer() {
+	if c.stream {
+		c.buf.WriteByte('\n')
+	}
+	switch {
+	case c.spool != nil:
+		c.spool.add(c, c.buf.Bytes(), nil)
+	case c.overflow != nil:
+		c.overflow.add(c, c.buf.Bytes())
+	default:
+		c.countDropped(c.buf.Bytes())
+	}
+	c.buf.Reset()
+	c.buffered = 0
+}
+
+// waitFlushed flushes any buffered or queued metrics and waits until
+// writes started by other goroutines have finished. See WaitFlushed
+// for details.
+func (c *client) waitFlushed() {
+	c.reportError(c.flushContext(context.Background()))
+
+	// Writes may be in progress with the client lock released;
+	// sendMu is held until they finish.
+	c.m.Lock()
+	c.sendMu.Lock()
+	c.sendMu.Unlock()
+	c.m.Unlock()
+}
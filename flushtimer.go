@@ -0,0 +1,155 @@
+package statsd
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultFlushInterval is the interval at which the package-level
+// functions flush metrics, unless SetFlushInterval is called.
+const defaultFlushInterval = 300 * time.Millisecond
+
+// flusher holds the state of the background goroutine
+// started by setFlushInterval.
+type flusher struct {
+	interval time.Duration
+	stop     chan struct{}
+}
+
+// setFlushInterval sets how often buffered metrics are flushed
+// in the background. See SetFlushInterval for details.
+func (c *client) setFlushInterval(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("negative flush interval %v", interval)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.flushIntervalSet = true
+	c.stopFlusher()
+	if interval > 0 {
+		c.startFlusher(interval)
+	}
+	return nil
+}
+
+// startDefaultFlusher starts flushing every interval in the background,
+// unless a flush interval has been set with setFlushInterval or the
+// client is closed.
+func (c *client) startDefaultFlusher(interval time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !c.flushIntervalSet && c.flusher == nil && !c.closed {
+		c.startFlusher(interval)
+	}
+}
+
+// getFlushInterval returns the interval set with setFlushInterval,
+// or zero if metrics are not flushed in the background.
+func (c *client) getFlushInterval() time.Duration {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.flusher == nil {
+		return 0
+	}
+	return c.flusher.interval
+}
+
+// startFlusher starts flushing in the background every interval.
+// Caller must hold the client mutex lock.
+func (c *client) startFlusher(interval time.Duration) {
+	c.flusher = &flusher{
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	// Use the current clock even if it is later replaced
+	// by setClock, which restarts the flushing.
+	after := time.After
+	if c.clock != nil {
+		after = c.clock.After
+	}
+	go c.flushEvery(c.flusher, after)
+}
+
+// stopFlusher stops any background flushing. Caller must hold
+// the client mutex lock.
+func (c *client) stopFlusher() {
+	if c.flusher != nil {
+		close(c.flusher.stop)
+		c.flusher = nil
+	}
+}
+
+// flushEvery flushes any buffered metrics every f.interval, as
+// measured by after, until f is stopped, passing errors to the
+// error function.
+func (c *client) flushEvery(f *flusher, after func(time.Duration) <-chan time.Time) {
+	for {
+		select {
+		case <-after(f.interval):
+		case <-f.stop:
+			return
+		}
+		c.syncAsync()
+
+		c.m.Lock()
+		if c.flusher != f {
+			c.m.Unlock()
+			return
+		}
+		var err error
+		if c.buf.Len() > 0 {
+			err = c.flush()
+		}
+		c.m.Unlock()
+		c.reportError(err)
+	}
+}
+
+// setLinger sets how long metrics may wait in the buffer before
+// being flushed. See SetLinger for details.
+func (c *client) setLinger(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("negative linger %v", d)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.linger = d
+	c.stopLinger()
+	if d > 0 && c.buf.Len() > 0 {
+		c.startLinger()
+	}
+	return nil
+}
+
+// startLinger arms the timer that flushes the buffer after
+// the linger duration. Caller must hold the client mutex lock.
+func (c *client) startLinger() {
+	c.stopLinger()
+	c.lingerTimer = c.afterFunc(c.linger, c.flushLinger)
+}
+
+// stopLinger stops the linger timer, if it is armed.
+// Caller must hold the client mutex lock.
+func (c *client) stopLinger() {
+	if c.lingerTimer != nil {
+		c.lingerTimer.Stop()
+	}
+}
+
+// flushLinger flushes any buffered metrics when the linger timer
+// fires, passing errors to the error function.
+func (c *client) flushLinger() {
+	c.m.Lock()
+	var err error
+	if c.buf.Len() > 0 {
+		c.unlockWrites = true
+		err = c.flush()
+		c.unlockWrites = false
+	}
+	c.m.Unlock()
+	c.reportError(err)
+}
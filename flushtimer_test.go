@@ -0,0 +1,132 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFlushInterval(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cl, err := NewClient(ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.SetFlushInterval(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// Metrics arrive without a call to Flush.
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "incr:1|c")
+
+	// Close stops the flushing.
+	if err := cl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if cl.c.flusher != nil {
+		t.Fatal("flusher not stopped")
+	}
+}
+
+func TestFlushIntervalAsync(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cl, err := NewClient(ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	cl.SetAsync(10)
+	if err := cl.SetFlushInterval(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("incr", 1, 1)
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "incr:1|c")
+}
+
+func TestFlushIntervalNegative(t *testing.T) {
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	if err := cl.SetFlushInterval(-time.Second); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLinger(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	clock := newFakeClock()
+	cl, err := New(ln.LocalAddr().String(), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	const linger = 20 * time.Millisecond
+	if err := cl.SetLinger(linger); err != nil {
+		t.Fatal(err)
+	}
+	// A lone metric arrives without a call to Flush,
+	// but not before the linger time.
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(linger - time.Millisecond)
+	if got, _ := cl.Pending(); got != 1 {
+		t.Fatalf("got %d pending metrics before the linger time, want 1", got)
+	}
+	clock.Advance(time.Millisecond)
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "incr:1|c")
+
+	// A flush disarms the timer.
+	cl.Increment("incr", 2, 1)
+	cl.Flush()
+	n, _, err = ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "incr:2|c")
+	clock.mu.Lock()
+	pending := len(clock.timers)
+	clock.mu.Unlock()
+	if pending != 0 {
+		t.Fatalf("got %d pending timers after flush, want 0", pending)
+	}
+}
+
+func TestLingerNegative(t *testing.T) {
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	if err := cl.SetLinger(-time.Second); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,180 @@
+package statsd
+
+import (
+	"strconv"
+	"time"
+)
+
+// handle holds the parts of a metric line that do not change
+// between calls on a Counter, Timer or GaugeHandle.
+type handle struct {
+	c    *client
+	stat string
+	kind string
+	rate float64
+	tags []string
+
+	// prefix holds the pre-rendered "stat:" part of the line.
+	prefix []byte
+
+	// suffix holds the pre-rendered "|kind|@rate" part of the line.
+	suffix []byte
+
+	// safe holds whether stat can be sent in any tag format
+	// without change.
+	safe bool
+}
+
+func newHandle(c *client, stat string, kind string, rate float64, tags []string) handle {
+	m := Metric{Stat: stat, Kind: kind, Rate: rate}
+	line := m.AppendTo(nil)
+	n := len(stat) + len(":")
+	return handle{
+		c:      c,
+		stat:   stat,
+		kind:   kind,
+		rate:   rate,
+		tags:   tags,
+		prefix: line[:n:n],
+		suffix: line[n:],
+		safe:   !hasUnsafeStatChar(stat),
+	}
+}
+
+// appendInt appends the metric line for the value n to buf, after
+// the client prefix. The pre-rendered line is only used when there are
+// no tags or container ID and the stat name needs no checking. Caller
+// must hold the client mutex lock.
+func (h *handle) appendInt(buf []byte, n int64) ([]byte, error) {
+	if len(h.tags) > 0 || len(h.c.tags) > 0 || h.c.containerID != "" || !h.safe {
+		m := Metric{Stat: h.stat, Value: strconv.FormatInt(n, 10), Kind: h.kind, Rate: h.rate, Tags: h.tags}
+		if err := h.c.sanitize(&m); err != nil {
+			return nil, err
+		}
+		return h.c.appendTo(buf, &m), nil
+	}
+	buf = append(buf, h.c.prefix...)
+	buf = append(buf, h.prefix...)
+	buf = strconv.AppendInt(buf, n, 10)
+	return append(buf, h.suffix...), nil
+}
+
+// Counter is a handle for sending counter updates to a single bucket.
+type Counter struct {
+	h handle
+}
+
+// Add increments the counter by n.
+func (ctr *Counter) Add(n int) error {
+	if ctr.h.c.discard.Load() {
+		return nil
+	}
+	if ctr.h.c.async.Load() != nil {
+		// Take the slow path to queue the metric.
+		return ctr.h.c.increment(ctr.h.stat, n, ctr.h.rate, ctr.h.tags...)
+	}
+	if !ctr.h.c.sample(ctr.h.rate) {
+		return nil
+	}
+	c := ctr.h.c
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var buf [64]byte
+	metric, err := ctr.h.appendInt(buf[:0], int64(n))
+	if err != nil {
+		return err
+	}
+	return c.appendCounter(n == 0, metric)
+}
+
+// Timer is a handle for sending timings to a single bucket.
+type Timer struct {
+	h handle
+}
+
+// Observe records the duration d in milliseconds.
+func (t *Timer) Observe(d time.Duration) error {
+	if t.h.c.discard.Load() {
+		return nil
+	}
+	ms := millisecond(d)
+	if ms < 0 || t.h.c.async.Load() != nil {
+		// Take the slow path to report the error or
+		// queue the metric.
+		return t.h.c.duration(t.h.stat, d, t.h.rate, t.h.tags...)
+	}
+	c := t.h.c
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.timers != nil {
+		// Record the value for aggregation.
+		return c.appendRecord(&metricRecord{
+			op:    opTimer,
+			m:     Metric{Stat: t.h.stat, Kind: "ms", Rate: t.h.rate, Tags: t.h.tags},
+			delta: float64(ms),
+		})
+	}
+	if !c.sample(t.h.rate) {
+		return nil
+	}
+	var buf [64]byte
+	metric, err := t.h.appendInt(buf[:0], int64(ms))
+	if err != nil {
+		return err
+	}
+	return c.append(metric)
+}
+
+// GaugeHandle is a handle for sending values to a single gauge.
+type GaugeHandle struct {
+	h handle
+}
+
+// Set sets the gauge to value.
+func (g *GaugeHandle) Set(value int) error {
+	if g.h.c.discard.Load() {
+		return nil
+	}
+	if g.h.c.async.Load() != nil {
+		// Take the slow path to queue the metric.
+		return g.h.c.gauge(g.h.stat, value, g.h.rate, g.h.tags...)
+	}
+	if !g.h.c.sample(g.h.rate) {
+		return nil
+	}
+	c := g.h.c
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var buf [64]byte
+	metric, err := g.h.appendInt(buf[:0], int64(value))
+	if err != nil {
+		return err
+	}
+	var reset []byte
+	if value < 0 && c.negativeGaugeReset {
+		var resetBuf [64]byte
+		reset, _ = g.h.appendInt(resetBuf[:0], 0)
+	}
+	return c.appendGauge(reset, metric)
+}
+
+func (c *client) counter(stat string, rate float64, tags ...string) *Counter {
+	return &Counter{
+		h: newHandle(c, stat, "c", rate, tags),
+	}
+}
+
+func (c *client) timer(stat string, rate float64, tags ...string) *Timer {
+	return &Timer{
+		h: newHandle(c, stat, "ms", rate, tags),
+	}
+}
+
+func (c *client) gaugeHandle(stat string, rate float64, tags ...string) *GaugeHandle {
+	return &GaugeHandle{
+		h: newHandle(c, stat, "g", rate, tags),
+	}
+}
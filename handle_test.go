@@ -0,0 +1,144 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCounter(t *testing.T) {
+	tc := newTestClient(t)
+	ctr := tc.client.counter("incr", 1)
+	for _, n := range []int{1, -5, 0} {
+		err := ctr.Add(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tc.client.setDropZeroCounts(true)
+	err := ctr.Add(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c\nincr:-5|c\nincr:0|c")
+}
+
+func TestCounterRate(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.counter("incr", 0.99).Add(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.counter("incr", 0).Add(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c|@0.99")
+}
+
+func TestTimer(t *testing.T) {
+	tc := newTestClient(t)
+	tm := tc.client.timer("timing", 1)
+	err := tm.Observe(123456789)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tm.Observe(-time.Second)
+	if err == nil {
+		t.Fatal("expected error for negative duration")
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "timing:123|ms")
+}
+
+func TestTimerAggregated(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, []float64{50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.timer("timing", 1).Observe(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.setTimerAggregation(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "timing.p50:1000|g\ntiming.max:1000|g\ntiming.count:1|c")
+}
+
+func TestGaugeHandle(t *testing.T) {
+	tc := newTestClient(t)
+	g := tc.client.gaugeHandle("gauge", 1)
+	err := g.Set(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.Set(-300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.client.setNegativeGaugeReset(true)
+	err = g.Set(-300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "gauge:300|g\ngauge:-300|g\ngauge:0|g\ngauge:-300|g")
+}
+
+func TestGaugeHandleRate(t *testing.T) {
+	tc := newTestClient(t)
+	tc.client.setNegativeGaugeReset(true)
+	err := tc.client.gaugeHandle("gauge", 0.99).Set(-300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.gaugeHandle("gauge", 0).Set(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "gauge:0|g|@0.99\ngauge:-300|g|@0.99")
+}
+
+func BenchmarkCounterAdd(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	ctr := c.counter("requests.total", 1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ctr.Add(1)
+	}
+}
+
+func TestHandlePrefix(t *testing.T) {
+	tc := newTestClient(t)
+	if err := WithSeparator('/')(&Client{c: tc.client}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setPrefix("app"); err != nil {
+		t.Fatal(err)
+	}
+	// The prefix is added to handles created before it was set.
+	ctr := tc.client.counter("hits", 1)
+	if err := tc.client.setPrefix("web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctr.Add(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.timer("time", 1).Observe(2 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.gaugeHandle("gauge", 1).Set(3); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "web/hits:1|c\nweb/time:2|ms\nweb/gauge:3|g")
+}
@@ -0,0 +1,89 @@
+package statsd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// osHostname returns the host name reported by the operating
+// system. It is a variable so that tests can replace it.
+var osHostname = os.Hostname
+
+// WithHostname returns an option that sets the host name substituted
+// for %h by later WithPrefixTemplate options, instead of the name
+// reported by the operating system. This is useful in containers,
+// whose host names are often random.
+func WithHostname(name string) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithHostname"); err != nil {
+			return err
+		}
+		cl.c.hostname = name
+		return nil
+	}
+}
+
+// WithPrefixTemplate returns an option that adds a prefix to the start
+// of every bucket name, as for WithPrefix, after substituting the host
+// name for each %h in template. Dots in the host name are replaced with
+// underscores, so that it forms a single component of the bucket name.
+// The host name is the one set by an earlier WithHostname option or,
+// failing that, the one reported by the operating system, or "unknown"
+// if it cannot be found. A literal percent sign is written as %%.
+//
+// For example, on host web1.example.com the template "app.%h." gives
+// the prefix "app.web1_example_com.".
+func WithPrefixTemplate(template string) Option {
+	return func(cl *Client) error {
+		prefix, err := expandPrefix(template, cl.c.hostname)
+		if err != nil {
+			return err
+		}
+		cl.prefix += cl.c.sepPrefix(prefix)
+		return nil
+	}
+}
+
+// expandPrefix returns template with its placeholders replaced,
+// using hostname, or the operating system's host name if it is
+// empty, for %h.
+func expandPrefix(template, hostname string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			b.WriteByte(template[i])
+			continue
+		}
+		i++
+		if i == len(template) {
+			return "", fmt.Errorf("invalid prefix template %q: trailing %%", template)
+		}
+		switch template[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'h':
+			if hostname == "" {
+				hostname = localHostname()
+			}
+			b.WriteString(strings.ReplaceAll(hostname, ".", "_"))
+		default:
+			return "", fmt.Errorf("invalid prefix template %q: unknown placeholder %%%c", template, template[i])
+		}
+	}
+	prefix := b.String()
+	if hasUnsafeStatChar(prefix) {
+		return "", fmt.Errorf("invalid character in prefix %q", prefix)
+	}
+	return prefix, nil
+}
+
+// localHostname returns the host name reported by the operating
+// system, or "unknown" if there is none.
+func localHostname() string {
+	name, err := osHostname()
+	if err != nil || name == "" {
+		return "unknown"
+	}
+	return name
+}
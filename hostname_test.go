@@ -0,0 +1,60 @@
+package statsd
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWithPrefixTemplate(t *testing.T) {
+	defer func(f func() (string, error)) { osHostname = f }(osHostname)
+	osHostname = func() (string, error) {
+		return "web1.example.com", nil
+	}
+	tests := []struct {
+		opts []Option
+		want string
+	}{
+		{[]Option{WithPrefixTemplate("app.%h.")}, "app.web1_example_com."},
+		{[]Option{WithPrefixTemplate("%h.%h.")}, "web1_example_com.web1_example_com."},
+		{[]Option{WithPrefixTemplate("app.100%%.")}, "app.100%."},
+		{[]Option{WithPrefix("x."), WithPrefixTemplate("%h.")}, "x.web1_example_com."},
+		{[]Option{WithHostname("pod.7"), WithPrefixTemplate("app.%h.")}, "app.pod_7."},
+	}
+	for _, test := range tests {
+		cl, err := New("127.0.0.1:8125", test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert(t, cl.prefix, test.want)
+		cl.Close()
+	}
+
+	osHostname = func() (string, error) {
+		return "", errors.New("no host name")
+	}
+	cl, err := New("127.0.0.1:8125", WithPrefixTemplate("app.%h."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	assert(t, cl.prefix, "app.unknown.")
+}
+
+func TestWithPrefixTemplateInvalid(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"app.%", `invalid prefix template "app.%": trailing %`},
+		{"app.%x.", `invalid prefix template "app.%x.": unknown placeholder %x`},
+		{"app:%h.", `invalid character in prefix "app:host."`},
+	}
+	for _, test := range tests {
+		_, err := New("127.0.0.1:8125", WithHostname("host"), WithPrefixTemplate(test.template))
+		if err == nil {
+			t.Errorf("no error for %q", test.template)
+			continue
+		}
+		assert(t, err.Error(), test.want)
+	}
+}
@@ -0,0 +1,136 @@
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Meter counts events and periodically reports them. Every interval,
+// the number of events marked since the last report is sent as a
+// counter for the meter's bucket, and the number of events per second
+// over the interval is sent as a gauge for the bucket with a
+// "per_second" suffix, preceded by the separator set by WithSeparator
+// or a dot. The rate gauge is sent even when no events have been
+// marked. Meters use the client clock and are closed when the
+// client is closed.
+type Meter struct {
+	c        *client
+	stat     string
+	tags     []string
+	interval time.Duration
+	count    atomic.Int64
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func (c *client) meter(stat string, interval time.Duration, tags ...string) (*Meter, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid meter interval %v", interval)
+	}
+	m := &Meter{
+		c:        c,
+		stat:     stat,
+		tags:     tags,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		m.closeOnce.Do(func() {
+			close(m.stop)
+		})
+		close(m.done)
+		return m, nil
+	}
+	if c.meters == nil {
+		c.meters = make(map[*Meter]struct{})
+	}
+	c.meters[m] = struct{}{}
+	go m.run()
+	return m, nil
+}
+
+// stopMeters closes all the meters created by the client,
+// so that events marked since their last report are sent.
+func (c *client) stopMeters() {
+	c.m.Lock()
+	meters := c.meters
+	c.meters = nil
+	c.m.Unlock()
+	for m := range meters {
+		m.Close()
+	}
+}
+
+// Mark records n events.
+func (m *Meter) Mark(n int64) {
+	m.count.Add(n)
+}
+
+// Close stops the meter, reporting any events marked since the last
+// report. Events marked after Close are not reported.
+func (m *Meter) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+	})
+	<-m.done
+	m.c.m.Lock()
+	delete(m.c.meters, m)
+	m.c.m.Unlock()
+	return nil
+}
+
+func (m *Meter) run() {
+	defer close(m.done)
+	last := m.c.now()
+	for {
+		select {
+		case <-m.c.after(m.interval):
+		case <-m.stop:
+			m.c.reportError(m.report(0, false))
+			return
+		}
+		now := m.c.now()
+		m.c.reportError(m.report(now.Sub(last), true))
+		last = now
+	}
+}
+
+// report sends the number of events marked since the last report,
+// if any. If withRate is true, it also sends the event rate given that
+// the last report was made the given time ago.
+func (m *Meter) report(elapsed time.Duration, withRate bool) error {
+	n := m.count.Swap(0)
+	var metrics []Metric
+	if n != 0 {
+		metrics = append(metrics, Metric{
+			Stat:  m.stat,
+			Value: strconv.FormatInt(n, 10),
+			Kind:  "c",
+			Rate:  1,
+			Tags:  m.tags,
+		})
+	}
+	if withRate {
+		metrics = append(metrics, Metric{
+			Stat:  m.c.suffixStat(m.stat, "per_second"),
+			Value: formatFloat(float64(n) / elapsed.Seconds()),
+			Kind:  "g",
+			Rate:  1,
+			Tags:  m.tags,
+		})
+	}
+	for _, metric := range metrics {
+		if err := m.c.send(metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
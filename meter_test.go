@@ -0,0 +1,104 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	tc := newTestClient(t)
+	clock := newFakeClock()
+	tc.client.clock = clock
+	m, err := tc.client.meter("events", 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	m.Mark(3)
+	m.Mark(2)
+
+	clock.waitTimers(t, 1)
+	clock.Advance(2 * time.Second)
+	assert(t, tc.waitBuffered(t), "events:5|c\nevents.per_second:2.5|g")
+}
+
+func TestMeterSeparator(t *testing.T) {
+	tc := newTestClient(t)
+	if err := WithSeparator('_')(&Client{c: tc.client}); err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock()
+	tc.client.clock = clock
+	m, err := tc.client.meter("events", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	m.Mark(2)
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Second)
+	assert(t, tc.waitBuffered(t), "events:2|c\nevents_per_second:2|g")
+}
+
+func TestMeterClose(t *testing.T) {
+	tc := newTestClient(t)
+	m, err := tc.client.meter("events", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Mark(7)
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing twice is fine.
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Mark(1)
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "events:7|c")
+}
+
+func TestMeterInvalidInterval(t *testing.T) {
+	tc := newTestClient(t)
+	for _, interval := range []time.Duration{0, -time.Second} {
+		_, err := tc.client.meter("events", interval)
+		if err == nil {
+			t.Fatalf("no error for interval %v", interval)
+		}
+		assert(t, err.Error(), "invalid meter interval "+interval.String())
+	}
+}
+
+func TestMeterClientClose(t *testing.T) {
+	tc := newTestClient(t)
+	m, err := tc.client.meter("events", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Mark(4)
+	err = tc.client.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, tc.buf.String(), "events:4|c")
+	// The meter was stopped by the client.
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Meters created after the client is closed
+	// are already stopped.
+	m, err = tc.client.meter("events", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
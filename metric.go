@@ -0,0 +1,183 @@
+package statsd
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sign specifies how the sign of a metric value is sent.
+type Sign int
+
+const (
+	// SignMaybe sends the value as is, so only negative
+	// values carry a sign.
+	SignMaybe Sign = iota
+
+	// SignRequired sends non-negative values with a leading '+',
+	// as used for relative gauge updates.
+	SignRequired
+)
+
+// TagFormat specifies how metric tags are sent. Characters that a
+// format does not allow are replaced or rejected as described for
+// SetStrict.
+type TagFormat int
+
+const (
+	// TagFormatDogStatsD sends tags after the metric type in the
+	// DogStatsD style, as in "stat:1|c|#env:prod,region:eu". Tags
+	// may not contain commas. This is the default.
+	TagFormatDogStatsD TagFormat = iota
+
+	// TagFormatInflux sends tags as part of the bucket name in the
+	// style used by Telegraf's statsd input for InfluxDB, as in
+	// "stat,env=prod,region=eu:1|c". Each tag must be of the form
+	// "key:value", with a non-empty key and value and no further
+	// colon. Commas, equals signs and spaces in keys and values are
+	// escaped with a backslash.
+	TagFormatInflux
+
+	// TagFormatGraphite sends tags as part of the bucket name in
+	// the style used by Graphite 1.1, as in
+	// "stat;env=prod;region=eu:1|c". Each tag must be of the form
+	// "key:value", with a non-empty key and value. Keys may not
+	// contain the characters ';', '!', '^', '=' or space, and values
+	// may not contain ';', ':' or space or start with '~'.
+	TagFormatGraphite
+
+	// TagFormatSignalFx sends tags as dimensions in square brackets
+	// after the bucket name in the style used by SignalFx, as in
+	// "stat[env=prod,region=eu]:1|c". Each tag must be of the form
+	// "key:value". Keys must start with a letter and contain only
+	// letters, digits, '_' and '-', and values may not be empty or
+	// contain the characters ',', '=', ':', '[' or ']'.
+	TagFormatSignalFx
+)
+
+// Metric represents a single statsd metric line, as sent on the wire
+// in the form "stat:value|kind|@rate|#tags|Ttimestamp".
+type Metric struct {
+	// Stat holds the name of the bucket.
+	Stat string
+
+	// Value holds the formatted value of the metric.
+	Value string
+
+	// Kind holds the metric type, for example "c" for counters
+	// or "ms" for timers.
+	Kind string
+
+	// Rate holds the sample rate. It is only sent when it is
+	// greater than zero and less than one.
+	Rate float64
+
+	// Sign specifies how the sign of Value is sent.
+	Sign Sign
+
+	// Tags holds any tags for the metric, such as "env:prod".
+	// AppendTo sends them in DogStatsD format without checking
+	// them; see TagFormat for the restrictions that apply in each
+	// format.
+	Tags []string
+
+	// ContainerID holds the ID of the container the metric
+	// originates from. If it is not empty, it is sent as a
+	// DogStatsD "|c:" suffix after the tags. It is not sent in
+	// other tag formats.
+	ContainerID string
+
+	// Timestamp holds the time the metric was recorded. If it is
+	// non-zero, it is sent as a DogStatsD "|T" suffix holding the
+	// Unix time in seconds.
+	Timestamp time.Time
+}
+
+// AppendTo appends the wire format of m to buf and returns
+// the extended buffer. Any tags are sent in DogStatsD format.
+func (m *Metric) AppendTo(buf []byte) []byte {
+	return appendMetric(buf, m.Stat, m, TagFormatDogStatsD)
+}
+
+// appendMetric is like m.AppendTo but uses stat as the bucket
+// name instead of m.Stat, so that names held as byte slices can
+// be used without conversion, and sends tags in the given format.
+func appendMetric[S string | []byte](buf []byte, stat S, m *Metric, format TagFormat) []byte {
+	buf = append(buf, stat...)
+	switch format {
+	case TagFormatInflux:
+		for _, tag := range m.Tags {
+			key, value, _ := strings.Cut(tag, ":")
+			buf = append(buf, ',')
+			buf = appendInfluxEscaped(buf, key)
+			buf = append(buf, '=')
+			buf = appendInfluxEscaped(buf, value)
+		}
+	case TagFormatGraphite:
+		for _, tag := range m.Tags {
+			key, value, _ := strings.Cut(tag, ":")
+			buf = append(buf, ';')
+			buf = append(buf, key...)
+			buf = append(buf, '=')
+			buf = append(buf, value...)
+		}
+	case TagFormatSignalFx:
+		for i, tag := range m.Tags {
+			key, value, _ := strings.Cut(tag, ":")
+			if i == 0 {
+				buf = append(buf, '[')
+			} else {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, key...)
+			buf = append(buf, '=')
+			buf = append(buf, value...)
+		}
+		if len(m.Tags) > 0 {
+			buf = append(buf, ']')
+		}
+	}
+	buf = append(buf, ':')
+	if m.Sign == SignRequired && (m.Value == "" || m.Value[0] != '-') {
+		buf = append(buf, '+')
+	}
+	buf = append(buf, m.Value...)
+	buf = append(buf, '|')
+	buf = append(buf, m.Kind...)
+	if m.Rate > 0 && m.Rate < 1 {
+		buf = append(buf, "|@"...)
+		buf = strconv.AppendFloat(buf, m.Rate, 'g', -1, 64)
+	}
+	if format == TagFormatDogStatsD {
+		for i, tag := range m.Tags {
+			if i == 0 {
+				buf = append(buf, "|#"...)
+			} else {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, tag...)
+		}
+		if m.ContainerID != "" {
+			buf = append(buf, "|c:"...)
+			buf = append(buf, m.ContainerID...)
+		}
+	}
+	if !m.Timestamp.IsZero() {
+		buf = append(buf, "|T"...)
+		buf = strconv.AppendInt(buf, m.Timestamp.Unix(), 10)
+	}
+	return buf
+}
+
+// appendInfluxEscaped appends s to buf, escaping the characters
+// that are special in Telegraf's tag syntax.
+func appendInfluxEscaped(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ',', '=', ' ':
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, s[i])
+	}
+	return buf
+}
@@ -0,0 +1,75 @@
+package statsd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var metricAppendToTests = []struct {
+	metric  Metric
+	control string
+}{{
+	metric:  Metric{Stat: "incr", Value: "1", Kind: "c"},
+	control: "incr:1|c",
+}, {
+	metric:  Metric{Stat: "incr", Value: "1", Kind: "c", Rate: 1},
+	control: "incr:1|c",
+}, {
+	metric:  Metric{Stat: "incr", Value: "1", Kind: "c", Rate: 0.99901},
+	control: "incr:1|c|@0.99901",
+}, {
+	metric:  Metric{Stat: "incr", Value: "1", Kind: "c", Rate: 0.00001},
+	control: "incr:1|c|@1e-05",
+}, {
+	metric:  Metric{Stat: "gauge", Value: "10", Kind: "g", Sign: SignRequired},
+	control: "gauge:+10|g",
+}, {
+	metric:  Metric{Stat: "gauge", Value: "-4", Kind: "g", Sign: SignRequired},
+	control: "gauge:-4|g",
+}, {
+	metric:  Metric{Stat: "gauge", Value: "0", Kind: "g", Sign: SignRequired},
+	control: "gauge:+0|g",
+}, {
+	metric:  Metric{Stat: "gauge", Value: "-4", Kind: "g"},
+	control: "gauge:-4|g",
+}, {
+	metric:  Metric{Stat: "gauge", Value: "1", Kind: "g", Rate: 0.5, Timestamp: time.Unix(1700000000, 0)},
+	control: "gauge:1|g|@0.5|T1700000000",
+}}
+
+func TestMetricAppendTo(t *testing.T) {
+	for i, mt := range metricAppendToTests {
+		buf := mt.metric.AppendTo([]byte("prefix"))
+		if string(buf) != "prefix"+mt.control {
+			t.Errorf("%d: incorrect metric, want %q, got %q", i, "prefix"+mt.control, buf)
+		}
+	}
+}
+
+// TestMetricCompatibility checks that Metric produces exactly the same
+// output as the fmt-based formatting that preceded it.
+func TestMetricCompatibility(t *testing.T) {
+	oldFormat := func(stat string, rate float64, format string, args ...interface{}) string {
+		if rate < 1 {
+			format = fmt.Sprintf("%s|@%g", format, rate)
+		}
+		return fmt.Sprintf("%s:%s", stat, fmt.Sprintf(format, args...))
+	}
+	rates := []float64{1, 0.99, 0.5, 0.1, 0.0001, 0.000001, 1.0 / 3}
+	values := []int{0, 1, -1, 765, 123456789, -300}
+	for _, rate := range rates {
+		for _, value := range values {
+			for _, kind := range []string{"c", "ms", "g", "s"} {
+				m := Metric{Stat: "stat", Value: fmt.Sprint(value), Kind: kind, Rate: rate}
+				assert(t, string(m.AppendTo(nil)), oldFormat("stat", rate, "%d|"+kind, value))
+			}
+			if value > 0 {
+				m := Metric{Stat: "stat", Value: fmt.Sprint(value), Kind: "g", Rate: rate, Sign: SignRequired}
+				assert(t, string(m.AppendTo(nil)), oldFormat("stat", rate, "+%d|g", value))
+				m = Metric{Stat: "stat", Value: fmt.Sprint(-value), Kind: "g", Rate: rate, Sign: SignRequired}
+				assert(t, string(m.AppendTo(nil)), oldFormat("stat", rate, "-%d|g", value))
+			}
+		}
+	}
+}
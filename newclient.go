@@ -0,0 +1,218 @@
+package statsd
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Option configures a client created by New or Client.Clone.
+type Option func(*Client) error
+
+// checkShared returns an error if cl is being created by Clone, as
+// the option with the given name changes settings that are shared
+// between clients and so can only be given to New.
+func checkShared(cl *Client, name string) error {
+	if cl.derived {
+		return fmt.Errorf("%s cannot be used with Clone", name)
+	}
+	return nil
+}
+
+// New returns a client that sends metrics to the given network address,
+// which is interpreted as described for SetAddr, after applying the
+// given options in order. The options are applied before the address
+// is dialed, so no metrics can be sent before the client is fully
+// configured. An error is returned if any option is invalid, if
+// WithCompression is given with an address that is not TCP, or if the
+// address cannot be dialed.
+func New(addr string, opts ...Option) (*Client, error) {
+	cl := &Client{c: newClient()}
+	for _, opt := range opts {
+		if err := opt(cl); err != nil {
+			cl.c.close()
+			return nil, err
+		}
+	}
+	if cl.c.tlsConfig != nil && !strings.Contains(addr, "://") {
+		addr = "tcp://" + addr
+	}
+	if cl.c.compression != 0 {
+		network, _, err := parseAddr(addr)
+		if err == nil && !strings.HasPrefix(network, "tcp") {
+			err = errors.New("compression requires a TCP or TLS connection")
+		}
+		if err != nil {
+			cl.c.close()
+			return nil, err
+		}
+	}
+	if err := cl.c.setAddr(addr); err != nil {
+		cl.c.close()
+		return nil, err
+	}
+	return cl, nil
+}
+
+// WithPacketSize returns an option that sets the maximum size of
+// packets, as for SetPacketSize.
+func WithPacketSize(size int) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithPacketSize"); err != nil {
+			return err
+		}
+		return cl.c.setPacketSize(size)
+	}
+}
+
+// WithErrorFunc returns an option that sets the function called with
+// errors that happen in the background, as for SetErrorFunc.
+func WithErrorFunc(f func(error)) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithErrorFunc"); err != nil {
+			return err
+		}
+		cl.c.setErrorFunc(f)
+		return nil
+	}
+}
+
+// WithFlushInterval returns an option that makes buffered metrics be
+// flushed every interval in the background, as for SetFlushInterval.
+func WithFlushInterval(interval time.Duration) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithFlushInterval"); err != nil {
+			return err
+		}
+		return cl.c.setFlushInterval(interval)
+	}
+}
+
+// WithPrefix returns an option that adds prefix to the start of every
+// bucket name, after any prefix added by earlier options, as for
+// Client.WithPrefix. Unlike clients returned by Client.WithPrefix, the
+// client returned by New is not derived, so closing it closes the
+// connection.
+func WithPrefix(prefix string) Option {
+	return func(cl *Client) error {
+		cl.prefix += cl.c.sepPrefix(prefix)
+		return nil
+	}
+}
+
+// WithTags returns an option that adds the given tags to every metric,
+// after any tags added by earlier options, as for Client.WithTags. Unlike
+// the tags set by WithGlobalTags, they apply only to the client that
+// the option is given to, which is useful with Client.Clone.
+func WithTags(tags ...string) Option {
+	return func(cl *Client) error {
+		cl.tags = append(cl.tags[:len(cl.tags):len(cl.tags)], tags...)
+		return nil
+	}
+}
+
+// WithDefaultRate returns an option that sets the sample rate of the
+// metrics sent by the Opt methods, such as Client.IncrementOpt, when
+// they are given no Rate option. The rate must be greater than 0 and
+// no greater than 1. The default is 1. With Client.Clone, it applies
+// only to the returned client.
+func WithDefaultRate(rate float64) Option {
+	return func(cl *Client) error {
+		if !(rate > 0 && rate <= 1) {
+			return fmt.Errorf("invalid default rate %v", rate)
+		}
+		cl.rate = rate
+		return nil
+	}
+}
+
+// WithGlobalTags returns an option that sets tags to be sent with
+// every metric, as for SetGlobalTags.
+func WithGlobalTags(tags ...string) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithGlobalTags"); err != nil {
+			return err
+		}
+		return cl.c.setGlobalTags(tags)
+	}
+}
+
+// WithAsync returns an option that makes the client queue metrics to
+// be sent in the background, as for SetAsync.
+func WithAsync(queueLen int) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithAsync"); err != nil {
+			return err
+		}
+		return cl.c.setAsync(queueLen)
+	}
+}
+
+// WithUnbuffered returns an option that makes the client write each
+// metric in its own packet as soon as it is recorded, as for
+// SetUnbuffered.
+func WithUnbuffered() Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithUnbuffered"); err != nil {
+			return err
+		}
+		return cl.c.setUnbuffered(true)
+	}
+}
+
+// WithTLS returns an option that makes the client send metrics over
+// TLS, as for NewClientTLS. An address without a scheme is treated as
+// TCP.
+func WithTLS(cfg *tls.Config) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithTLS"); err != nil {
+			return err
+		}
+		if cfg != nil {
+			cl.c.tlsConfig = cfg.Clone()
+		} else {
+			cl.c.tlsConfig = &tls.Config{}
+		}
+		return nil
+	}
+}
+
+// WithCompression returns an option that makes the client compress
+// metrics with gzip at the given level, as for SetCompression. The
+// first connection is compressed from its start, so it is dialed only
+// once. New returns an error if the address is not a TCP address.
+func WithCompression(level int) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithCompression"); err != nil {
+			return err
+		}
+		if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+			return err
+		}
+		cl.c.compression = level
+		return nil
+	}
+}
+
+// WithRandSource returns an option that makes the client use src to
+// decide which metrics to send when sampling. By default, the randomly
+// seeded global source in math/rand is used, so that processes sample
+// independently of each other. A source with a fixed seed makes
+// sampling repeatable, for example in tests.
+func WithRandSource(src rand.Source) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithRandSource"); err != nil {
+			return err
+		}
+		if src == nil {
+			return errors.New("nil rand source")
+		}
+		cl.c.rand = rand.New(src)
+		return nil
+	}
+}
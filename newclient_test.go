@@ -0,0 +1,124 @@
+package statsd
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	errc := make(chan error, 1)
+	cl, err := New(ln.LocalAddr().String(),
+		WithPacketSize(1024),
+		WithErrorFunc(func(err error) { errc <- err }),
+		WithFlushInterval(10*time.Millisecond),
+		WithPrefix("app."),
+		WithPrefix("api."),
+		WithGlobalTags("env:test"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if cl.c.size != 1024 {
+		t.Errorf("got packet size %d, want 1024", cl.c.size)
+	}
+	// The metric is flushed in the background.
+	cl.Increment("requests", 1, 1)
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 1024)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "app.api.requests:1|c|#env:test")
+
+	cl.c.reportError(errTooBig)
+	if err := <-errc; err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestNewAsyncUnbuffered(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cl, err := New(ln.LocalAddr().String(), WithAsync(10), WithUnbuffered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if cl.c.async.Load() == nil || !cl.c.unbuffered {
+		t.Fatal("options not applied")
+	}
+	cl.Increment("a", 1, 1)
+	ln.SetReadDeadline(time.Now().Add(3 * time.Second))
+	out := make([]byte, 512)
+	n, _, err := ln.ReadFrom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, string(out[:n]), "a:1|c")
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		opt  Option
+		want string
+	}{
+		{WithPacketSize(0), "invalid packet size 0"},
+		{WithFlushInterval(-time.Second), "negative flush interval -1s"},
+		{WithAsync(-1), "invalid queue length -1"},
+		{WithRandSource(nil), "nil rand source"},
+	}
+	for _, test := range tests {
+		cl, err := New("127.0.0.1:8125", WithPrefix("x."), test.opt)
+		if err == nil {
+			cl.Close()
+			t.Errorf("no error, want %q", test.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("got error %q, want %q", err, test.want)
+		}
+	}
+	if _, err := New("udp://127.0.0.1:8125", WithTLS(nil)); err == nil || !strings.Contains(err.Error(), "cannot use TLS over udp") {
+		t.Errorf("unexpected error %v for TLS over UDP", err)
+	}
+}
+
+func TestWithRandSource(t *testing.T) {
+	// Clients with sources seeded alike sample the same metrics.
+	var sampled [2][]bool
+	for i := range sampled {
+		cl, err := New("127.0.0.1:8125", WithRandSource(rand.NewSource(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cl.Close()
+		for j := 0; j < 100; j++ {
+			sampled[i] = append(sampled[i], cl.c.sample(0.5))
+		}
+	}
+	n := 0
+	for j := range sampled[0] {
+		if sampled[0][j] != sampled[1][j] {
+			t.Fatalf("sample %d differs", j)
+		}
+		if sampled[0][j] {
+			n++
+		}
+	}
+	if n == 0 || n == 100 {
+		t.Fatalf("%d of 100 metrics sampled", n)
+	}
+}
@@ -0,0 +1,71 @@
+package statsd
+
+import "time"
+
+// nop is a Statter that discards all metrics.
+type nop struct{}
+
+var _ Statter = nop{}
+
+// Nop returns a Statter that discards all metrics without error. It is
+// useful where metrics are optional, such as when no statsd server is
+// configured, so that code can send metrics unconditionally.
+func Nop() Statter {
+	return nop{}
+}
+
+func (nop) Increment(stat string, count int, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Decrement(stat string, count int, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Duration(stat string, duration time.Duration, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Timing(stat string, delta int, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) TimingFloat(stat string, delta float64, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Gauge(stat string, value int, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) GaugeFloat64(stat string, value float64, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) IncrementGauge(stat string, value int, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) DecrementGauge(stat string, value int, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Unique(stat string, value int, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Histogram(stat string, value float64, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Distribution(stat string, value float64, rate float64, tags ...string) error {
+	return nil
+}
+
+func (nop) Flush() error {
+	return nil
+}
+
+func (nop) Close() error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNop(t *testing.T) {
+	s := Nop()
+	errs := []error{
+		s.Increment("a", 1, 1),
+		s.Decrement("a", 1, 1),
+		s.Duration("a", time.Second, 1),
+		s.Timing("a", 1, 1),
+		s.TimingFloat("a", 1, 1),
+		s.Gauge("a", 1, 1),
+		s.GaugeFloat64("a", 1, 1),
+		s.IncrementGauge("a", 1, 1),
+		s.DecrementGauge("a", 1, 1),
+		s.Unique("a", 1, 1),
+		s.Histogram("a", 1, 1),
+		s.Distribution("a", 1, 1, "k:v"),
+		s.Flush(),
+		s.Close(),
+		// Invalid metrics are not checked.
+		s.Increment("a|b", 1, 1),
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("call %d: unexpected error %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package statsd
+
+import "time"
+
+// MetricOption modifies a single metric sent by one of the Client
+// methods with an Opt suffix, such as IncrementOpt. Options are
+// plain values, so they can be created once and passed to many calls
+// without allocating.
+type MetricOption struct {
+	tags    []string
+	rate    float64
+	hasRate bool
+}
+
+// Tags returns an option that sends the given tags with a metric,
+// after the default tags of the client. When several Tags options
+// are given, all their tags are sent in order.
+func Tags(tags ...string) MetricOption {
+	return MetricOption{tags: tags}
+}
+
+// Rate returns an option that sets the sample rate of a metric. The
+// default rate is 1, or the rate set by WithDefaultRate. When several
+// Rate options are given, the last one applies.
+func Rate(rate float64) MetricOption {
+	return MetricOption{rate: rate, hasRate: true}
+}
+
+// options returns the sample rate and tags specified by opts,
+// together with the default tags of cl.
+func (cl *Client) options(opts []MetricOption) (rate float64, tags []string) {
+	rate = 1
+	if cl.rate != 0 {
+		rate = cl.rate
+	}
+	ntags := 0
+	for _, o := range opts {
+		if o.hasRate {
+			rate = o.rate
+		}
+		if len(o.tags) > 0 {
+			tags = o.tags
+			ntags++
+		}
+	}
+	if ntags > 1 {
+		tags = nil
+		for _, o := range opts {
+			tags = append(tags, o.tags...)
+		}
+	}
+	return rate, cl.metricTags(tags)
+}
+
+// IncrementOpt is like Increment but takes the sample rate and tags
+// as options. For example:
+//
+//	cl.IncrementOpt("requests", 1, statsd.Tags("code:200"), statsd.Rate(0.5))
+//
+// The other Opt methods behave similarly.
+func (cl *Client) IncrementOpt(stat string, count int, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.increment(cl.stat(stat), count, rate, tags...)
+}
+
+// DecrementOpt is like Decrement but takes the sample rate and tags as options.
+func (cl *Client) DecrementOpt(stat string, count int, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.decrement(cl.stat(stat), count, rate, tags...)
+}
+
+// DurationOpt is like Duration but takes the sample rate and tags as options.
+func (cl *Client) DurationOpt(stat string, duration time.Duration, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.duration(cl.stat(stat), duration, rate, tags...)
+}
+
+// TimingOpt is like Timing but takes the sample rate and tags as options.
+func (cl *Client) TimingOpt(stat string, delta int, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.timing(cl.stat(stat), delta, rate, tags...)
+}
+
+// GaugeOpt is like Gauge but takes the sample rate and tags as options.
+func (cl *Client) GaugeOpt(stat string, value int, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.gauge(cl.stat(stat), value, rate, tags...)
+}
+
+// GaugeFloat64Opt is like GaugeFloat64 but takes the sample rate and tags as options.
+func (cl *Client) GaugeFloat64Opt(stat string, value float64, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.gaugeFloat64(cl.stat(stat), value, rate, tags...)
+}
+
+// IncrementGaugeOpt is like IncrementGauge but takes the sample rate and tags as options.
+func (cl *Client) IncrementGaugeOpt(stat string, value int, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.incrementGauge(cl.stat(stat), value, rate, tags...)
+}
+
+// DecrementGaugeOpt is like DecrementGauge but takes the sample rate and tags as options.
+func (cl *Client) DecrementGaugeOpt(stat string, value int, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.decrementGauge(cl.stat(stat), value, rate, tags...)
+}
+
+// UniqueOpt is like Unique but takes the sample rate and tags as options.
+func (cl *Client) UniqueOpt(stat string, value int, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.unique(cl.stat(stat), value, rate, tags...)
+}
+
+// HistogramOpt is like Histogram but takes the sample rate and tags as options.
+func (cl *Client) HistogramOpt(stat string, value float64, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.histogram(cl.stat(stat), value, rate, tags...)
+}
+
+// DistributionOpt is like Distribution but takes the sample rate and tags as options.
+func (cl *Client) DistributionOpt(stat string, value float64, opts ...MetricOption) error {
+	rate, tags := cl.options(opts)
+	return cl.c.distribution(cl.stat(stat), value, rate, tags...)
+}
@@ -0,0 +1,92 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricOptions(t *testing.T) {
+	tc := newTestClient(t)
+	cl := (&Client{c: tc.client}).WithTags("client:1")
+	code := Tags("code:200")
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(cl.IncrementOpt("incr", 1))
+	checkErr(cl.IncrementOpt("incr", 1, code))
+	checkErr(cl.IncrementOpt("incr", 1, code, Rate(0.99)))
+	checkErr(cl.IncrementOpt("incr", 1, Rate(0.1), code, Rate(0.99), Tags("x", "y")))
+	checkErr(cl.IncrementOpt("incr", 1, Rate(0)))
+	checkErr(cl.DecrementOpt("decr", 1, code))
+	checkErr(cl.DurationOpt("timing", time.Second, code))
+	checkErr(cl.TimingOpt("timing", 5, code))
+	checkErr(cl.GaugeOpt("gauge", 3, code))
+	checkErr(cl.GaugeFloat64Opt("gauge", 0.5, code))
+	checkErr(cl.IncrementGaugeOpt("gauge", 3, code))
+	checkErr(cl.DecrementGaugeOpt("gauge", 3, code))
+	checkErr(cl.UniqueOpt("unique", 765, code))
+	checkErr(cl.HistogramOpt("hist", 1.5, code))
+	checkErr(cl.DistributionOpt("dist", 1.5, code))
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr:1|c|#client:1",
+		"incr:1|c|#client:1,code:200",
+		"incr:1|c|@0.99|#client:1,code:200",
+		"incr:1|c|@0.99|#client:1,code:200,x,y",
+		"decr:-1|c|#client:1,code:200",
+		"timing:1000|ms|#client:1,code:200",
+		"timing:5|ms|#client:1,code:200",
+		"gauge:3|g|#client:1,code:200",
+		"gauge:0.5|g|#client:1,code:200",
+		"gauge:+3|g|#client:1,code:200",
+		"gauge:-3|g|#client:1,code:200",
+		"unique:765|s|#client:1,code:200",
+		"hist:1.5|h|#client:1,code:200",
+		"dist:1.5|d|#client:1,code:200",
+	}, "\n"))
+}
+
+func TestMetricOptionsAllocs(t *testing.T) {
+	cl := &Client{c: &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}}
+	tags := Tags("code:200")
+	rate := Rate(1)
+	plain := testing.AllocsPerRun(100, func() {
+		cl.Increment("requests.total", 1, 1, "code:200")
+	})
+	opt := testing.AllocsPerRun(100, func() {
+		cl.IncrementOpt("requests.total", 1, tags, rate)
+	})
+	if opt > plain {
+		t.Fatalf("IncrementOpt made %v allocations, Increment made %v", opt, plain)
+	}
+}
+
+func BenchmarkIncrementTags(b *testing.B) {
+	cl := &Client{c: &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cl.Increment("requests.total", 1, 1, "code:200")
+	}
+}
+
+func BenchmarkIncrementOpt(b *testing.B) {
+	cl := &Client{c: &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}}
+	tags := Tags("code:200")
+	rate := Rate(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cl.IncrementOpt("requests.total", 1, tags, rate)
+	}
+}
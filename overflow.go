@@ -0,0 +1,143 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// OverflowPolicy specifies what happens to metrics that
+// cannot be sent. See SetOverflowPolicy.
+type OverflowPolicy int
+
+const (
+	// DropNewest discards each packet that cannot be written.
+	// This is the default.
+	DropNewest OverflowPolicy = iota
+
+	// DropOldest keeps packets that cannot be written in memory
+	// and writes them, oldest first, before later packets. When
+	// the kept packets exceed their size limit, the oldest are
+	// discarded.
+	DropOldest
+
+	// Block makes an asynchronous client wait for room in its
+	// queue instead of discarding metrics when the queue is full.
+	// Packets that cannot be written are discarded as for
+	// DropNewest.
+	Block
+)
+
+// overflow holds the packets kept by the DropOldest
+// policy after they could not be written.
+type overflow struct {
+	maxSize int
+	size    int
+	packets [][]byte
+}
+
+// setOverflowPolicy sets the policy for metrics that cannot be sent.
+// See SetOverflowPolicy for details.
+func (c *client) setOverflowPolicy(policy OverflowPolicy, maxBytes int) error {
+	switch policy {
+	case DropNewest, Block:
+	case DropOldest:
+		if maxBytes <= 0 {
+			return fmt.Errorf("invalid overflow size %d", maxBytes)
+		}
+	default:
+		return fmt.Errorf("unknown overflow policy %d", policy)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.blockWhenFull.Store(policy == Block)
+	if policy != DropOldest {
+		c.dropOverflow()
+		return nil
+	}
+	if c.overflow == nil {
+		c.overflow = &overflow{}
+	}
+	c.overflow.maxSize = maxBytes
+	c.overflow.trim(c)
+	return nil
+}
+
+// dropOverflow discards any packets kept by the DropOldest policy
+// and stops keeping them. Caller must hold the client mutex lock.
+func (c *client) dropOverflow() {
+	if c.overflow != nil {
+		for _, packet := range c.overflow.packets {
+			c.countDropped(packet)
+		}
+		c.overflow = nil
+	}
+}
+
+// write writes any kept packets to the client connection, oldest
+// first, and then packet. When writing fails, the write error is
+// returned and the packets not yet written are kept for a later
+// flush. Caller must hold the client mutex lock.
+func (o *overflow) write(c *client, packet []byte) error {
+	for len(o.packets) > 0 {
+		if err := c.write(o.packets[0]); err != nil {
+			o.add(c, packet)
+			return err
+		}
+		o.size -= len(o.packets[0])
+		o.packets[0] = nil
+		o.packets = o.packets[1:]
+	}
+	if err := c.write(packet); err != nil {
+		o.add(c, packet)
+		return err
+	}
+	return nil
+}
+
+// add keeps a copy of packet to be written later.
+// Caller must hold the client mutex lock.
+func (o *overflow) add(c *client, packet []byte) {
+	o.packets = append(o.packets, bytes.Clone(packet))
+	o.size += len(packet)
+	o.trim(c)
+}
+
+// trim discards the oldest packets until the kept packets fit
+// within the size limit. Caller must hold the client mutex lock.
+func (o *overflow) trim(c *client) {
+	for o.size > o.maxSize {
+		c.countDropped(o.packets[0])
+		o.size -= len(o.packets[0])
+		o.packets[0] = nil
+		o.packets = o.packets[1:]
+	}
+}
+
+// setDropReportInterval sets the minimum interval between reports
+// of dropped metrics. See SetDropReportInterval for details.
+func (c *client) setDropReportInterval(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.dropReportInterval = d
+	c.dropReported = c.dropped.Load()
+}
+
+// reportDropped passes the number of metrics dropped since the last
+// report to the error function, unless reports are disabled or the
+// last one was made less than the report interval ago. Caller must
+// hold the client mutex lock.
+func (c *client) reportDropped() {
+	if c.dropReportInterval <= 0 {
+		return
+	}
+	n := c.dropped.Load()
+	if n == c.dropReported || c.since(c.dropReportTime) < c.dropReportInterval {
+		return
+	}
+	c.reportErrorAsync(fmt.Errorf("%d metrics dropped", n-c.dropReported))
+	c.dropReported = n
+	c.dropReportTime = c.now()
+}
@@ -0,0 +1,118 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOverflowDropNewest(t *testing.T) {
+	var packets []string
+	down := true
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	defer cl.Close()
+	sendPackets(t, cl, "a", "b")
+	if got := cl.Stats().Dropped; got != 2 {
+		t.Fatalf("got %d dropped metrics, want 2", got)
+	}
+
+	down = false
+	sendPackets(t, cl, "c")
+	assert(t, strings.Join(packets, " "), "c:1|c")
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	var packets []string
+	down := true
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	defer cl.Close()
+	// Room for two packets of the form "x:1|c".
+	if err := cl.SetOverflowPolicy(DropOldest, 10); err != nil {
+		t.Fatal(err)
+	}
+	sendPackets(t, cl, "a", "b", "c")
+	if got := cl.Stats().Dropped; got != 1 {
+		t.Fatalf("got %d dropped metrics, want 1", got)
+	}
+
+	down = false
+	sendPackets(t, cl, "d")
+	assert(t, strings.Join(packets, " "), "b:1|c c:1|c d:1|c")
+	if got := cl.Stats().Dropped; got != 1 {
+		t.Fatalf("got %d dropped metrics, want 1", got)
+	}
+
+	// Kept packets are discarded when the policy changes.
+	down = true
+	sendPackets(t, cl, "e")
+	if err := cl.SetOverflowPolicy(DropNewest, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := cl.Stats().Dropped; got != 2 {
+		t.Fatalf("got %d dropped metrics, want 2", got)
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	var packets []string
+	down := true
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	defer cl.Close()
+	if err := cl.SetOverflowPolicy(Block, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.SetAsync(1); err != nil {
+		t.Fatal(err)
+	}
+	cl.SetErrorFunc(func(error) {})
+	// No metrics are dropped from the queue, so all of them
+	// reach the connection and are counted when writing fails.
+	const n = 100
+	for i := 0; i < n; i++ {
+		cl.Increment("a", 1, 1)
+	}
+	cl.Flush()
+	if got := cl.Stats().Dropped; got != n {
+		t.Fatalf("got %d dropped metrics, want %d", got, n)
+	}
+}
+
+func TestSetOverflowPolicyInvalid(t *testing.T) {
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	if err := cl.SetOverflowPolicy(DropOldest, 0); err == nil {
+		t.Fatal("expected error for zero size")
+	}
+	if err := cl.SetOverflowPolicy(OverflowPolicy(99), 0); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestDropReportInterval(t *testing.T) {
+	var packets []string
+	down := true
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	defer cl.Close()
+	reports := make(chan string, 10)
+	cl.SetErrorFunc(func(err error) {
+		if strings.HasSuffix(err.Error(), "metrics dropped") {
+			reports <- err.Error()
+		}
+	})
+	cl.SetDropReportInterval(time.Hour)
+	sendPackets(t, cl, "a", "b", "c")
+	assert(t, <-reports, "1 metrics dropped")
+
+	cl.SetDropReportInterval(time.Nanosecond)
+	sendPackets(t, cl, "d")
+	assert(t, <-reports, "1 metrics dropped")
+	cl.Increment("e", 1, 1)
+	cl.Increment("f", 1, 1)
+	cl.Flush()
+	assert(t, <-reports, "2 metrics dropped")
+	select {
+	case r := <-reports:
+		t.Fatalf("unexpected report %q", r)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
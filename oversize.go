@@ -0,0 +1,96 @@
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// OversizePolicy specifies what happens to a metric that is
+// larger than the packet size. See SetOversizePolicy.
+type OversizePolicy int
+
+const (
+	// OversizeDrop discards the metric and returns an error.
+	// This is the default.
+	OversizeDrop OversizePolicy = iota
+
+	// OversizeTruncate shortens the stat name of the metric
+	// so that it fits, marking the end of the shortened name
+	// with a '~'. The value, type, sample rate and tags of the
+	// metric are kept. If the metric still cannot fit, it is
+	// discarded as for OversizeDrop.
+	OversizeTruncate
+
+	// OversizeSend sends the metric in a packet of its own,
+	// ignoring the packet size. This suits stream connections
+	// such as TCP, which have no packet size limit; a datagram
+	// that is too large is likely to be rejected when written.
+	OversizeSend
+)
+
+// truncateMarker marks the end of a stat name
+// shortened by OversizeTruncate.
+const truncateMarker = '~'
+
+// setOversizePolicy sets the policy for metrics larger than
+// the packet size. See SetOversizePolicy for details.
+func (c *client) setOversizePolicy(policy OversizePolicy) error {
+	switch policy {
+	case OversizeDrop, OversizeTruncate, OversizeSend:
+	default:
+		return fmt.Errorf("unknown oversize policy %d", policy)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.oversize = policy
+	return nil
+}
+
+// getOversizePolicy returns the policy set with setOversizePolicy.
+func (c *client) getOversizePolicy() OversizePolicy {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.oversize
+}
+
+// fitMetric applies the oversize policy to metric, which is too big to
+// fit in a packet, and returns the metric to add to the buffer. Caller
+// must hold the client mutex lock.
+func (c *client) fitMetric(metric []byte) ([]byte, error) {
+	switch c.oversize {
+	case OversizeSend:
+		return metric, nil
+	case OversizeTruncate:
+		if m := truncateStat(metric, c.headerLen()+len(metric)-c.size); m != nil {
+			c.truncated.Add(1)
+			return m, nil
+		}
+	}
+	c.tooBig.Add(1)
+	return nil, errTooBig
+}
+
+// truncateStat returns a copy of metric with its stat name shortened
+// so that the metric is at least excess bytes smaller, allowing for
+// the marker added to the name, or nil if the name is too short.
+// The name is not cut within a UTF-8 sequence.
+func truncateStat(metric []byte, excess int) []byte {
+	i := bytes.IndexByte(metric, ':')
+	if i < 0 {
+		return nil
+	}
+	n := i - excess - 1
+	for n > 0 && !utf8.RuneStart(metric[n]) {
+		n--
+	}
+	if n <= 0 {
+		return nil
+	}
+	m := make([]byte, 0, len(metric)-excess)
+	m = append(m, metric[:n]...)
+	m = append(m, truncateMarker)
+	return append(m, metric[i:]...)
+}
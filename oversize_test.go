@@ -0,0 +1,77 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOversizePolicy(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 20)
+	defer cl.Close()
+	WithRandSource(halfSource{})(cl)
+	if got := cl.OversizePolicy(); got != OversizeDrop {
+		t.Fatalf("got default policy %v", got)
+	}
+	long := strings.Repeat("x", 20)
+	cl.Increment("a", 1, 1)
+	if err := cl.Increment(long, 1, 1); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := cl.SetOversizePolicy(OversizeTruncate); err != nil {
+		t.Fatal(err)
+	}
+	if got := cl.OversizePolicy(); got != OversizeTruncate {
+		t.Fatalf("got policy %v", got)
+	}
+	// The value, type, rate and tags are kept.
+	if err := cl.Timing(long, 123, 0.75, "k:v"); err != nil {
+		t.Fatal(err)
+	}
+	// A stat name that cannot be shortened enough is dropped.
+	if err := cl.Increment("y", 1, 1, strings.Repeat("t", 20)); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := cl.SetOversizePolicy(OversizeSend); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("b", 1, 1)
+	if err := cl.Increment(long, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("c", 1, 1)
+	cl.Flush()
+	assert(t, strings.Join(packets, " "), "a:1|c x~:123|ms|@0.75|#k:v b:1|c "+long+":1|c c:1|c")
+
+	stats := cl.Stats()
+	if stats.TooBig != 2 || stats.Truncated != 1 {
+		t.Fatalf("got %d too big and %d truncated, want 2 and 1", stats.TooBig, stats.Truncated)
+	}
+	if err := cl.SetOversizePolicy(OversizePolicy(99)); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
+
+func TestTruncateStat(t *testing.T) {
+	tests := []struct {
+		metric string
+		excess int
+		want   string
+	}{
+		{"abcdef:1|c", 1, "abcd~:1|c"},
+		{"abcdef:1|c", 4, "a~:1|c"},
+		{"abcdef:1|c", 5, ""},
+		// The name is not cut within a UTF-8 sequence.
+		{"aé€b:1|c", 2, "aé~:1|c"},
+		{"aé€b:1|c", 3, "aé~:1|c"},
+		{"aé€b:1|c", 4, "a~:1|c"},
+	}
+	for _, test := range tests {
+		got := string(truncateStat([]byte(test.metric), test.excess))
+		if got != test.want {
+			t.Errorf("truncateStat(%q, %d) = %q, want %q", test.metric, test.excess, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package statsd
+
+import (
+	"fmt"
+	"time"
+)
+
+// pacer spaces out packet writes to limit their rate.
+type pacer struct {
+	interval time.Duration
+
+	// next holds the earliest time at which
+	// the next packet may be written.
+	next time.Time
+}
+
+// setMaxPacketsPerSecond limits the rate at which packets are
+// written. See SetMaxPacketsPerSecond for details.
+func (c *client) setMaxPacketsPerSecond(n int) error {
+	if n < 0 {
+		return fmt.Errorf("negative packet rate %d", n)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if n == 0 {
+		c.pacer = nil
+		return nil
+	}
+	if c.pacer == nil {
+		c.pacer = &pacer{}
+	}
+	c.pacer.interval = time.Second / time.Duration(n)
+	return nil
+}
+
+// wait waits until the next packet may be written.
+func (p *pacer) wait() {
+	now := time.Now()
+	if d := p.next.Sub(now); d > 0 {
+		time.Sleep(d)
+		now = p.next
+	}
+	p.next = now.Add(p.interval)
+}
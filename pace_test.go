@@ -0,0 +1,43 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxPacketsPerSecond(t *testing.T) {
+	var packets []string
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	defer cl.Close()
+	if err := cl.SetMaxPacketsPerSecond(-1); err == nil {
+		t.Fatal("expected error for negative rate")
+	}
+	const rate, k = 100, 6
+	if err := cl.SetMaxPacketsPerSecond(rate); err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Now()
+	for i := 0; i < k; i++ {
+		cl.Increment("a", 1, 1)
+		cl.Flush()
+	}
+	if d, want := time.Since(t0), (k-1)*time.Second/rate; d < want {
+		t.Fatalf("%d packets written in %v, want at least %v", len(packets), d, want)
+	}
+	if len(packets) != k {
+		t.Fatalf("got %d packets, want %d", len(packets), k)
+	}
+
+	// Removing the limit stops the pacing.
+	if err := cl.SetMaxPacketsPerSecond(0); err != nil {
+		t.Fatal(err)
+	}
+	t0 = time.Now()
+	for i := 0; i < 100; i++ {
+		cl.Increment("a", 1, 1)
+		cl.Flush()
+	}
+	if d := time.Since(t0); d > time.Second/2 {
+		t.Fatalf("unlimited packets took %v", d)
+	}
+}
@@ -0,0 +1,199 @@
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+var errReconnecting = errors.New("metrics dropped while reconnecting")
+
+// reconnector holds the state of the background goroutine
+// started by startReconnect.
+type reconnector struct {
+	stop chan struct{}
+}
+
+// setReconnectBackoff sets the bounds of the delay between attempts
+// to redial a stream connection. See SetReconnectBackoff for details.
+func (c *client) setReconnectBackoff(min, max time.Duration) error {
+	if min <= 0 || max < min {
+		return fmt.Errorf("invalid reconnect backoff %v to %v", min, max)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.minBackoff, c.maxBackoff = min, max
+	return nil
+}
+
+// startReconnect closes the client connection and starts redialing
+// it in the background. Caller must hold the client mutex lock.
+func (c *client) startReconnect() {
+	c.closeConn()
+	min, max := c.minBackoff, c.maxBackoff
+	if min == 0 {
+		min, max = defaultMinBackoff, defaultMaxBackoff
+	}
+	c.reconnect = &reconnector{
+		stop: make(chan struct{}),
+	}
+	go c.redial(c.reconnect, c.addr, min, max)
+}
+
+// stopReconnect stops any background redial. Caller must hold
+// the client mutex lock.
+func (c *client) stopReconnect() {
+	if c.reconnect != nil {
+		close(c.reconnect.stop)
+		c.reconnect = nil
+	}
+}
+
+// redial tries to connect to addr until it succeeds or r is stopped,
+// waiting between attempts for a delay that starts at min and doubles
+// after each failure up to max. Failures are passed to the error
+// function. Addresses are dialed without the client mutex lock held.
+func (c *client) redial(r *reconnector, addr string, min, max time.Duration) {
+	backoff := min
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-r.stop:
+			timer.Stop()
+			return
+		}
+		conn, err := c.dialAddr(addr)
+		if err != nil {
+			c.reportError(fmt.Errorf("cannot reconnect: %v", err))
+			backoff *= 2
+			if backoff > max {
+				backoff = max
+			}
+			continue
+		}
+		c.m.Lock()
+		if c.reconnect != r {
+			c.m.Unlock()
+			conn.Close()
+			return
+		}
+		c.reconnect = nil
+		c.setConn(conn)
+		c.m.Unlock()
+		return
+	}
+}
+
+// countDropped adds the number of metrics in packet
+// to the count of dropped metrics.
+func (c *client) countDropped(packet []byte) {
+	c.dropped.Add(int64(c.packetMetrics(packet)))
+}
+
+// packetMetrics returns the number of metrics in packet, which
+// was flushed from the buffer. Caller must hold the client mutex
+// lock.
+func (c *client) packetMetrics(packet []byte) int {
+	packet = bytes.TrimSuffix(packet, []byte("\n"))
+	n := bytes.Count(packet, []byte("\n")) + 1
+	if len(c.header) > 0 {
+		n--
+	}
+	return n
+}
+
+// ClientStats holds statistics about a client. Except for the
+// pending counts, they are cumulative since the client was created
+// or ResetStats was last called.
+type ClientStats struct {
+	// Recorded holds the number of metrics added to the
+	// buffer to be sent, and SampledOut the number discarded
+	// because of their sample rate.
+	Recorded   int64
+	SampledOut int64
+
+	// PacketsWritten and BytesWritten hold the number of
+	// packets written to the connection and their total size.
+	// WriteErrors holds the number of packets that could not
+	// be written.
+	PacketsWritten int64
+	BytesWritten   int64
+	WriteErrors    int64
+
+	// CallbackPanics holds the number of panics recovered
+	// from the functions set by SetErrorFunc and SetFlushFunc.
+	CallbackPanics int64
+
+	// Dropped holds the number of metrics discarded because
+	// they could not be sent, for example while a TCP
+	// connection was being redialed, because the
+	// asynchronous queue was full or because they were
+	// discarded by the overflow policy.
+	Dropped int64
+
+	// TooBig holds the number of metrics discarded because
+	// they were larger than the packet size, and Truncated
+	// the number whose stat names were shortened to fit, as
+	// set with SetOversizePolicy.
+	TooBig    int64
+	Truncated int64
+
+	// PendingMetrics and PendingBytes hold the number of
+	// metrics in the buffer waiting to be flushed and their
+	// size in bytes, as returned by Pending.
+	PendingMetrics int
+	PendingBytes   int
+}
+
+// stats returns the statistics of the client.
+func (c *client) stats() ClientStats {
+	metrics, bytes := c.pending()
+	return ClientStats{
+		Recorded:       c.recorded.Load(),
+		SampledOut:     c.sampledOut.Load(),
+		PacketsWritten: c.packetsWritten.Load(),
+		BytesWritten:   c.bytesWritten.Load(),
+		WriteErrors:    c.writeErrors.Load(),
+		CallbackPanics: c.callbackPanics.Load(),
+		Dropped:        c.dropped.Load(),
+		TooBig:         c.tooBig.Load(),
+		Truncated:      c.truncated.Load(),
+		PendingMetrics: metrics,
+		PendingBytes:   bytes,
+	}
+}
+
+// resetStats sets the cumulative statistics of the client to zero.
+func (c *client) resetStats() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.recorded.Store(0)
+	c.sampledOut.Store(0)
+	c.packetsWritten.Store(0)
+	c.bytesWritten.Store(0)
+	c.writeErrors.Store(0)
+	c.callbackPanics.Store(0)
+	c.dropped.Store(0)
+	c.tooBig.Store(0)
+	c.truncated.Store(0)
+	// Dropped metrics not yet reported are not reported.
+	c.dropReported = 0
+}
+
+// pending returns the number of metrics in the buffer
+// and their size in bytes.
+func (c *client) pending() (metrics, bytes int) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.buffered, c.buf.Len()
+}
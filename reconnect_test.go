@@ -0,0 +1,220 @@
+package statsd
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcpServer accepts TCP connections and sends the lines
+// read from them on a channel.
+type tcpServer struct {
+	ln    net.Listener
+	lines chan string
+	conns chan net.Conn
+}
+
+func newTCPServer(t *testing.T, addr string) *tcpServer {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveLines(ln)
+}
+
+// serveLines returns a tcpServer that accepts connections from ln.
+func serveLines(ln net.Listener) *tcpServer {
+	s := &tcpServer{
+		ln:    ln,
+		lines: make(chan string, 100),
+		conns: make(chan net.Conn, 10),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns <- conn
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					s.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+// close closes the listener and all accepted connections.
+func (s *tcpServer) close() {
+	s.ln.Close()
+	for {
+		select {
+		case conn := <-s.conns:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (s *tcpServer) waitLine(t *testing.T, want string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-s.lines:
+			if line == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+func TestTCP(t *testing.T) {
+	s := newTCPServer(t, "127.0.0.1:0")
+	defer s.close()
+	cl, err := NewClient("tcp://" + s.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	// Lines from separate writes are not joined.
+	for i := 1; i <= 2; i++ {
+		if err := cl.Increment("incr", i, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Increment("incr", i*10, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"incr:1|c", "incr:10|c", "incr:2|c", "incr:20|c"} {
+		select {
+		case line := <-s.lines:
+			assert(t, line, want)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+func TestTCPReconnect(t *testing.T) {
+	s := newTCPServer(t, "127.0.0.1:0")
+	addr := s.ln.Addr().String()
+	cl, err := NewClient("tcp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if err := cl.SetReconnectBackoff(time.Millisecond, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 100)
+	cl.SetErrorFunc(func(err error) {
+		select {
+		case errc <- err:
+		default:
+		}
+	})
+	if err := cl.Increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.waitLine(t, "incr:1|c")
+
+	// Stop the server. Writes fail once the connection
+	// reset is noticed.
+	s.close()
+	for i := 0; ; i++ {
+		if i == 100 {
+			t.Fatalf("no write error after server stopped")
+		}
+		cl.Increment("incr", 2, 1)
+		if cl.Flush() != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cl.Stats().Dropped == 0 {
+		t.Fatalf("no metrics counted as dropped")
+	}
+	select {
+	case err := <-errc:
+		if !strings.HasPrefix(err.Error(), "cannot reconnect: ") {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reconnection error reported")
+	}
+
+	// Restart the server; metrics flow again once
+	// the connection has been redialed.
+	s = newTCPServer(t, addr)
+	defer s.close()
+	for i := 0; ; i++ {
+		if i == 500 {
+			t.Fatalf("metrics not sent after server restarted")
+		}
+		cl.Increment("incr", 3, 1)
+		if cl.Flush() == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.waitLine(t, "incr:3|c")
+}
+
+func TestReconnectDrops(t *testing.T) {
+	n := newFakeNet()
+	c := newClient()
+	c.dial = n.dial
+	if err := c.setAddr("tcp://a:8125"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.setReconnectBackoff(time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	n.set(n.failing, "a:8125", true)
+	c.increment("incr", 1, 1)
+	c.increment("incr", 2, 1)
+	c.m.Lock()
+	err := c.flush()
+	c.m.Unlock()
+	assert(t, err.Error(), "connection refused")
+	// While reconnecting, packets are dropped without
+	// being written.
+	n.set(n.failing, "a:8125", false)
+	c.increment("incr", 3, 1)
+	c.m.Lock()
+	err = c.flush()
+	c.m.Unlock()
+	if err != errReconnecting {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := c.stats().Dropped; got != 3 {
+		t.Fatalf("got %d dropped metrics, want 3", got)
+	}
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, n.received("a:8125"), "")
+}
+
+func TestReconnectBackoffInvalid(t *testing.T) {
+	c := newClient()
+	for _, b := range [][2]time.Duration{{0, time.Second}, {time.Second, time.Millisecond}} {
+		if err := c.setReconnectBackoff(b[0], b[1]); err == nil {
+			t.Errorf("no error for backoff %v", b)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package statsd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// resolver holds the state of the background goroutine
+// started by setResolveInterval.
+type resolver struct {
+	stop chan struct{}
+}
+
+// setResolveInterval sets how often the host name in the address
+// is resolved again. See SetResolveInterval for details.
+func (c *client) setResolveInterval(interval time.Duration) error {
+	if interval < 0 {
+		return fmt.Errorf("negative resolve interval %v", interval)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.stopResolver()
+	if interval > 0 {
+		c.resolver = &resolver{
+			stop: make(chan struct{}),
+		}
+		go c.resolve(c.resolver, interval)
+	}
+	return nil
+}
+
+// stopResolver stops any background re-resolution. Caller must
+// hold the client mutex lock.
+func (c *client) stopResolver() {
+	if c.resolver != nil {
+		close(c.resolver.stop)
+		c.resolver = nil
+	}
+}
+
+// resolve resolves the host name in the client address every interval
+// until r is stopped, reconnecting when the address that the client
+// sends to is no longer among those the name resolves to.
+func (c *client) resolve(r *resolver, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+		c.reportError(c.resolveOnce(r))
+	}
+}
+
+// resolveOnce resolves the host name in the client address and
+// reconnects if needed. The name is resolved and dialed without the
+// client mutex lock held.
+func (c *client) resolveOnce(r *resolver) error {
+	c.m.Lock()
+	addr := c.addr
+	c.m.Unlock()
+
+	network, address, err := parseAddr(addr)
+	if err != nil || !strings.HasPrefix(network, "udp") {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return nil
+	}
+	lookupHost := c.lookupHost
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
+	ips, err := lookupHost(host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %v", host, err)
+	}
+	ips = filterFamily(network, ips)
+	if len(ips) == 0 {
+		return nil
+	}
+
+	c.m.Lock()
+	current := c.remoteIP
+	if c.resolver != r || c.addr != addr {
+		c.m.Unlock()
+		return nil
+	}
+	if current == "" {
+		// We don't know where the connection sends to,
+		// so assume that it is the first address.
+		c.remoteIP = ips[0]
+		c.m.Unlock()
+		return nil
+	}
+	c.m.Unlock()
+	for _, ip := range ips {
+		if ip == current {
+			return nil
+		}
+	}
+
+	conn, err := c.dialNetwork(network, net.JoinHostPort(ips[0], port))
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	if c.resolver != r || c.addr != addr {
+		c.m.Unlock()
+		conn.Close()
+		return nil
+	}
+	// Send any buffered metrics to the old address first.
+	if c.buf.Len() > 0 {
+		err = c.flush()
+	}
+	c.stopReconnect()
+	c.closeConn()
+	c.setConn(conn)
+	c.remoteIP = ips[0]
+	c.m.Unlock()
+	return err
+}
+
+// dialHost connects to address on the named network. When the network
+// is UDP and the host name in address resolves to several addresses of
+// the network's family, each is tried in turn until one can be dialed
+// and written to, and an error listing all the addresses tried is
+// returned if none can. The write is of an empty packet, which statsd
+// servers ignore; it fails when there is no route to the address.
+func dialHost(network, address string, lookupHost func(string) ([]string, error), dial func(network, address string) (io.WriteCloser, error)) (io.WriteCloser, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !strings.HasPrefix(network, "udp") || net.ParseIP(host) != nil {
+		return dial(network, address)
+	}
+	ips, err := lookupHost(host)
+	if err != nil {
+		// Let dial report the error.
+		return dial(network, address)
+	}
+	ips = filterFamily(network, ips)
+	if len(ips) < 2 {
+		return dial(network, address)
+	}
+	var errs []string
+	for _, ip := range ips {
+		conn, err := dial(network, net.JoinHostPort(ip, port))
+		if err == nil {
+			if _, err = conn.Write(nil); err == nil {
+				return conn, nil
+			}
+			conn.Close()
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", ip, err))
+	}
+	return nil, fmt.Errorf("cannot connect to any address of %s: %s", host, strings.Join(errs, "; "))
+}
+
+// filterFamily returns the addresses in ips that belong to the
+// address family of network, which may be udp4 or udp6. For other
+// networks, ips is returned unchanged.
+func filterFamily(network string, ips []string) []string {
+	if network != "udp4" && network != "udp6" {
+		return ips
+	}
+	var family []string
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			continue
+		}
+		if (parsed.To4() != nil) == (network == "udp4") {
+			family = append(family, ip)
+		}
+	}
+	return family
+}
+
+// remoteIP returns the IP address that conn sends to,
+// or the empty string if it is not known.
+func remoteIP(conn io.WriteCloser) string {
+	if conn, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok {
+			return addr.IP.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,242 @@
+package statsd
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver is a host name resolver for resolve tests.
+type fakeResolver struct {
+	mu      sync.Mutex
+	ips     []string
+	err     error
+	lookups int
+}
+
+func (r *fakeResolver) set(err error, ips ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ips, r.err = ips, err
+}
+
+func (r *fakeResolver) lookupHost(host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	return r.ips, r.err
+}
+
+func (r *fakeResolver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
+
+func newResolveTestClient(t *testing.T, addr string) (*client, *fakeNet, *fakeResolver) {
+	n := newFakeNet()
+	r := &fakeResolver{}
+	c := newClient()
+	c.dial = n.dial
+	c.lookupHost = r.lookupHost
+	if err := c.setAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	return c, n, r
+}
+
+func TestResolveSwap(t *testing.T) {
+	c, n, r := newResolveTestClient(t, "statsd.local:8125")
+	rs := &resolver{}
+	c.resolver = rs
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.set(nil, "10.0.0.1")
+	checkErr(c.resolveOnce(rs))
+	checkErr(c.increment("incr", 1, 1))
+	// The address is unchanged, so nothing happens.
+	r.set(nil, "10.0.0.3", "10.0.0.1")
+	checkErr(c.resolveOnce(rs))
+	checkErr(c.increment("incr", 2, 1))
+	// The address has changed, so the buffered metrics are
+	// flushed to the old connection before reconnecting.
+	r.set(nil, "10.0.0.2")
+	checkErr(c.resolveOnce(rs))
+	checkErr(c.increment("incr", 3, 1))
+	c.resolver = nil
+	checkErr(c.close())
+	assert(t, n.received("statsd.local:8125"), "incr:1|c\nincr:2|c")
+	assert(t, n.received("10.0.0.2:8125"), "incr:3|c")
+}
+
+func TestResolveError(t *testing.T) {
+	c, n, r := newResolveTestClient(t, "statsd.local:8125")
+	rs := &resolver{}
+	c.resolver = rs
+	r.set(errors.New("no such host"))
+	err := c.resolveOnce(rs)
+	assert(t, err.Error(), "cannot resolve statsd.local: no such host")
+	// The connection is kept.
+	if err := c.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	c.resolver = nil
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, n.received("statsd.local:8125"), "incr:1|c")
+}
+
+func TestResolveNoHostName(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8125", "[::1]:8125", "/var/run/statsd.sock"} {
+		c, _, r := newResolveTestClient(t, addr)
+		rs := &resolver{}
+		c.resolver = rs
+		if err := c.resolveOnce(rs); err != nil {
+			t.Fatal(err)
+		}
+		if r.count() != 0 {
+			t.Errorf("%s: address looked up", addr)
+		}
+	}
+}
+
+func TestResolveInterval(t *testing.T) {
+	c, n, r := newResolveTestClient(t, "statsd.local:8125")
+	r.set(nil, "10.0.0.1")
+	if err := c.setResolveInterval(-1); err == nil {
+		t.Fatalf("no error for negative interval")
+	}
+	if err := c.setResolveInterval(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for r.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r.set(nil, "10.0.0.2")
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		c.m.Lock()
+		ip := c.remoteIP
+		c.m.Unlock()
+		if ip == "10.0.0.2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for reconnection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, n.received("10.0.0.2:8125"), "incr:1|c")
+
+	// Close stops the re-resolution, although a lookup
+	// may already be in progress.
+	time.Sleep(10 * time.Millisecond)
+	count := r.count()
+	time.Sleep(10 * time.Millisecond)
+	if r.count() != count {
+		t.Fatalf("host name resolved after Close")
+	}
+}
+
+var dialHostTests = []struct {
+	about   string
+	network string
+	address string
+	ips     []string
+	failing []string
+	want    string
+	err     string
+}{{
+	about:   "IPv4 literal",
+	network: "udp",
+	address: "127.0.0.1:8125",
+	ips:     []string{"10.0.0.1", "10.0.0.2"},
+	want:    "127.0.0.1:8125",
+}, {
+	about:   "IPv6 literal",
+	network: "udp",
+	address: "[::1]:8125",
+	ips:     []string{"10.0.0.1", "10.0.0.2"},
+	want:    "[::1]:8125",
+}, {
+	about:   "host name with one address",
+	network: "udp",
+	address: "statsd.local:8125",
+	ips:     []string{"10.0.0.1"},
+	want:    "statsd.local:8125",
+}, {
+	about:   "host name with several addresses",
+	network: "udp",
+	address: "statsd.local:8125",
+	ips:     []string{"2001:db8::1", "10.0.0.1"},
+	want:    "[2001:db8::1]:8125",
+}, {
+	about:   "first address not routable",
+	network: "udp",
+	address: "statsd.local:8125",
+	ips:     []string{"2001:db8::1", "10.0.0.1"},
+	failing: []string{"[2001:db8::1]:8125"},
+	want:    "10.0.0.1:8125",
+}, {
+	about:   "IPv6 only",
+	network: "udp6",
+	address: "statsd.local:8125",
+	ips:     []string{"10.0.0.1", "2001:db8::1", "2001:db8::2"},
+	failing: []string{"[2001:db8::1]:8125"},
+	want:    "[2001:db8::2]:8125",
+}, {
+	about:   "IPv4 only",
+	network: "udp4",
+	address: "statsd.local:8125",
+	ips:     []string{"2001:db8::1", "10.0.0.1", "10.0.0.2"},
+	want:    "10.0.0.1:8125",
+}, {
+	about:   "no address routable",
+	network: "udp",
+	address: "statsd.local:8125",
+	ips:     []string{"2001:db8::1", "10.0.0.1"},
+	failing: []string{"[2001:db8::1]:8125", "10.0.0.1:8125"},
+	err:     "cannot connect to any address of statsd.local: 2001:db8::1: connection refused; 10.0.0.1: connection refused",
+}, {
+	about:   "TCP",
+	network: "tcp",
+	address: "statsd.local:8125",
+	ips:     []string{"2001:db8::1", "10.0.0.1"},
+	want:    "statsd.local:8125",
+}}
+
+func TestDialHost(t *testing.T) {
+	for _, test := range dialHostTests {
+		t.Run(test.about, func(t *testing.T) {
+			n := newFakeNet()
+			for _, addr := range test.failing {
+				n.failing[addr] = true
+			}
+			r := &fakeResolver{}
+			r.set(nil, test.ips...)
+			conn, err := dialHost(test.network, test.address, r.lookupHost, n.dial)
+			if test.err != "" {
+				if err == nil {
+					t.Fatalf("no error, want %q", test.err)
+				}
+				assert(t, err.Error(), test.err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert(t, conn.(fakeNetConn).addr, test.want)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Statter is the set of methods for sending metrics that is shared
+// by *Client, the routers returned by NewRouter and the no-op Statter
+// returned by Nop, so that libraries can accept a Statter and callers
+// can pass any of them.
+type Statter interface {
+	Increment(stat string, count int, rate float64, tags ...string) error
+	Decrement(stat string, count int, rate float64, tags ...string) error
+	Duration(stat string, duration time.Duration, rate float64, tags ...string) error
+	Timing(stat string, delta int, rate float64, tags ...string) error
+	TimingFloat(stat string, delta float64, rate float64, tags ...string) error
+	Gauge(stat string, value int, rate float64, tags ...string) error
+	GaugeFloat64(stat string, value float64, rate float64, tags ...string) error
+	IncrementGauge(stat string, value int, rate float64, tags ...string) error
+	DecrementGauge(stat string, value int, rate float64, tags ...string) error
+	Unique(stat string, value int, rate float64, tags ...string) error
+	Histogram(stat string, value float64, rate float64, tags ...string) error
+	Distribution(stat string, value float64, rate float64, tags ...string) error
+	Flush() error
+	Close() error
+}
+
+var _ Statter = (*Client)(nil)
+
+// router sends each metric to the client whose
+// route is the longest prefix of its stat name.
+type router struct {
+	// prefixes holds the route prefixes, longest first.
+	prefixes []string
+	routes   map[string]*Client
+	fallback *Client
+
+	// clients holds each distinct client once,
+	// for Flush and Close.
+	clients []*Client
+}
+
+// NewRouter returns a Statter that sends each metric to the client
+// in routes whose key is the longest prefix of the metric's stat name,
+// or to fallback if there is none. If fallback is nil, sending a metric
+// that matches no route returns an error. The stat name is passed to the
+// chosen client unchanged, so the client's own prefix, if any, is
+// added after routing.
+//
+// Flush and Close act on every client, and return all the errors
+// that they encounter.
+func NewRouter(routes map[string]*Client, fallback *Client) Statter {
+	r := &router{
+		routes:   make(map[string]*Client, len(routes)),
+		fallback: fallback,
+	}
+	seen := make(map[*Client]bool)
+	addClient := func(cl *Client) {
+		if cl != nil && !seen[cl] {
+			seen[cl] = true
+			r.clients = append(r.clients, cl)
+		}
+	}
+	for prefix, cl := range routes {
+		r.prefixes = append(r.prefixes, prefix)
+		r.routes[prefix] = cl
+	}
+	sort.Slice(r.prefixes, func(i, j int) bool {
+		if len(r.prefixes[i]) != len(r.prefixes[j]) {
+			return len(r.prefixes[i]) > len(r.prefixes[j])
+		}
+		return r.prefixes[i] < r.prefixes[j]
+	})
+	for _, prefix := range r.prefixes {
+		addClient(r.routes[prefix])
+	}
+	addClient(fallback)
+	return r
+}
+
+// route returns the client to which metrics for stat are sent.
+func (r *router) route(stat string) (*Client, error) {
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(stat, prefix) {
+			return r.routes[prefix], nil
+		}
+	}
+	if r.fallback == nil {
+		return nil, fmt.Errorf("no route for stat %q", stat)
+	}
+	return r.fallback, nil
+}
+
+func (r *router) Increment(stat string, count int, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Increment(stat, count, rate, tags...)
+}
+
+func (r *router) Decrement(stat string, count int, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Decrement(stat, count, rate, tags...)
+}
+
+func (r *router) Duration(stat string, duration time.Duration, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Duration(stat, duration, rate, tags...)
+}
+
+func (r *router) Timing(stat string, delta int, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Timing(stat, delta, rate, tags...)
+}
+
+func (r *router) TimingFloat(stat string, delta float64, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.TimingFloat(stat, delta, rate, tags...)
+}
+
+func (r *router) Gauge(stat string, value int, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Gauge(stat, value, rate, tags...)
+}
+
+func (r *router) GaugeFloat64(stat string, value float64, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.GaugeFloat64(stat, value, rate, tags...)
+}
+
+func (r *router) IncrementGauge(stat string, value int, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.IncrementGauge(stat, value, rate, tags...)
+}
+
+func (r *router) DecrementGauge(stat string, value int, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.DecrementGauge(stat, value, rate, tags...)
+}
+
+func (r *router) Unique(stat string, value int, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Unique(stat, value, rate, tags...)
+}
+
+func (r *router) Histogram(stat string, value float64, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Histogram(stat, value, rate, tags...)
+}
+
+func (r *router) Distribution(stat string, value float64, rate float64, tags ...string) error {
+	cl, err := r.route(stat)
+	if err != nil {
+		return err
+	}
+	return cl.Distribution(stat, value, rate, tags...)
+}
+
+func (r *router) Flush() error {
+	var errs []error
+	for _, cl := range r.clients {
+		errs = append(errs, cl.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+func (r *router) Close() error {
+	var errs []error
+	for _, cl := range r.clients {
+		errs = append(errs, cl.Close())
+	}
+	return errors.Join(errs...)
+}
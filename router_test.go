@@ -0,0 +1,70 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var app, appHTTP, infra, fallback []string
+	r := NewRouter(map[string]*Client{
+		"app.":      NewClientWriter(packetWriter{&app}, 0),
+		"app.http.": NewClientWriter(packetWriter{&appHTTP}, 0),
+		"infra.":    NewClientWriter(packetWriter{&infra}, 0),
+	}, NewClientWriter(packetWriter{&fallback}, 0))
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(r.Increment("app.jobs", 1, 1))
+	checkErr(r.Timing("app.http.latency", 20, 1))
+	checkErr(r.Gauge("infra.disk", 90, 1))
+	checkErr(r.Increment("other", 1, 1, "tag:x"))
+	checkErr(r.Histogram("app.httpx", 1.5, 1))
+	checkErr(r.Flush())
+	assert(t, strings.Join(app, "|"), "app.jobs:1|c\napp.httpx:1.5|h")
+	assert(t, strings.Join(appHTTP, "|"), "app.http.latency:20|ms")
+	assert(t, strings.Join(infra, "|"), "infra.disk:90|g")
+	assert(t, strings.Join(fallback, "|"), "other:1|c|#tag:x")
+	checkErr(r.Close())
+}
+
+func TestRouterNoFallback(t *testing.T) {
+	var app []string
+	r := NewRouter(map[string]*Client{
+		"app.": NewClientWriter(packetWriter{&app}, 0),
+	}, nil)
+	defer r.Close()
+	err := r.Increment("other", 1, 1)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	assert(t, err.Error(), `no route for stat "other"`)
+}
+
+func TestRouterCloseFansOut(t *testing.T) {
+	var packets []string
+	closed := make([]int, 3)
+	shared := NewClientWriter(closeCountWriter{packetWriter{&packets}, &closed[0]}, 0)
+	r := NewRouter(map[string]*Client{
+		"a.": shared,
+		"b.": shared,
+		"c.": NewClientWriter(closeCountWriter{packetWriter{&packets}, &closed[1]}, 0),
+	}, NewClientWriter(closeCountWriter{packetWriter{&packets}, &closed[2]}, 0))
+	r.Increment("a.x", 1, 1)
+	r.Increment("c.x", 1, 1)
+	r.Increment("d.x", 1, 1)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A client used by several routes is closed once.
+	for i, n := range closed {
+		if n != 1 {
+			t.Errorf("client %d closed %d times", i, n)
+		}
+	}
+	if len(packets) != 3 {
+		t.Fatalf("got packets %q, want 3", packets)
+	}
+}
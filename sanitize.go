@@ -0,0 +1,300 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultReplacementChar replaces characters that cannot be sent
+// unless another character is set with SetReplacementChar.
+const defaultReplacementChar = '_'
+
+// TagError is the error returned when a tag cannot be sent
+// in the tag format in use.
+type TagError struct {
+	// Tag holds the offending tag.
+	Tag string
+
+	// Key holds the key of the offending tag, the part of the
+	// tag before the first colon.
+	Key string
+
+	// Format holds the tag format in use.
+	Format TagFormat
+}
+
+func (e *TagError) Error() string {
+	return fmt.Sprintf("invalid tag %q for %v format", e.Tag, e.Format)
+}
+
+func (f TagFormat) String() string {
+	switch f {
+	case TagFormatDogStatsD:
+		return "DogStatsD"
+	case TagFormatInflux:
+		return "InfluxDB"
+	case TagFormatGraphite:
+		return "Graphite"
+	case TagFormatSignalFx:
+		return "SignalFx"
+	}
+	return fmt.Sprintf("TagFormat(%d)", int(f))
+}
+
+// validStatRune reports whether r can be sent in a stat name
+// in the given format.
+func validStatRune(format TagFormat, r rune) bool {
+	switch r {
+	case '|', ':', '\n':
+		return false
+	case ',':
+		return format != TagFormatInflux
+	case ';':
+		return format != TagFormatGraphite
+	case '[':
+		return format != TagFormatSignalFx
+	}
+	return true
+}
+
+// hasUnsafeStatChar reports whether stat contains any character that
+// cannot be sent in a stat name in at least one format.
+func hasUnsafeStatChar[S string | []byte](stat S) bool {
+	for i := 0; i < len(stat); i++ {
+		switch stat[i] {
+		case '|', ':', '\n', ',', ';', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// validTagRune reports whether r can be sent at byte offset i of the
+// key of a tag, or of its value if value is true, in the given format.
+// In DogStatsD format the whole tag is treated as the key.
+func validTagRune(format TagFormat, value bool, i int, r rune) bool {
+	if r == '|' || r == '\n' {
+		return false
+	}
+	switch format {
+	case TagFormatDogStatsD:
+		return r != ','
+	case TagFormatInflux:
+		return !value || r != ':'
+	case TagFormatGraphite:
+		if value {
+			return r != ';' && r != ':' && r != ' ' && (i > 0 || r != '~')
+		}
+		return !strings.ContainsRune(";!^= ", r)
+	case TagFormatSignalFx:
+		if value {
+			return !strings.ContainsRune(",=:[]", r)
+		}
+		return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' ||
+			i > 0 && ('0' <= r && r <= '9' || r == '_' || r == '-')
+	}
+	return true
+}
+
+// splitTag splits tag into its key and value. In DogStatsD format the
+// whole tag is returned as the key. It returns false if a tag in a
+// format requiring "key:value" does not have that form.
+func splitTag(format TagFormat, tag string) (key, value string, ok bool) {
+	if format == TagFormatDogStatsD {
+		return tag, "", tag != ""
+	}
+	key, value, ok = strings.Cut(tag, ":")
+	return key, value, ok && key != "" && value != ""
+}
+
+// checkTag returns a *TagError if tag cannot be sent in the given format.
+func checkTag(format TagFormat, tag string) error {
+	key, value, ok := splitTag(format, tag)
+	if ok {
+		ok = validTagPart(format, false, key) && validTagPart(format, true, value)
+	}
+	if !ok {
+		key, _, _ := strings.Cut(tag, ":")
+		return &TagError{
+			Tag:    tag,
+			Key:    key,
+			Format: format,
+		}
+	}
+	return nil
+}
+
+// checkTags returns a *TagError for the first of the given tags
+// that cannot be sent in the given format.
+func checkTags(format TagFormat, tags []string) error {
+	for _, tag := range tags {
+		if err := checkTag(format, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validTagPart(format TagFormat, value bool, s string) bool {
+	for i, r := range s {
+		if !validTagRune(format, value, i, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func replaceTagPart(format TagFormat, value bool, s string, repl rune) string {
+	return strings.Map(func(r rune) rune {
+		// strings.Map does not pass the offset, but only the first
+		// character of a value or key is treated specially, and a
+		// replacement is always valid after the first character.
+		if !validTagRune(format, value, 1, r) {
+			return repl
+		}
+		return r
+	}, s)
+}
+
+// sanitizeTag returns tag with any characters that cannot be sent in the
+// given format replaced by repl. It returns a *TagError if the tag still
+// cannot be sent, for example because it is empty.
+func sanitizeTag(format TagFormat, tag string, repl rune) (string, error) {
+	if checkTag(format, tag) == nil {
+		return tag, nil
+	}
+	key, value, ok := splitTag(format, tag)
+	if ok {
+		fixed := replaceTagPart(format, false, key, repl)
+		if format != TagFormatDogStatsD {
+			value = replaceTagPart(format, true, value, repl)
+			if !validTagRune(format, true, 0, rune(value[0])) {
+				value = string(repl) + value[1:]
+			}
+			fixed += ":" + value
+		}
+		if checkTag(format, fixed) == nil {
+			return fixed, nil
+		}
+	}
+	return "", checkTag(format, tag)
+}
+
+// sanitizeTags is like sanitizeTag but applies to all the given tags.
+// If strict is true, no replacement is done. The tags slice is not
+// modified.
+func sanitizeTags(format TagFormat, strict bool, repl rune, tags []string) ([]string, error) {
+	copied := false
+	for i, tag := range tags {
+		if strict {
+			if err := checkTag(format, tag); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		fixed, err := sanitizeTag(format, tag, repl)
+		if err != nil {
+			return nil, err
+		}
+		if fixed != tag {
+			if !copied {
+				tags = append([]string(nil), tags...)
+				copied = true
+			}
+			tags[i] = fixed
+		}
+	}
+	return tags, nil
+}
+
+// sanitizeStat is like sanitizeTags but applies to a stat name.
+func sanitizeStat(format TagFormat, strict bool, repl rune, stat string) (string, error) {
+	if !hasUnsafeStatChar(stat) {
+		return stat, nil
+	}
+	valid := true
+	for _, r := range stat {
+		if !validStatRune(format, r) {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return stat, nil
+	}
+	if strict {
+		return "", fmt.Errorf("invalid stat name %q for %v format", stat, format)
+	}
+	return strings.Map(func(r rune) rune {
+		if !validStatRune(format, r) {
+			return repl
+		}
+		return r
+	}, stat), nil
+}
+
+// sanitize makes sure that the stat name and tags of m can be sent. In
+// strict mode, it returns an error if they cannot; otherwise it replaces
+// characters that cannot be sent with the replacement character, and
+// returns an error only for tags that cannot be fixed that way. Caller
+// must hold the client mutex lock.
+func (c *client) sanitize(m *Metric) error {
+	stat, err := sanitizeStat(c.tagFormat, c.strict, c.replacementChar(), m.Stat)
+	if err != nil {
+		return err
+	}
+	tags, err := sanitizeTags(c.tagFormat, c.strict, c.replacementChar(), m.Tags)
+	if err != nil {
+		return err
+	}
+	m.Stat, m.Tags = stat, tags
+	return nil
+}
+
+// replacementChar returns the character that replaces characters
+// that cannot be sent. Caller must hold the client mutex lock.
+func (c *client) replacementChar() rune {
+	if c.replacement == 0 {
+		return defaultReplacementChar
+	}
+	return c.replacement
+}
+
+// setStrict sets whether metrics with stat names or tags holding
+// characters that cannot be sent are rejected rather than fixed.
+// See SetStrict for details.
+func (c *client) setStrict(strict bool) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.setTagConfig(c.globalTags, c.tagFormat, strict, c.replacementChar())
+}
+
+// setReplacementChar sets the character that replaces characters
+// that cannot be sent. See SetReplacementChar for details.
+func (c *client) setReplacementChar(r rune) error {
+	if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+		return fmt.Errorf("invalid replacement character %q", r)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.setTagConfig(c.globalTags, c.tagFormat, c.strict, r)
+}
+
+// setTagConfig sets the global tags and the settings that determine
+// how they are sent. If the global tags cannot be sent with the new
+// settings, it returns an error and leaves the settings unchanged.
+// Caller must hold the client mutex lock.
+func (c *client) setTagConfig(globalTags []string, format TagFormat, strict bool, repl rune) error {
+	tags, err := sanitizeTags(format, strict, repl, globalTags)
+	if err != nil {
+		return err
+	}
+	c.globalTags = globalTags
+	c.tags = tags
+	c.tagFormat = format
+	c.strict = strict
+	c.replacement = repl
+	return nil
+}
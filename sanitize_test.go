@@ -0,0 +1,229 @@
+package statsd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sanitizeMethods holds a call of every metric method. Methods
+// that do not accept tags ignore them.
+var sanitizeMethods = []struct {
+	about string
+	send  func(c *client, stat string, tags []string) error
+}{
+	{"increment", func(c *client, stat string, tags []string) error { return c.increment(stat, 1, 1, tags...) }},
+	{"increment64", func(c *client, stat string, tags []string) error { return c.increment64(stat, 1, 1) }},
+	{"incrementFloat", func(c *client, stat string, tags []string) error { return c.incrementFloat(stat, 1, 1) }},
+	{"incrementBytes", func(c *client, stat string, tags []string) error { return c.incrementBytes("", []byte(stat), 1, 1) }},
+	{"decrement", func(c *client, stat string, tags []string) error { return c.decrement(stat, 1, 1, tags...) }},
+	{"duration", func(c *client, stat string, tags []string) error { return c.duration(stat, time.Second, 1, tags...) }},
+	{"durationBytes", func(c *client, stat string, tags []string) error {
+		return c.durationBytes("", []byte(stat), time.Second, 1)
+	}},
+	{"durationSince", func(c *client, stat string, tags []string) error { return c.durationSince(stat, time.Now(), 1) }},
+	{"durationN", func(c *client, stat string, tags []string) error { return c.durationN(stat, time.Second, 2, 1) }},
+	{"durations", func(c *client, stat string, tags []string) error {
+		return c.durations(stat, []time.Duration{time.Second}, 1)
+	}},
+	{"durationFloat", func(c *client, stat string, tags []string) error { return c.durationFloat(stat, time.Second, 1) }},
+	{"timing", func(c *client, stat string, tags []string) error { return c.timing(stat, 1, 1, tags...) }},
+	{"timingFloat", func(c *client, stat string, tags []string) error { return c.timingFloat(stat, 1, 1, tags...) }},
+	{"gauge", func(c *client, stat string, tags []string) error { return c.gauge(stat, 1, 1, tags...) }},
+	{"gauge64", func(c *client, stat string, tags []string) error { return c.gauge64(stat, 1, 1) }},
+	{"gaugeFloat64", func(c *client, stat string, tags []string) error { return c.gaugeFloat64(stat, 1, 1, tags...) }},
+	{"gaugeAt", func(c *client, stat string, tags []string) error { return c.gaugeAt(stat, 1, time.Unix(1, 0)) }},
+	{"gaugeValue", func(c *client, stat string, tags []string) error { return gaugeValue(c, stat, uint8(1), 1) }},
+	{"gaugeBytes", func(c *client, stat string, tags []string) error { return c.gaugeBytes("", []byte(stat), 1, 1) }},
+	{"gaugeBool", func(c *client, stat string, tags []string) error { return c.gaugeBool(stat, true, 1) }},
+	{"incrementGauge", func(c *client, stat string, tags []string) error { return c.incrementGauge(stat, 1, 1, tags...) }},
+	{"decrementGauge", func(c *client, stat string, tags []string) error { return c.decrementGauge(stat, 1, 1, tags...) }},
+	{"unique", func(c *client, stat string, tags []string) error { return c.unique(stat, 1, 1, tags...) }},
+	{"uniqueString", func(c *client, stat string, tags []string) error { return c.uniqueString(stat, "v", 1) }},
+	{"uniqueValue", func(c *client, stat string, tags []string) error { return c.uniqueValue(stat, 1, 1) }},
+	{"histogram", func(c *client, stat string, tags []string) error { return c.histogram(stat, 1, 1, tags...) }},
+	{"byteSize", func(c *client, stat string, tags []string) error { return c.byteSize(stat, 1, 1) }},
+	{"distribution", func(c *client, stat string, tags []string) error { return c.distribution(stat, 1, 1, tags...) }},
+	{"keyValue", func(c *client, stat string, tags []string) error { return c.keyValue(stat, 1) }},
+	{"sendRaw", func(c *client, stat string, tags []string) error { return c.sendRaw(stat, "1", "c", 1) }},
+	{"counter", func(c *client, stat string, tags []string) error { return c.counter(stat, 1).Add(1) }},
+	{"timer", func(c *client, stat string, tags []string) error { return c.timer(stat, 1).Observe(time.Second) }},
+	{"gaugeHandle", func(c *client, stat string, tags []string) error { return c.gaugeHandle(stat, 1).Set(1) }},
+}
+
+func TestSanitize(t *testing.T) {
+	stat := "a|b:c\nd"
+	tags := []string{"x|y", "new\nline", "ünï:cödé", "k,v"}
+	for _, m := range sanitizeMethods {
+		t.Run(m.about, func(t *testing.T) {
+			tc := newTestClient(t)
+			if err := m.send(tc.client, stat, tags); err != nil {
+				t.Fatal(err)
+			}
+			tc.assertClose(t)
+			out := tc.buf.String()
+			if strings.Contains(out, "\n") {
+				t.Fatalf("output holds more than one line: %q", out)
+			}
+			if !strings.HasPrefix(out, "a_b_c_d:") {
+				t.Fatalf("unexpected stat name in %q", out)
+			}
+			if strings.Contains(out, "|#") && !strings.HasSuffix(out, "|#x_y,new_line,ünï:cödé,k_v") {
+				t.Fatalf("unexpected tags in %q", out)
+			}
+		})
+	}
+}
+
+func TestSanitizeStrict(t *testing.T) {
+	for _, m := range sanitizeMethods {
+		t.Run(m.about, func(t *testing.T) {
+			tc := newTestClient(t)
+			if err := tc.client.setStrict(true); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.send(tc.client, "a|b", nil); err == nil {
+				t.Errorf("no error from invalid stat name")
+			}
+			if err := m.send(tc.client, "a\nb", nil); err == nil {
+				t.Errorf("no error from stat name with newline")
+			}
+			if err := m.send(tc.client, "ünïcödé", []string{"ünï:cödé"}); err != nil {
+				t.Errorf("unexpected error from unicode: %v", err)
+			}
+			tc.assertClose(t)
+			out := tc.buf.String()
+			if strings.Contains(out, "\n") || !strings.HasPrefix(out, "ünïcödé:") {
+				t.Fatalf("unexpected output %q", out)
+			}
+		})
+	}
+}
+
+func TestSanitizeEmptyTag(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		tc := newTestClient(t)
+		if err := tc.client.setStrict(strict); err != nil {
+			t.Fatal(err)
+		}
+		err := tc.client.increment("incr", 1, 1, "a", "")
+		var tagErr *TagError
+		if !errors.As(err, &tagErr) {
+			t.Fatalf("unexpected error %#v", err)
+		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), "")
+	}
+}
+
+func TestStrictTagError(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setTagFormat(TagFormatGraphite); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setStrict(true); err != nil {
+		t.Fatal(err)
+	}
+	err := tc.client.increment("incr", 1, 1, "ok:1", "region:eu west")
+	var tagErr *TagError
+	if !errors.As(err, &tagErr) {
+		t.Fatalf("unexpected error %#v", err)
+	}
+	assert(t, tagErr.Key, "region")
+	assert(t, tagErr.Tag, "region:eu west")
+	if tagErr.Format != TagFormatGraphite {
+		t.Fatalf("unexpected format %v", tagErr.Format)
+	}
+	assert(t, err.Error(), `invalid tag "region:eu west" for Graphite format`)
+}
+
+var sanitizeFormatTests = []struct {
+	format TagFormat
+	stat   string
+	tags   []string
+	expect string
+}{{
+	format: TagFormatDogStatsD,
+	stat:   "a,b;c[d",
+	tags:   []string{"a,b", "c|d:e"},
+	expect: "a,b;c[d:1|c|#a_b,c_d:e",
+}, {
+	format: TagFormatInflux,
+	stat:   "a,b;c",
+	tags:   []string{"a:b:c", "d e:f,g"},
+	expect: `a_b;c,a=b_c,d\ e=f\,g:1|c`,
+}, {
+	format: TagFormatGraphite,
+	stat:   "a;b,c",
+	tags:   []string{"a b:~c d", "e=f:g;h"},
+	expect: "a_b,c;a_b=_c_d;e_f=g_h:1|c",
+}, {
+	format: TagFormatSignalFx,
+	stat:   "a[b]",
+	tags:   []string{"a.b:c[d]", "e:f,g=h"},
+	expect: "a_b][a_b=c_d_,e=f_g_h]:1|c",
+}}
+
+func TestSanitizeFormats(t *testing.T) {
+	for _, test := range sanitizeFormatTests {
+		t.Run(test.format.String(), func(t *testing.T) {
+			tc := newTestClient(t)
+			if err := tc.client.setTagFormat(test.format); err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.client.increment(test.stat, 1, 1, test.tags...); err != nil {
+				t.Fatal(err)
+			}
+			tc.assertClose(t)
+			assert(t, tc.buf.String(), test.expect)
+		})
+	}
+}
+
+func TestSanitizeUnfixableTags(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setTagFormat(TagFormatSignalFx); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"", "novalue", ":v", "k:", "1k:v", "_k:v"} {
+		if err := tc.client.increment("incr", 1, 1, tag); err == nil {
+			t.Errorf("no error for tag %q", tag)
+		}
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestReplacementChar(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setGlobalTags([]string{"g|g"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setReplacementChar('-'); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setReplacementChar('|'); err == nil {
+		t.Fatal("no error from invalid replacement character")
+	}
+	if err := tc.client.increment("a:b", 1, 1, "x|y"); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "a-b:1|c|#g-g,x-y")
+}
+
+func TestSetStrictInvalidGlobalTags(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setGlobalTags([]string{"g|g"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setStrict(true); err == nil {
+		t.Fatal("no error from strict mode with invalid global tags")
+	}
+	if err := tc.client.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c|#g_g")
+}
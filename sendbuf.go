@@ -0,0 +1,34 @@
+package statsd
+
+import "fmt"
+
+// setSendBufferSize sets the size of the operating system's send
+// buffer for the connection. See SetSendBufferSize for details.
+func (c *client) setSendBufferSize(bytes int) error {
+	if bytes < 0 {
+		return fmt.Errorf("negative send buffer size %d", bytes)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.sendBufferSize = bytes
+	if bytes == 0 {
+		return nil
+	}
+	if conn, ok := socketConn(c.conn).(interface{ SetWriteBuffer(int) error }); ok {
+		return conn.SetWriteBuffer(bytes)
+	}
+	return nil
+}
+
+// getSendBufferSize returns the size of the operating system's send
+// buffer for the connection. See SendBufferSize for details.
+func (c *client) getSendBufferSize() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if size, ok := socketSendBufferSize(socketConn(c.conn)); ok {
+		return size
+	}
+	return c.sendBufferSize
+}
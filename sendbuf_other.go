@@ -0,0 +1,11 @@
+//go:build !unix
+
+package statsd
+
+import "io"
+
+// socketSendBufferSize returns the send buffer size of the socket
+// underlying conn. It is not known on this platform.
+func socketSendBufferSize(conn io.WriteCloser) (int, bool) {
+	return 0, false
+}
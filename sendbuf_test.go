@@ -0,0 +1,98 @@
+package statsd
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// bufferSizeConn is a connection that records the send
+// buffer sizes set on it.
+type bufferSizeConn struct {
+	sizes *[]int
+	err   error
+}
+
+func (c bufferSizeConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (c bufferSizeConn) Close() error {
+	return nil
+}
+
+func (c bufferSizeConn) SetWriteBuffer(bytes int) error {
+	*c.sizes = append(*c.sizes, bytes)
+	return c.err
+}
+
+func TestSendBufferSizeDial(t *testing.T) {
+	var sizes []int
+	var dialErr error
+	c := newClient()
+	c.dial = func(network, address string) (io.WriteCloser, error) {
+		return bufferSizeConn{sizes: &sizes, err: dialErr}, nil
+	}
+	errc := make(chan error, 1)
+	c.setErrorFunc(func(err error) {
+		errc <- err
+	})
+	if err := c.setSendBufferSize(-1); err == nil {
+		t.Fatalf("no error for negative size")
+	}
+	// Without a size, the buffer size is not set.
+	if err := c.setAddr("localhost:8125"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 0 {
+		t.Fatalf("unexpected buffer sizes %v", sizes)
+	}
+	// Setting a size applies it to the current connection
+	// and to later ones.
+	if err := c.setSendBufferSize(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.setAddr("localhost:8126"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] != 1<<20 || sizes[1] != 1<<20 {
+		t.Fatalf("unexpected buffer sizes %v", sizes)
+	}
+	if size := c.getSendBufferSize(); size != 1<<20 {
+		t.Fatalf("got send buffer size %d, want %d", size, 1<<20)
+	}
+
+	// A failure to set the size on a new connection is
+	// reported but does not stop it being used.
+	dialErr = errors.New("no buffer for you")
+	if err := c.setAddr("localhost:8127"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		assert(t, err.Error(), "cannot set send buffer size: no buffer for you")
+	case <-time.After(3 * time.Second):
+		t.Fatal("no error reported")
+	}
+}
+
+func TestSendBufferSizeSocket(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cl, err := NewClient(ln.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if err := cl.SetSendBufferSize(64 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if size := cl.SendBufferSize(); size <= 0 {
+		t.Fatalf("unexpected send buffer size %d", size)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package statsd
+
+import (
+	"io"
+	"syscall"
+)
+
+// socketSendBufferSize returns the send buffer size of the
+// socket underlying conn, if there is one.
+func socketSendBufferSize(conn io.WriteCloser) (int, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var size int
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		size, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil || serr != nil {
+		return 0, false
+	}
+	return size, true
+}
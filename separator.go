@@ -0,0 +1,57 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// WithSeparator returns an option that sets the separator between the
+// components of bucket names. Prefixes added by WithPrefix,
+// WithPrefixTemplate, Client.WithPrefix and SetPrefix are followed by
+// sep unless they already end with it, so that for example with the
+// separator '_', the prefix "api" and the stat "latency" give the
+// bucket name "api_latency". The suffixes of aggregated timer
+// summaries, such as "max", and of meter rates are preceded by sep. Without this option,
+// prefixes are used as given and suffixes are preceded by a dot.
+//
+// The option must be given before any options that add prefixes.
+func WithSeparator(sep rune) Option {
+	return func(cl *Client) error {
+		if err := checkShared(cl, "WithSeparator"); err != nil {
+			return err
+		}
+		if !unicode.IsPrint(sep) || unicode.IsSpace(sep) || hasUnsafeStatChar(string(sep)) {
+			return fmt.Errorf("invalid separator %q", sep)
+		}
+		if cl.prefix != "" {
+			return errors.New("separator set after prefix")
+		}
+		cl.c.separator = string(sep)
+		return nil
+	}
+}
+
+// sepPrefix returns prefix followed by the separator, unless there is
+// no separator or prefix already ends with it. The separator is only
+// set by New, so the lock need not be held.
+func (c *client) sepPrefix(prefix string) string {
+	if c.separator == "" || prefix == "" || strings.HasSuffix(prefix, c.separator) {
+		return prefix
+	}
+	return prefix + c.separator
+}
+
+// suffixStat returns stat followed by the separator, or a dot if
+// there is none, and suffix.
+func (c *client) suffixStat(stat, suffix string) string {
+	sep := c.separator
+	if sep == "" {
+		sep = "."
+	}
+	if strings.HasSuffix(stat, sep) {
+		return stat + suffix
+	}
+	return stat + sep + suffix
+}
@@ -0,0 +1,104 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithSeparator(t *testing.T) {
+	tests := []struct {
+		about     string
+		opts      []Option
+		prefix    string
+		setPrefix string
+		want      string
+	}{{
+		about: "no separator",
+		opts:  []Option{WithPrefix("api")},
+		want:  "apilatency:1|c",
+	}, {
+		about: "dot",
+		opts:  []Option{WithSeparator('.'), WithPrefix("api")},
+		want:  "api.latency:1|c",
+	}, {
+		about: "underscore",
+		opts:  []Option{WithSeparator('_'), WithPrefix("api")},
+		want:  "api_latency:1|c",
+	}, {
+		about: "dot already present",
+		opts:  []Option{WithSeparator('.'), WithPrefix("api.")},
+		want:  "api.latency:1|c",
+	}, {
+		about: "underscore already present",
+		opts:  []Option{WithSeparator('_'), WithPrefix("api_")},
+		want:  "api_latency:1|c",
+	}, {
+		about:  "dot with several prefixes",
+		opts:   []Option{WithSeparator('.'), WithPrefix("app"), WithPrefix("api.")},
+		prefix: "v1",
+		want:   "app.api.v1.latency:1|c",
+	}, {
+		about:     "underscore with several prefixes",
+		opts:      []Option{WithSeparator('_'), WithPrefixTemplate("app_%h")},
+		prefix:    "v1_",
+		setPrefix: "prod",
+		want:      "prod_app_host_v1_latency:1|c",
+	}}
+	defer func(f func() (string, error)) { osHostname = f }(osHostname)
+	osHostname = func() (string, error) {
+		return "host", nil
+	}
+	for _, test := range tests {
+		t.Run(test.about, func(t *testing.T) {
+			var packets []string
+			cl := NewClientWriter(packetWriter{&packets}, 0)
+			defer cl.Close()
+			for _, opt := range test.opts {
+				if err := opt(cl); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.setPrefix != "" {
+				cl.SetPrefix(test.setPrefix)
+			}
+			if test.prefix != "" {
+				cl = cl.WithPrefix(test.prefix)
+			}
+			cl.Increment("latency", 1, 1)
+			cl.Flush()
+			assert(t, strings.Join(packets, " "), test.want)
+		})
+	}
+}
+
+func TestWithSeparatorSuffixes(t *testing.T) {
+	for _, sep := range []rune{'.', '_'} {
+		var packets []string
+		cl := NewClientWriter(packetWriter{&packets}, 0)
+		defer cl.Close()
+		if err := WithSeparator(sep)(cl); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.c.setTimerAggregation(time.Hour, []float64{99.9}); err != nil {
+			t.Fatal(err)
+		}
+		cl.WithPrefix("api").Timing("latency", 5, 1)
+		cl.c.setTimerAggregation(0, nil)
+		cl.Flush()
+		want := "api.latency.p99_9:5|g\napi.latency.max:5|g\napi.latency.count:1|c"
+		assert(t, strings.Join(packets, " "), strings.ReplaceAll(want, ".", string(sep)))
+	}
+}
+
+func TestWithSeparatorInvalid(t *testing.T) {
+	for _, sep := range []rune{':', '|', ' ', '\n', 0} {
+		if _, err := New("127.0.0.1:8125", WithSeparator(sep)); err == nil {
+			t.Errorf("no error for separator %q", sep)
+		}
+	}
+	_, err := New("127.0.0.1:8125", WithPrefix("api"), WithSeparator('.'))
+	if err == nil || err.Error() != "separator set after prefix" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
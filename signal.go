@@ -0,0 +1,37 @@
+package statsd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// flushOnSignal flushes the client whenever one of the given signals
+// arrives. See FlushOnSignal for details.
+func (c *client) flushOnSignal(signals []os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigc := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigc, signals...)
+	go func() {
+		for {
+			select {
+			case <-sigc:
+				c.reportError(c.flushContext(context.Background()))
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigc)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+//go:build unix
+
+package statsd
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFlushOnSignal(t *testing.T) {
+	var packets []string
+	flushed := make(chan bool, 1)
+	cl := NewClientWriter(packetWriter{&packets}, 0)
+	defer cl.Close()
+	cl.SetFlushFunc(func(bytes, metrics int) {
+		flushed <- true
+	})
+
+	// The application can handle the signal too.
+	appc := make(chan os.Signal, 1)
+	signal.Notify(appc, syscall.SIGUSR1)
+	defer signal.Stop(appc)
+
+	stop := cl.FlushOnSignal(syscall.SIGUSR1)
+	cl.Increment("a", 1, 1)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no flush after signal")
+	}
+	<-appc
+	cl.Flush()
+	assert(t, strings.Join(packets, " "), "a:1|c")
+
+	// After stop, signals no longer flush.
+	stop()
+	stop()
+	cl.Increment("b", 1, 1)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	<-appc
+	select {
+	case <-flushed:
+		t.Fatal("flush after stop")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,214 @@
+package statsd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	defaultSpoolMaxSize     = 10 << 20
+	defaultSpoolReplayLimit = 100
+)
+
+// SpoolOption configures the spool set with SetSpool.
+type SpoolOption func(*spool)
+
+// SpoolMaxSize sets the maximum size in bytes of the spool file.
+// Packets that do not fit are discarded and counted in
+// ClientStats.Dropped. The default is 10MiB.
+func SpoolMaxSize(bytes int64) SpoolOption {
+	return func(s *spool) {
+		s.maxSize = bytes
+	}
+}
+
+// SpoolReplayLimit sets the maximum number of spooled packets that
+// are replayed each time the client flushes. The default is 100.
+func SpoolReplayLimit(n int) SpoolOption {
+	return func(s *spool) {
+		s.replayLimit = n
+	}
+}
+
+// spool holds packets that could not be written in a file,
+// as a sequence of packets each preceded by its length as
+// a 4-byte big-endian integer.
+type spool struct {
+	path        string
+	maxSize     int64
+	replayLimit int
+
+	f *os.File
+
+	// size holds the size of the file.
+	size int64
+
+	// offset holds the offset in the file of the
+	// next packet to be replayed.
+	offset int64
+
+	// buf holds the last packet read from the file.
+	buf []byte
+}
+
+// spoolHeaderSize holds the size of the length
+// preceding each packet in a spool file.
+const spoolHeaderSize = 4
+
+// setSpool makes the client spool packets to the file at path.
+// See SetSpool for details.
+func (c *client) setSpool(path string, opts []SpoolOption) error {
+	var s *spool
+	if path != "" {
+		s = &spool{
+			path:        path,
+			maxSize:     defaultSpoolMaxSize,
+			replayLimit: defaultSpoolReplayLimit,
+		}
+		for _, opt := range opts {
+			opt(s)
+		}
+		if s.maxSize <= spoolHeaderSize {
+			return fmt.Errorf("invalid spool size %d", s.maxSize)
+		}
+		if s.replayLimit < 1 {
+			return fmt.Errorf("invalid spool replay limit %d", s.replayLimit)
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		s.f = f
+		s.size = info.Size()
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.closeSpool()
+	c.spool = s
+	return nil
+}
+
+// closeSpool stops spooling packets, leaving any spooled packets in
+// the file. Caller must hold the client mutex lock.
+func (c *client) closeSpool() {
+	if c.spool != nil {
+		c.spool.f.Close()
+		c.spool = nil
+	}
+}
+
+// write writes packet to the client connection after replaying any
+// spooled packets. The packet is spooled if writing fails or if
+// spooled packets remain. When writing fails, the write error is
+// returned. Caller must hold the client mutex lock.
+func (s *spool) write(c *client, packet []byte) error {
+	if s.offset < s.size {
+		if err := s.replay(c); err != nil {
+			return s.add(c, packet, err)
+		}
+		if s.offset < s.size {
+			return s.add(c, packet, nil)
+		}
+	}
+	if err := c.write(packet); err != nil {
+		return s.add(c, packet, err)
+	}
+	return nil
+}
+
+// replay writes up to replayLimit spooled packets to the client
+// connection, oldest first, and returns any write error. Caller must
+// hold the client mutex lock.
+func (s *spool) replay(c *client) error {
+	for i := 0; i < s.replayLimit && s.offset < s.size; i++ {
+		packet, err := s.read()
+		if err != nil {
+			c.reportErrorAsync(fmt.Errorf("discarding spooled metrics in %s from offset %d: %v", s.path, s.offset, err))
+			s.size = s.offset
+			break
+		}
+		if err := c.write(packet); err != nil {
+			return err
+		}
+		s.offset += int64(spoolHeaderSize + len(packet))
+	}
+	if s.offset == s.size {
+		if err := s.f.Truncate(0); err != nil {
+			return err
+		}
+		s.offset, s.size = 0, 0
+	}
+	return nil
+}
+
+// read returns the packet at the current offset.
+func (s *spool) read() ([]byte, error) {
+	var hdr [spoolHeaderSize]byte
+	if _, err := s.f.ReadAt(hdr[:], s.offset); err != nil {
+		return nil, err
+	}
+	n := int64(binary.BigEndian.Uint32(hdr[:]))
+	if n == 0 || n > s.size-s.offset-spoolHeaderSize {
+		return nil, errors.New("invalid packet length")
+	}
+	if int64(cap(s.buf)) < n {
+		s.buf = make([]byte, n)
+	}
+	s.buf = s.buf[:n]
+	if _, err := s.f.ReadAt(s.buf, s.offset+spoolHeaderSize); err != nil {
+		return nil, err
+	}
+	return s.buf, nil
+}
+
+// add appends packet to the file and returns writeErr, the error
+// that made the packet be spooled. If the packet cannot be
+// spooled, it is discarded. Caller must hold the client mutex lock.
+func (s *spool) add(c *client, packet []byte, writeErr error) error {
+	n := int64(spoolHeaderSize + len(packet))
+	if s.size+n > s.maxSize && s.offset > 0 {
+		if err := s.compact(); err != nil {
+			c.countDropped(packet)
+			return errors.Join(writeErr, err)
+		}
+	}
+	if s.size+n > s.maxSize {
+		c.countDropped(packet)
+		return errors.Join(writeErr, fmt.Errorf("spool %s is full", s.path))
+	}
+	buf := make([]byte, spoolHeaderSize, n)
+	binary.BigEndian.PutUint32(buf, uint32(len(packet)))
+	buf = append(buf, packet...)
+	if _, err := s.f.WriteAt(buf, s.size); err != nil {
+		c.countDropped(packet)
+		return errors.Join(writeErr, err)
+	}
+	s.size += n
+	return writeErr
+}
+
+// compact removes the packets that have been replayed
+// from the start of the file.
+func (s *spool) compact() error {
+	buf := make([]byte, s.size-s.offset)
+	if _, err := s.f.ReadAt(buf, s.offset); err != nil && err != io.EOF {
+		return err
+	}
+	if _, err := s.f.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	if err := s.f.Truncate(int64(len(buf))); err != nil {
+		return err
+	}
+	s.offset, s.size = 0, int64(len(buf))
+	return nil
+}
@@ -0,0 +1,166 @@
+package statsd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// outageWriter is a packetWriter that fails while down is set.
+type outageWriter struct {
+	packetWriter
+	down *bool
+}
+
+func (w outageWriter) Write(p []byte) (int, error) {
+	if *w.down {
+		return 0, errors.New("network is down")
+	}
+	return w.packetWriter.Write(p)
+}
+
+// sendPackets sends each stat as a counter in its own packet.
+func sendPackets(t *testing.T, cl *Client, stats ...string) {
+	for _, stat := range stats {
+		if err := cl.Increment(stat, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		cl.Flush()
+	}
+}
+
+func TestSpool(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	var packets []string
+	down := false
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	if err := cl.SetSpool(path, SpoolReplayLimit(2)); err != nil {
+		t.Fatal(err)
+	}
+	sendPackets(t, cl, "a")
+
+	down = true
+	cl.Increment("b", 1, 1)
+	if err := cl.Flush(); err == nil || err.Error() != "network is down" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sendPackets(t, cl, "c", "d", "e")
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Fatalf("nothing spooled: %v", err)
+	}
+
+	// Each flush replays up to two spooled packets; new
+	// packets are spooled until none remain.
+	down = false
+	sendPackets(t, cl, "f")
+	assert(t, strings.Join(packets, " "), "a:1|c b:1|c c:1|c")
+	sendPackets(t, cl, "g", "h")
+	assert(t, strings.Join(packets, " "), "a:1|c b:1|c c:1|c d:1|c e:1|c f:1|c g:1|c h:1|c")
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("spool not emptied: %v", err)
+	}
+	if got := cl.Stats().Dropped; got != 0 {
+		t.Fatalf("got %d dropped metrics, want 0", got)
+	}
+	cl.Close()
+}
+
+func TestSpoolFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	var packets []string
+	down := true
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	// Room for two packets of the form "x:1|c".
+	if err := cl.SetSpool(path, SpoolMaxSize(2*(spoolHeaderSize+5))); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	sendPackets(t, cl, "a", "b")
+	cl.Increment("c", 1, 1)
+	err := cl.Flush()
+	if err == nil || !strings.Contains(err.Error(), "is full") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := cl.Stats().Dropped; got != 1 {
+		t.Fatalf("got %d dropped metrics, want 1", got)
+	}
+
+	down = false
+	sendPackets(t, cl, "d")
+	assert(t, strings.Join(packets, " "), "a:1|c b:1|c d:1|c")
+}
+
+func TestSpoolPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	var packets []string
+	down := true
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	if err := cl.SetSpool(path); err != nil {
+		t.Fatal(err)
+	}
+	sendPackets(t, cl, "a", "b")
+	cl.Close()
+
+	down = false
+	cl = NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	if err := cl.SetSpool(path); err != nil {
+		t.Fatal(err)
+	}
+	sendPackets(t, cl, "c")
+	cl.Close()
+	assert(t, strings.Join(packets, " "), "a:1|c b:1|c c:1|c")
+}
+
+func TestSpoolCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	var packets []string
+	down := true
+	cl := NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	if err := cl.SetSpool(path); err != nil {
+		t.Fatal(err)
+	}
+	sendPackets(t, cl, "a")
+	cl.Close()
+
+	// Append a length that runs past the end of the file.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 'b'})
+	f.Close()
+
+	down = false
+	errc := make(chan error, 1)
+	cl = NewClientWriter(outageWriter{packetWriter{&packets}, &down}, 0)
+	cl.SetErrorFunc(func(err error) {
+		errc <- err
+	})
+	if err := cl.SetSpool(path); err != nil {
+		t.Fatal(err)
+	}
+	sendPackets(t, cl, "c")
+	cl.Close()
+	assert(t, strings.Join(packets, " "), "a:1|c c:1|c")
+	err = <-errc
+	if !strings.Contains(err.Error(), "invalid packet length") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestSetSpoolInvalid(t *testing.T) {
+	cl := NewClientWriter(packetWriter{new([]string)}, 0)
+	defer cl.Close()
+	path := filepath.Join(t.TempDir(), "spool")
+	if err := cl.SetSpool(path, SpoolMaxSize(0)); err == nil {
+		t.Fatal("expected error for zero size")
+	}
+	if err := cl.SetSpool(path, SpoolReplayLimit(0)); err == nil {
+		t.Fatal("expected error for zero replay limit")
+	}
+	if err := cl.SetSpool(filepath.Join(path, "nonexistent", "spool")); err == nil {
+		t.Fatal("expected error for bad path")
+	}
+}
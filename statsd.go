@@ -2,7 +2,8 @@
 Package statsd is a StatsD-compatible client for collecting operational
 in-app metrics.
 
-Supports counting, sampling, timing, gauges, sets and multi-metrics packet.
+Supports counting, sampling, timing, gauges, sets, histograms and
+multi-metrics packet.
 
 Example usage:
 
@@ -17,16 +18,46 @@ package statsd
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
+// Packet sizes for use with SetPacketSize.
 const (
-	defaultBufSize = 512
+	// PacketSizeSafe is small enough to be sent over UDP on any
+	// network without fragmentation. It is the default.
+	PacketSizeSafe = 512
+
+	// PacketSizeLAN fits in a single Ethernet frame with the
+	// standard 1500-byte MTU, allowing for IP and UDP headers
+	// and the overhead of some tunnels.
+	PacketSizeLAN = 1432
+
+	// PacketSizeJumbo fits in a single Ethernet jumbo frame
+	// with a 9000-byte MTU.
+	PacketSizeJumbo = 8932
+)
+
+const (
+	defaultBufSize = PacketSizeSafe
+)
+
+var (
+	errTooBig = errors.New("metric too big to fit in a packet")
+	errClosed = errors.New("client closed")
 )
 
 type client struct {
@@ -34,27 +65,381 @@ type client struct {
 
 	m    sync.Mutex
 	addr string
-	conn net.Conn
+	conn io.WriteCloser
 	buf  bytes.Buffer
+
+	// spare holds the buffer that was last flushed. When a
+	// packet is flushed, buf and spare are swapped, so that
+	// metrics can be appended to buf while the packet is
+	// written without m held. Flushes made while the packet in
+	// spare is still being written, as indicated by spareBusy,
+	// copy their packet instead.
+	spare     bytes.Buffer
+	spareBusy bool
+
+	// sendMu serializes writes to conn, which may be made
+	// without m held, and closing conn. It is always acquired
+	// with m held, so that packets are written in the order
+	// they are flushed.
+	sendMu sync.Mutex
+
+	// flushing holds the flush in progress by Flush or
+	// FlushContext, if any, while m is released for writing.
+	flushing *flushCall
+
+	// unlockWrites holds whether writeConn may release m while
+	// writing. It is only set by callers that do not depend on
+	// state guarded by m staying the same across a flush.
+	unlockWrites bool
+
+	// writer holds whether conn wraps a writer passed to
+	// NewClientWriter, in which case it is never redialed.
+	writer bool
+
+	// stream holds whether conn is a stream connection, such
+	// as TCP, which is redialed in the background when a
+	// write fails.
+	stream bool
+
+	// reconnect holds the state of the background redial
+	// of a stream connection, if one is in progress.
+	reconnect *reconnector
+
+	// minBackoff and maxBackoff hold the bounds of the delay
+	// between attempts to redial a stream connection. Zero
+	// values mean the defaults.
+	minBackoff, maxBackoff time.Duration
+
+	// dropped holds the number of metrics discarded
+	// because they could not be sent.
+	dropped atomic.Int64
+
+	// recorded, sampledOut, bytesWritten, packetsWritten and
+	// writeErrors hold the counts returned by stats. They are
+	// atomic so that they can be read without the lock.
+	recorded, sampledOut         atomic.Int64
+	bytesWritten, packetsWritten atomic.Int64
+	writeErrors                  atomic.Int64
+
+	// overflow holds the packets that could not be written,
+	// when the overflow policy is DropOldest.
+	overflow *overflow
+
+	// blockWhenFull holds whether an asynchronous client waits
+	// for room in its queue rather than dropping metrics. It is
+	// atomic because metrics are queued without the lock.
+	blockWhenFull atomic.Bool
+
+	// dropReportInterval holds the minimum interval between
+	// reports of dropped metrics to errorFunc. Zero means that
+	// they are not reported. dropReported holds the count of
+	// dropped metrics at the last report, made at dropReportTime.
+	dropReportInterval time.Duration
+	dropReported       int64
+	dropReportTime     time.Time
+
+	// unconnected holds whether UDP packets are sent with
+	// an unconnected socket. It is atomic because it is read
+	// when dialing without the lock.
+	unconnected atomic.Bool
+
+	// discard holds whether metrics are discarded, either because
+	// the client is disabled, as set by SetEnabled, or because it
+	// is the default client and no address has been set, as
+	// indicated by noAddr. It is atomic so that metrics can be
+	// discarded without acquiring the lock.
+	discard  atomic.Bool
+	disabled bool
+	noAddr   bool
+
+	// async holds the queue of metrics to be added to the
+	// buffer when the client is asynchronous.
+	async atomic.Pointer[asyncQueue]
+
+	// sendBufferSize holds the size of the operating system's
+	// send buffer requested for the connection. Zero means the
+	// system default.
+	sendBufferSize int
+
+	// writeTimeout holds the maximum time that a write
+	// may take. Zero means no limit.
+	writeTimeout time.Duration
+
+	// dial is used to connect to addr. If it is nil,
+	// net.Dial is used.
+	dial func(network, address string) (io.WriteCloser, error)
+
+	// tlsConfig holds the TLS configuration when the client
+	// was created by NewClientTLS. It is not changed after
+	// that, so it may be used without the lock.
+	tlsConfig *tls.Config
+
+	// hostname holds the host name set by WithHostname, if any.
+	// It is only used by New.
+	hostname string
+
+	// separator holds the separator set by WithSeparator, if any.
+	// It is only set by New, so it may be used without the lock.
+	separator string
+
+	// failover holds the failover state when the client
+	// was created by NewClientFailover.
+	failover *failover
+
+	// spool holds the file to which packets are written
+	// when they cannot be sent, if set.
+	spool *spool
+
+	// buffered holds the number of metrics in buf.
+	buffered int
+
+	// flushThreshold holds the number of buffered metrics
+	// that triggers a flush, or zero for no limit.
+	flushThreshold int
+
+	// flushFunc is called after each packet is written.
+	flushFunc func(bytes, metrics int)
+
+	// oversize holds the policy for metrics larger
+	// than the packet size.
+	oversize OversizePolicy
+
+	// tooBig and truncated hold the number of metrics
+	// discarded and shortened because they were larger
+	// than the packet size.
+	tooBig, truncated atomic.Int64
+
+	// pacer limits the rate at which packets are
+	// written, if set.
+	pacer *pacer
+
+	// prefix holds the prefix added to every bucket name.
+	prefix string
+
+	// header holds the line written at the start of each
+	// packet, without its newline, if any.
+	header []byte
+
+	// unbuffered holds whether each metric is
+	// written in its own packet as it is recorded.
+	unbuffered bool
+
+	// closed holds whether the client has been closed, after
+	// which metrics are discarded. closedReported holds whether
+	// that has been reported to the error function.
+	closed         bool
+	closedReported bool
+
+	// compression holds the gzip compression level for
+	// stream connections, or zero for no compression.
+	compression int
+
+	// batching holds whether flush adds packets to batch
+	// rather than writing them, so that they can be written
+	// together by flushBatch.
+	batching bool
+	batch    [][]byte
+
+	// remoteIP holds the IP address that conn sends to,
+	// if known.
+	remoteIP string
+
+	// lookupHost is used to resolve host names when
+	// a resolve interval is set. If it is nil,
+	// net.LookupHost is used.
+	lookupHost func(host string) ([]string, error)
+
+	// resolver holds the state of the background re-resolution
+	// started by setResolveInterval, if any.
+	resolver *resolver
+
+	// flusher holds the state of the background flushing
+	// started by setFlushInterval, if any. flushIntervalSet
+	// holds whether setFlushInterval has been called, in
+	// which case startDefaultFlusher does nothing.
+	flusher          *flusher
+	flushIntervalSet bool
+
+	// linger holds how long the first metric added to an empty
+	// buffer may wait before the buffer is flushed. Zero means
+	// that the buffer is only flushed when it is full or by
+	// Flush. lingerTimer fires after that time.
+	linger      time.Duration
+	lingerTimer ClockTimer
+
+	// clock provides the time and timers used by the client.
+	// Nil means the system clock. It is only set by New, before
+	// the client is used.
+	clock Clock
+
+	// rand holds the source used for sampling, as set by
+	// WithRandSource. Nil means the global math/rand source,
+	// which is randomly seeded. randMu guards it so that
+	// sampling does not acquire the lock.
+	rand   *rand.Rand
+	randMu sync.Mutex
+
+	// negativeGaugeReset holds whether negative gauge values
+	// are preceded by a line setting the gauge to zero.
+	negativeGaugeReset bool
+
+	// dropZeroCounts holds whether counter metrics
+	// with a zero count are discarded.
+	dropZeroCounts bool
+
+	// sizeUnit holds the unit in which sizes are sent.
+	// The zero value means Bytes. It is atomic so that it
+	// can be read without the lock.
+	sizeUnit atomic.Int64
+
+	// timers holds the timer aggregation state when
+	// timer aggregation is enabled.
+	timers *timerAggregator
+
+	// meters holds the meters that are stopped
+	// when the client is closed.
+	meters map[*Meter]struct{}
+
+	// errorFunc holds the function called with errors that
+	// happen in the background, if any. It is atomic so that
+	// errors can be reported without acquiring the lock.
+	errorFunc atomic.Pointer[func(error)]
+
+	// callbackPanics holds the number of panics recovered from
+	// the error and flush functions. flushFuncPanicked holds
+	// whether a panic in the flush function has been reported.
+	callbackPanics    atomic.Int64
+	flushFuncPanicked bool
+
+	// globalTags holds tags that are sent with every metric,
+	// before any tags specific to the metric, as set by
+	// setGlobalTags.
+	globalTags []string
+
+	// tags holds globalTags as they are sent, with any characters
+	// that cannot be sent in the tag format replaced.
+	tags []string
+
+	// tagFormat holds the format in which tags are sent.
+	tagFormat TagFormat
+
+	// strict holds whether metrics with stat names or tags that
+	// cannot be sent are rejected rather than fixed.
+	strict bool
+
+	// replacement holds the character used to replace characters
+	// that cannot be sent. The zero value means '_'.
+	replacement rune
+
+	// containerID holds the container ID sent with every
+	// metric in DogStatsD format, if any.
+	containerID string
+
+	// tagLimiter holds the tag value limiter, if any. It is
+	// accessed without holding the client mutex lock.
+	tagLimiter atomic.Pointer[tagLimiter]
 }
 
 func millisecond(d time.Duration) int {
 	return int(d.Seconds() * 1000)
 }
 
+// fractionalMillisecond returns d as a fractional number of milliseconds.
+func fractionalMillisecond(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 func newClient() *client {
 	return &client{
 		size: defaultBufSize,
 	}
 }
 
+// setErrorFunc sets the function to be called with errors
+// that happen in the background.
+func (c *client) setErrorFunc(f func(error)) {
+	if f == nil {
+		c.errorFunc.Store(nil)
+		return
+	}
+	c.errorFunc.Store(&f)
+}
+
+// reportError calls the error function, if any, with err
+// if it is not nil. Caller must not hold the client mutex lock,
+// as the error function may use the client.
+func (c *client) reportError(err error) {
+	if err == nil {
+		return
+	}
+	if f := c.errorFunc.Load(); f != nil {
+		c.callErrorFunc(*f, err)
+	}
+}
+
+// reportErrorAsync is like reportError but calls the error function in
+// a new goroutine. It should only be used for rare errors, as their
+// order is not preserved. Caller must hold the client mutex lock.
+func (c *client) reportErrorAsync(err error) {
+	if f := c.errorFunc.Load(); f != nil && err != nil {
+		go c.callErrorFunc(*f, err)
+	}
+}
+
 // setAddr connects the client to a new address, to which stats will be sent.
+// Buffered stats are first flushed to the old address, if any; an error
+// doing so is passed to the error function.
 func (c *client) setAddr(addr string) error {
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	if c.conn != nil && c.buf.Len() > 0 {
+		if err := c.flush(); err != nil {
+			c.reportErrorAsync(err)
+		}
+	}
 	c.addr = addr
-	return c.connect()
+	c.writer = false
+	c.stopFailover()
+	if err := c.connect(); err != nil {
+		return err
+	}
+	if c.noAddr {
+		c.noAddr = false
+		c.updateDiscard()
+	}
+	return nil
+}
+
+// getAddr returns the address set with setAddr, as it was given.
+func (c *client) getAddr() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.addr
+}
+
+// getRemoteAddr returns the address that the client connection
+// sends to. See RemoteAddr for details.
+func (c *client) getRemoteAddr() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	addr, _ := c.remoteAddr()
+	return addr
+}
+
+// remoteAddr returns the address that the client connection sends
+// to and its network, or empty strings if there is no connection
+// or it has no remote address. Caller must hold the client mutex
+// lock.
+func (c *client) remoteAddr() (addr, network string) {
+	if conn, ok := socketConn(c.conn).(interface{ RemoteAddr() net.Addr }); ok {
+		if addr := conn.RemoteAddr(); addr != nil {
+			return addr.String(), addr.Network()
+		}
+	}
+	return "", ""
 }
 
 // connect dials the currently configured address after closing any prior
@@ -63,105 +448,1175 @@ func (c *client) setAddr(addr string) error {
 func (c *client) connect() error {
 	var err error
 
-	if c.conn != nil {
-		defer c.conn.Close()
-		c.conn = nil
-	}
+	c.closeConn()
 
 	if c.addr == "" {
 		return errors.New("address not set")
 	}
 
-	conn, err := net.Dial("udp", c.addr)
+	c.stopReconnect()
+	c.remoteIP = ""
+	network, address, err := parseAddr(c.addr)
 	if err != nil {
 		return err
 	}
+	c.stream = strings.HasPrefix(network, "tcp")
+	conn, err := c.dialNetwork(network, address)
+	if err != nil {
+		return err
+	}
+	c.setConn(conn)
+	return nil
+}
+
+// setConn makes conn the client connection, applying any connection
+// settings to it. Caller must hold the client mutex lock.
+func (c *client) setConn(conn io.WriteCloser) {
+	if c.compression != 0 && c.stream {
+		conn = newGzipConn(conn, c.compression)
+	}
 	c.conn = conn
+	c.remoteIP = remoteIP(conn)
+	if c.sendBufferSize > 0 {
+		if conn, ok := socketConn(conn).(interface{ SetWriteBuffer(int) error }); ok {
+			if err := conn.SetWriteBuffer(c.sendBufferSize); err != nil {
+				c.reportErrorAsync(fmt.Errorf("cannot set send buffer size: %v", err))
+			}
+		}
+	}
+}
+
+// dialAddr connects to addr. It does not use any state
+// guarded by the client mutex lock.
+func (c *client) dialAddr(addr string) (io.WriteCloser, error) {
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.dialNetwork(network, address)
+}
+
+// dialNetwork connects to the given address on the named network.
+// It does not use any state guarded by the client mutex lock.
+func (c *client) dialNetwork(network, address string) (io.WriteCloser, error) {
+	if c.tlsConfig != nil {
+		return c.dialTLS(network, address)
+	}
+	if c.dial != nil {
+		return c.dial(network, address)
+	}
+	lookupHost := c.lookupHost
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
+	dial := netDial
+	if c.unconnected.Load() {
+		dial = dialUnconnected
+	}
+	return dialHost(network, address, lookupHost, dial)
+}
+
+// netDial is like net.Dial but returns an io.WriteCloser.
+func netDial(network, address string) (io.WriteCloser, error) {
+	return net.Dial(network, address)
+}
+
+// addrSchemes maps the schemes accepted in addresses
+// to the networks that they are dialed with.
+var addrSchemes = map[string]string{
+	"udp":      "udp",
+	"udp4":     "udp4",
+	"udp6":     "udp6",
+	"tcp":      "tcp",
+	"tcp4":     "tcp4",
+	"tcp6":     "tcp6",
+	"unixgram": "unixgram",
+	"unix":     "unixgram",
+}
+
+// parseAddr returns the network and address to dial for addr, which
+// is interpreted as described for SetAddr.
+func parseAddr(addr string) (network, address string, err error) {
+	scheme, address, ok := strings.Cut(addr, "://")
+	if !ok {
+		if strings.HasPrefix(addr, "/") || strings.HasPrefix(addr, ".") {
+			return "unixgram", addr, nil
+		}
+		scheme, address = "udp", addr
+	}
+	network, ok = addrSchemes[scheme]
+	if !ok {
+		return "", "", fmt.Errorf("unknown scheme %q in address %q", scheme, addr)
+	}
+	if address == "" {
+		return "", "", fmt.Errorf("no address after scheme in %q", addr)
+	}
+	if network != "unixgram" && strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+		return "", "", fmt.Errorf("IPv6 address in %q must be enclosed in brackets, as in [::1]:8125", addr)
+	}
+	return network, address, nil
+}
+
+// setWriteTimeout sets the maximum time that writing a packet
+// may take. See SetWriteTimeout for details.
+func (c *client) setWriteTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("negative write timeout %v", timeout)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.writeTimeout = timeout
+	return nil
+}
+
+// setPacketSize sets the maximum size of a packet.
+// See SetPacketSize for details.
+func (c *client) setPacketSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid packet size %d", size)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if size <= c.headerLen() {
+		return fmt.Errorf("packet size %d leaves no room after the packet header", size)
+	}
+	var err error
+	if c.buf.Len() > size {
+		err = c.flush()
+	}
+	c.size = size
+	return err
+}
+
+// getPacketSize returns the maximum size of a packet.
+func (c *client) getPacketSize() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.size
+}
+
+// setFlushFunc sets the function called after each packet is
+// written. See SetFlushFunc for details.
+func (c *client) setFlushFunc(f func(bytes, metrics int)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.flushFunc = f
+}
+
+// packetSent counts packet, which has been written, and calls the
+// flush function, if any. Caller must hold the client mutex lock.
+func (c *client) packetSent(packet []byte) {
+	c.packetsWritten.Add(1)
+	c.bytesWritten.Add(int64(len(packet)))
+	if c.flushFunc != nil {
+		c.callFlushFunc(len(packet), c.packetMetrics(packet))
+	}
+}
+
+// setPacketHeader sets the line that begins every packet.
+// See SetPacketHeader for details.
+func (c *client) setPacketHeader(header []byte) error {
+	if bytes.IndexByte(header, '\n') >= 0 {
+		return errors.New("packet header contains a newline")
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if len(header) > 0 && len(header)+1 >= c.size {
+		return fmt.Errorf("packet header of %d bytes leaves no room in packets of %d bytes", len(header), c.size)
+	}
+	// Buffered metrics follow the old header.
+	var err error
+	if c.buf.Len() > 0 {
+		err = c.flush()
+	}
+	c.header = bytes.Clone(header)
+	return err
+}
+
+// headerLen returns the number of bytes taken by the packet
+// header, including its newline. Caller must hold the client
+// mutex lock.
+func (c *client) headerLen() int {
+	if len(c.header) == 0 {
+		return 0
+	}
+	return len(c.header) + 1
+}
+
+// setFlushThreshold sets the number of buffered metrics that
+// triggers a flush. See SetFlushThreshold for details.
+func (c *client) setFlushThreshold(n int) error {
+	if n < 0 {
+		return fmt.Errorf("negative flush threshold %d", n)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.flushThreshold = n
+	if n > 0 && c.buffered >= n {
+		return c.flush()
+	}
+	return nil
+}
+
+// setUnbuffered sets whether each metric is written as soon as it
+// is recorded. See SetUnbuffered for details.
+func (c *client) setUnbuffered(unbuffered bool) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.unbuffered = unbuffered
+	if unbuffered && c.buf.Len() > 0 {
+		return c.flush()
+	}
+	return nil
+}
+
+// setPrefix sets the prefix added to every bucket name.
+// See SetPrefix for details.
+func (c *client) setPrefix(prefix string) error {
+	if hasUnsafeStatChar(prefix) {
+		return fmt.Errorf("invalid character in prefix %q", prefix)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.prefix = c.sepPrefix(prefix)
+	return nil
+}
+
+// getPrefix returns the prefix set with setPrefix.
+func (c *client) getPrefix() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.prefix
+}
+
+// setEnabled enables or disables sending metrics.
+// See SetEnabled for details.
+func (c *client) setEnabled(enabled bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.disabled = !enabled
+	c.updateDiscard()
+}
+
+// getEnabled reports whether the client is enabled,
+// as set by setEnabled.
+func (c *client) getEnabled() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return !c.disabled
+}
+
+// updateDiscard sets whether metrics are discarded, discarding any
+// buffered metrics if so. Caller must hold the client mutex lock.
+func (c *client) updateDiscard() {
+	discard := c.disabled || c.noAddr
+	c.discard.Store(discard)
+	if discard {
+		c.buf.Reset()
+		c.buffered = 0
+		c.stopLinger()
+	}
+}
+
+// setGlobalTags sets the tags sent with every metric.
+// See SetGlobalTags for details.
+func (c *client) setGlobalTags(tags []string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if len(tags) > 0 {
+		tags = append([]string(nil), tags...)
+	} else {
+		tags = nil
+	}
+	return c.setTagConfig(tags, c.tagFormat, c.strict, c.replacementChar())
+}
+
+// getGlobalTags returns a copy of the tags set with setGlobalTags.
+func (c *client) getGlobalTags() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]string(nil), c.globalTags...)
+}
+
+// getTagFormat returns the format set with setTagFormat.
+func (c *client) getTagFormat() TagFormat {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.tagFormat
+}
+
+// setTagFormat sets the format in which tags are sent.
+// See SetTagFormat for details.
+func (c *client) setTagFormat(format TagFormat) error {
+	if format < TagFormatDogStatsD || format > TagFormatSignalFx {
+		return fmt.Errorf("unknown tag format %d", format)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if err := c.setTagConfig(c.globalTags, format, c.strict, c.replacementChar()); err != nil {
+		return fmt.Errorf("cannot use global tags with new format: %v", err)
+	}
+	return nil
+}
+
+// setDropZeroCounts sets whether counter metrics with
+// a zero count are discarded.
+func (c *client) setDropZeroCounts(drop bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.dropZeroCounts = drop
+}
+
+func (c *client) increment(stat string, count int, rate float64, tags ...string) error {
+	return c.sendCounter(count == 0, Metric{Stat: stat, Value: strconv.Itoa(count), Kind: "c", Rate: rate, Tags: tags})
+}
+
+func (c *client) increment64(stat string, count int64, rate float64, tags ...string) error {
+	return c.sendCounter(count == 0, Metric{Stat: stat, Value: strconv.FormatInt(count, 10), Kind: "c", Rate: rate, Tags: tags})
+}
+
+func (c *client) incrementFloat(stat string, delta float64, rate float64, tags ...string) error {
+	return c.sendCounter(delta == 0, Metric{Stat: stat, Value: formatFloat(delta), Kind: "c", Rate: rate, Tags: tags})
+}
+
+// incrementBytes is like increment but takes the bucket name as
+// prefix followed by stat, so that neither needs to be copied.
+func (c *client) incrementBytes(prefix string, stat []byte, count int, rate float64, tags ...string) error {
+	if c.discard.Load() {
+		return nil
+	}
+	if len(tags) > 0 || hasUnsafeStatChar(prefix) || hasUnsafeStatChar(stat) || c.async.Load() != nil {
+		// Take the slow path to check the name and tags or
+		// queue the metric.
+		return c.increment(prefix+string(stat), count, rate, tags...)
+	}
+	if !c.sample(rate) {
+		return nil
+	}
+	m := Metric{Value: strconv.Itoa(count), Kind: "c", Rate: rate}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var buf [128]byte
+	return c.appendCounter(count == 0, appendClientMetric(c, buf[:0], prefix, stat, &m))
+}
+
+// sendCounter is like send but is used for counters. The metric
+// is discarded if it records a zero count and dropZeroCounts is set.
+func (c *client) sendCounter(zero bool, m Metric) error {
+	return c.record(metricRecord{op: opCounter, m: m, flag: zero})
+}
+
+// appendCounter adds the formatted counter metric to the buffer
+// unless it records a zero count and dropZeroCounts is set.
+// Caller must hold the client mutex lock.
+func (c *client) appendCounter(zero bool, metric []byte) error {
+	if zero && c.dropZeroCounts {
+		return nil
+	}
+	return c.append(metric)
+}
+
+func (c *client) decrement(stat string, count int, rate float64, tags ...string) error {
+	return c.increment(stat, -count, rate, tags...)
+}
+
+func (c *client) duration(stat string, duration time.Duration, rate float64, tags ...string) error {
+	return c.timing(stat, millisecond(duration), rate, tags...)
+}
+
+// durationBytes is like duration but takes the bucket name as
+// prefix followed by stat, so that neither needs to be copied.
+func (c *client) durationBytes(prefix string, stat []byte, duration time.Duration, rate float64, tags ...string) error {
+	if c.discard.Load() {
+		return nil
+	}
+	ms := millisecond(duration)
+	if ms < 0 || len(tags) > 0 || hasUnsafeStatChar(prefix) || hasUnsafeStatChar(stat) || c.async.Load() != nil {
+		// Take the slow path to report the error, check the name
+		// and tags or queue the metric.
+		return c.duration(prefix+string(stat), duration, rate, tags...)
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.timers != nil {
+		// Record the value for aggregation.
+		return c.appendRecord(&metricRecord{
+			op:    opTimer,
+			m:     Metric{Stat: prefix + string(stat), Kind: "ms", Rate: rate},
+			delta: float64(ms),
+		})
+	}
+	if !c.sample(rate) {
+		return nil
+	}
+	m := Metric{Value: strconv.Itoa(ms), Kind: "ms", Rate: rate}
+	var buf [128]byte
+	return c.append(appendClientMetric(c, buf[:0], prefix, stat, &m))
+}
+
+func (c *client) durationSince(stat string, start time.Time, rate float64, tags ...string) error {
+	return c.duration(stat, c.since(start), rate, tags...)
+}
+
+// durationN records a single duration line standing for n observations
+// of duration d. See DurationN for details.
+func (c *client) durationN(stat string, duration time.Duration, n int, rate float64, tags ...string) error {
+	if n < 0 {
+		return fmt.Errorf("negative observation count %d", n)
+	}
+	if n == 0 {
+		return nil
+	}
+	ms := millisecond(duration)
+	if ms < 0 {
+		return fmt.Errorf("invalid timing value %d", ms)
+	}
+	return c.record(metricRecord{
+		op:    opTimer,
+		m:     Metric{Stat: stat, Value: strconv.Itoa(ms), Kind: "ms", Rate: rate, Tags: tags},
+		delta: float64(ms),
+		n:     n,
+	})
+}
+
+// durations records all the given durations for stat while holding the
+// client lock only once. Sampling is applied to each duration separately.
+func (c *client) durations(stat string, durations []time.Duration, rate float64, tags ...string) error {
+	for _, d := range durations {
+		if ms := millisecond(d); ms < 0 {
+			return fmt.Errorf("invalid timing value %d", ms)
+		}
+	}
+	if c.discard.Load() {
+		return nil
+	}
+	tags = c.limitTags(tags)
+	if q := c.async.Load(); q != nil {
+		for _, d := range durations {
+			ms := millisecond(d)
+			c.enqueue(q, metricRecord{
+				op:    opTimer,
+				m:     Metric{Stat: stat, Value: strconv.Itoa(ms), Kind: "ms", Rate: rate, Tags: tags},
+				delta: float64(ms),
+			})
+		}
+		return nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	m := Metric{Stat: stat, Kind: "ms", Rate: rate, Tags: tags}
+	if err := c.sanitize(&m); err != nil {
+		return err
+	}
+	if c.timers != nil {
+		for _, d := range durations {
+			c.timers.add(m.Stat, m.Tags, float64(millisecond(d)), 1)
+		}
+		return nil
+	}
+
+	var buf []byte
+	for _, d := range durations {
+		if !c.sample(rate) {
+			continue
+		}
+		m.Value = strconv.Itoa(millisecond(d))
+		buf = c.appendTo(buf[:0], &m)
+		err := c.append(buf)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *client) increment(stat string, count int, rate float64) error {
-	return c.send(stat, rate, "%d|c", count)
+func (c *client) durationFloat(stat string, duration time.Duration, rate float64, tags ...string) error {
+	return c.timingFloat(stat, fractionalMillisecond(duration), rate, tags...)
 }
 
-func (c *client) decrement(stat string, count int, rate float64) error {
-	return c.increment(stat, -count, rate)
+func (c *client) timing(stat string, delta int, rate float64, tags ...string) error {
+	return c.timingFloat(stat, float64(delta), rate, tags...)
 }
 
-func (c *client) duration(stat string, duration time.Duration, rate float64) error {
-	return c.send(stat, rate, "%d|ms", millisecond(duration))
+func (c *client) timingFloat(stat string, delta float64, rate float64, tags ...string) error {
+	if delta < 0 || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return fmt.Errorf("invalid timing value %v", delta)
+	}
+	return c.record(metricRecord{
+		op:    opTimer,
+		m:     Metric{Stat: stat, Value: formatFloat(delta), Kind: "ms", Rate: rate, Tags: tags},
+		delta: delta,
+	})
 }
 
-func (c *client) timing(stat string, delta int, rate float64) error {
-	return c.send(stat, rate, "%d|ms", delta)
+func (c *client) time(stat string, rate float64, f func(), tags ...string) error {
+	_, err := c.timeDuration(stat, rate, f, tags...)
+	return err
 }
 
-func (c *client) time(stat string, rate float64, f func()) error {
-	ts := time.Now()
+func (c *client) timeDuration(stat string, rate float64, f func(), tags ...string) (time.Duration, error) {
+	ts := c.now()
 	f()
-	return c.duration(stat, time.Since(ts), rate)
+	d := c.since(ts)
+	return d, c.duration(stat, d, rate, tags...)
+}
+
+func (c *client) timeErr(stat string, rate float64, f func() error, tags ...string) error {
+	var ferr error
+	_, err := c.timeDuration(stat, rate, func() {
+		ferr = f()
+	}, tags...)
+	if ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// setNegativeGaugeReset sets whether negative gauge values are sent
+// after a line setting the gauge to zero.
+func (c *client) setNegativeGaugeReset(reset bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.negativeGaugeReset = reset
+}
+
+func (c *client) gauge(stat string, value int, rate float64, tags ...string) error {
+	return c.sendGauge(value < 0, Metric{Stat: stat, Value: strconv.Itoa(value), Kind: "g", Rate: rate, Tags: tags})
+}
+
+func (c *client) gauge64(stat string, value int64, rate float64, tags ...string) error {
+	return c.sendGauge(value < 0, Metric{Stat: stat, Value: strconv.FormatInt(value, 10), Kind: "g", Rate: rate, Tags: tags})
+}
+
+func (c *client) gaugeFloat64(stat string, value float64, rate float64, tags ...string) error {
+	return c.sendGauge(value < 0, Metric{Stat: stat, Value: formatFloat(value), Kind: "g", Rate: rate, Tags: tags})
+}
+
+func (c *client) gaugeAt(stat string, value int, t time.Time, tags ...string) error {
+	return c.sendGauge(value < 0, Metric{Stat: stat, Value: strconv.Itoa(value), Kind: "g", Rate: 1, Timestamp: t, Tags: tags})
+}
+
+// Number is the set of numeric types accepted by GaugeValue.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+func gaugeValue[T Number](c *client, stat string, value T, rate float64, tags ...string) error {
+	return c.sendGauge(value < 0, Metric{Stat: stat, Value: formatNumber(value), Kind: "g", Rate: rate, Tags: tags})
+}
+
+// formatNumber formats v in the same way as the type-specific
+// methods would: integers in decimal and floating point values
+// with formatFloat.
+func formatNumber[T Number](v T) string {
+	half := 0.5
+	if T(half) == 0 {
+		// T is an integer type.
+		if v < 0 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatUint(uint64(v), 10)
+	}
+	if unsafe.Sizeof(v) == 4 {
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return formatFloat(float64(v))
+}
+
+// gaugeBytes is like gauge but takes the bucket name as
+// prefix followed by stat, so that neither needs to be copied.
+func (c *client) gaugeBytes(prefix string, stat []byte, value int, rate float64, tags ...string) error {
+	if c.discard.Load() {
+		return nil
+	}
+	if len(tags) > 0 || hasUnsafeStatChar(prefix) || hasUnsafeStatChar(stat) || c.async.Load() != nil {
+		// Take the slow path to check the name and tags or
+		// queue the metric.
+		return c.gauge(prefix+string(stat), value, rate, tags...)
+	}
+	if !c.sample(rate) {
+		return nil
+	}
+	m := Metric{Value: strconv.Itoa(value), Kind: "g", Rate: rate}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var reset []byte
+	if value < 0 && c.negativeGaugeReset {
+		r := m
+		r.Value = "0"
+		reset = appendClientMetric(c, nil, prefix, stat, &r)
+	}
+	var buf [128]byte
+	return c.appendGauge(reset, appendClientMetric(c, buf[:0], prefix, stat, &m))
+}
+
+func (c *client) gaugeBool(stat string, value bool, rate float64, tags ...string) error {
+	n := 0
+	if value {
+		n = 1
+	}
+	return c.gauge(stat, n, rate, tags...)
+}
+
+// sendGauge is like send but is used for absolute gauge values. Because
+// statsd servers treat a leading minus sign as a decrement, when
+// negativeGaugeReset is set a negative value is preceded by a line
+// setting the gauge to zero. Both lines are always sent in the same
+// packet.
+func (c *client) sendGauge(negative bool, m Metric) error {
+	return c.record(metricRecord{op: opGauge, m: m, flag: negative})
+}
+
+// appendGauge adds the formatted gauge metric to the buffer. If
+// reset is non-nil, the metric is preceded by reset, which should
+// set the gauge to zero. Caller must hold the client mutex lock.
+func (c *client) appendGauge(reset, metric []byte) error {
+	if reset != nil {
+		buf := make([]byte, 0, len(reset)+len("\n")+len(metric))
+		buf = append(buf, reset...)
+		buf = append(buf, '\n')
+		metric = append(buf, metric...)
+	}
+	return c.append(metric)
+}
+
+func (c *client) incrementGauge(stat string, value int, rate float64, tags ...string) error {
+	return c.send(Metric{Stat: stat, Value: strconv.Itoa(value), Kind: "g", Rate: rate, Sign: SignRequired, Tags: tags})
+}
+
+func (c *client) decrementGauge(stat string, value int, rate float64, tags ...string) error {
+	return c.send(Metric{Stat: stat, Value: strconv.Itoa(-value), Kind: "g", Rate: rate, Sign: SignRequired, Tags: tags})
+}
+
+func (c *client) unique(stat string, value int, rate float64, tags ...string) error {
+	return c.send(Metric{Stat: stat, Value: strconv.Itoa(value), Kind: "s", Rate: rate, Tags: tags})
+}
+
+func (c *client) uniqueString(stat string, value string, rate float64, tags ...string) error {
+	if strings.ContainsAny(value, "|:\n") {
+		return fmt.Errorf("invalid set value %q", value)
+	}
+	return c.send(Metric{Stat: stat, Value: value, Kind: "s", Rate: rate, Tags: tags})
+}
+
+func (c *client) uniqueValue(stat string, value interface{}, rate float64, tags ...string) error {
+	switch value := value.(type) {
+	case int:
+		return c.unique(stat, value, rate, tags...)
+	case int64:
+		return c.send(Metric{Stat: stat, Value: strconv.FormatInt(value, 10), Kind: "s", Rate: rate, Tags: tags})
+	case string:
+		return c.uniqueString(stat, value, rate, tags...)
+	case fmt.Stringer:
+		return c.uniqueString(stat, value.String(), rate, tags...)
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return c.send(Metric{Stat: stat, Value: strconv.FormatInt(v.Int(), 10), Kind: "s", Rate: rate, Tags: tags})
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return c.send(Metric{Stat: stat, Value: strconv.FormatUint(v.Uint(), 10), Kind: "s", Rate: rate, Tags: tags})
+	case reflect.String:
+		return c.uniqueString(stat, v.String(), rate, tags...)
+	}
+	return fmt.Errorf("unsupported set value type %T", value)
+}
+
+func (c *client) histogram(stat string, value float64, rate float64, tags ...string) error {
+	return c.send(Metric{Stat: stat, Value: formatFloat(value), Kind: "h", Rate: rate, Tags: tags})
+}
+
+// SizeUnit represents the unit in which sizes are sent.
+type SizeUnit int64
+
+const (
+	Bytes SizeUnit = 1
+	KiB   SizeUnit = 1 << 10
+	MiB   SizeUnit = 1 << 20
+	GiB   SizeUnit = 1 << 30
+)
+
+// setSizeUnit sets the unit in which sizes are sent.
+func (c *client) setSizeUnit(unit SizeUnit) error {
+	if unit <= 0 {
+		return fmt.Errorf("invalid size unit %d", unit)
+	}
+	c.sizeUnit.Store(int64(unit))
+	return nil
+}
+
+func (c *client) byteSize(stat string, bytes int64, rate float64, tags ...string) error {
+	if bytes < 0 {
+		return fmt.Errorf("negative size %d", bytes)
+	}
+	unit := c.sizeUnit.Load()
+	if unit == 0 {
+		unit = int64(Bytes)
+	}
+	// Round to the nearest unit, with halves rounded up.
+	n := bytes/unit + (bytes%unit*2)/unit
+	return c.send(Metric{Stat: stat, Value: strconv.FormatInt(n, 10), Kind: "h", Rate: rate, Tags: tags})
 }
 
-func (c *client) gauge(stat string, value int, rate float64) error {
-	return c.send(stat, rate, "%d|g", value)
+func (c *client) distribution(stat string, value float64, rate float64, tags ...string) error {
+	return c.send(Metric{Stat: stat, Value: formatFloat(value), Kind: "d", Rate: rate, Tags: tags})
 }
 
-func (c *client) incrementGauge(stat string, value int, rate float64) error {
-	return c.send(stat, rate, "+%d|g", value)
+func (c *client) keyValue(stat string, value float64, tags ...string) error {
+	return c.send(Metric{Stat: stat, Value: formatFloat(value), Kind: "kv", Rate: 1, Tags: tags})
 }
 
-func (c *client) decrementGauge(stat string, value int, rate float64) error {
-	return c.send(stat, rate, "-%d|g", value)
+func (c *client) sendRaw(stat string, value string, kind string, rate float64, tags ...string) error {
+	if kind == "" || strings.ContainsAny(kind, "|\n") {
+		return fmt.Errorf("invalid metric kind %q", kind)
+	}
+	if strings.ContainsAny(value, "|\n") {
+		return fmt.Errorf("invalid metric value %q", value)
+	}
+	return c.send(Metric{Stat: stat, Value: value, Kind: kind, Rate: rate, Tags: tags})
 }
 
-func (c *client) unique(stat string, value int, rate float64) error {
-	return c.send(stat, rate, "%d|s", value)
+// formatFloat formats f using the fewest digits necessary to represent it
+// exactly, without an exponent.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
 }
 
 // flush writes all buffered stats messages to the client connection. Caller
 // must hold the client mutex lock.
 func (c *client) flush() error {
-	defer c.buf.Reset()
+	c.buffered = 0
+	c.stopLinger()
 
+	if c.closed {
+		c.buf.Reset()
+		return nil
+	}
+	if c.stream {
+		// Terminate the last line so that it is not joined
+		// with the first line of the next write.
+		c.buf.WriteByte('\n')
+	}
+	if c.batching {
+		c.addToBatch(c.buf.Bytes())
+		c.buf.Reset()
+		return nil
+	}
+	if c.spareBusy {
+		// The other buffer is still being written, so copy
+		// the packet out of the buffer, which other goroutines
+		// may append to if the lock is released while writing.
+		p := packetPool.Get().(*[]byte)
+		packet := append((*p)[:0], c.buf.Bytes()...)
+		c.buf.Reset()
+		err := c.sendPacket(packet)
+		*p = packet
+		packetPool.Put(p)
+		return err
+	}
+	c.buf, c.spare = c.spare, c.buf
+	c.buf.Reset()
+	c.spareBusy = true
+	err := c.sendPacket(c.spare.Bytes())
+	c.spareBusy = false
+	return err
+}
+
+// packetPool holds buffers for packets flushed while
+// the spare buffer is in use.
+var packetPool = sync.Pool{
+	New: func() any {
+		return new([]byte)
+	},
+}
+
+// sendPacket writes packet to the client connection, or to the spool
+// if there is one, and records the result for failover. A packet that
+// cannot be written is discarded or kept according to the overflow
+// policy. Caller must hold the client mutex lock.
+func (c *client) sendPacket(packet []byte) error {
+	var err error
+	switch {
+	case c.spool != nil:
+		err = c.spool.write(c, packet)
+	case c.overflow != nil:
+		err = c.overflow.write(c, packet)
+	default:
+		err = c.write(packet)
+		if err != nil {
+			c.countDropped(packet)
+		}
+	}
+	if c.failover != nil {
+		c.failover.record(c, err)
+	}
+	c.reportDropped()
+	return err
+}
+
+// write writes a packet to the client connection, connecting first if
+// needed, and reconnecting and retrying once if the write fails.
+// Caller must hold the client mutex lock.
+func (c *client) write(packet []byte) error {
+	if c.reconnect != nil {
+		return errReconnecting
+	}
 	if c.conn == nil {
+		if c.writer {
+			return errClosed
+		}
+		if c.stream {
+			c.startReconnect()
+			return errReconnecting
+		}
 		err := c.connect()
 		if err != nil {
 			return err
 		}
 	}
 
-	_, err := c.conn.Write(c.buf.Bytes())
-	if err != nil {
-		// Try to reconnect and retry
+	if c.pacer != nil {
+		c.pacer.wait()
+	}
+	conn := c.conn
+	err := c.writeConn(packet)
+	if err != nil && c.conn != conn {
+		// The connection was replaced or closed while
+		// the lock was released for writing.
+		return err
+	}
+	if err != nil && c.stream {
+		c.startReconnect()
+		return err
+	}
+	if err != nil && !c.writer && !errors.Is(err, os.ErrDeadlineExceeded) {
+		// Try to reconnect and retry. A timed out write is not
+		// retried, so that callers wait no longer than the
+		// write timeout.
 		err = c.connect()
 		if err != nil {
 			return err
 		}
-		_, err = c.conn.Write(c.buf.Bytes())
+		err = c.writeConn(packet)
+	}
+	if err == nil {
+		c.packetSent(packet)
+	} else {
+		c.writeErrors.Add(1)
+	}
+	return err
+}
+
+// writeConn writes packet to the client connection, setting a
+// deadline first if there is a write timeout and the connection
+// supports deadlines. Caller must hold the client mutex lock.
+func (c *client) writeConn(packet []byte) error {
+	c.setWriteDeadline()
+	conn := c.conn
+	c.sendMu.Lock()
+	if !c.unlockWrites || c.spool != nil || c.overflow != nil {
+		_, err := conn.Write(packet)
+		c.sendMu.Unlock()
 		return err
 	}
+	// Release the client lock while writing, so that a slow
+	// connection does not hold up goroutines recording metrics.
+	// sendMu must be released before relocking, as other
+	// goroutines acquire it with the client lock held.
+	c.unlockWrites = false
+	c.m.Unlock()
+	_, err := conn.Write(packet)
+	c.sendMu.Unlock()
+	c.m.Lock()
+	c.unlockWrites = true
+	return err
+}
 
-	return nil
+// setWriteDeadline sets a deadline for writes to the client connection
+// if there is a write timeout and the connection supports deadlines.
+// Caller must hold the client mutex lock.
+func (c *client) setWriteDeadline() {
+	if c.writeTimeout > 0 {
+		setWriteDeadline(c.conn, time.Now().Add(c.writeTimeout))
+	}
 }
 
-func (c *client) send(stat string, rate float64, format string, args ...interface{}) error {
-	if rate < 1 {
-		if rand.Float64() < rate {
-			format = fmt.Sprintf("%s|@%g", format, rate)
-		} else {
-			return nil
-		}
+// setWriteDeadline sets the write deadline of conn to t, reporting
+// whether conn supports deadlines.
+func setWriteDeadline(conn io.WriteCloser, t time.Time) bool {
+	if conn, ok := conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		conn.SetWriteDeadline(t)
+		return true
 	}
+	return false
+}
+
+// discardClosed reports, the first time that it is called, that
+// metrics are discarded because the client is closed. Caller must
+// hold the client mutex lock.
+func (c *client) discardClosed() {
+	if !c.closedReported {
+		c.closedReported = true
+		c.reportErrorAsync(fmt.Errorf("metrics discarded: %w", errClosed))
+	}
+}
 
-	format = fmt.Sprintf("%s:%s", stat, format)
+// close flushes any buffered stats and closes the client connection.
+func (c *client) close() error {
+	c.stopMeters()
+	c.stopAsync()
 
 	c.m.Lock()
 	defer c.m.Unlock()
 
+	if c.closed {
+		return nil
+	}
+	// Aggregated timer values are sent with the final flush.
+	terr := c.stopTimers()
 	var err error
+	if c.buf.Len() > 0 {
+		err = c.flush()
+	}
+	if err == nil {
+		err = terr
+	}
+	c.closed = true
+	c.dropOverflow()
+	c.stopFailover()
+	c.stopResolver()
+	c.stopFlusher()
+	c.stopLinger()
+	c.stopReconnect()
+	c.closeSpool()
+	if cerr := c.closeConn(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// closeConn closes the client connection, if there is one, after
+// waiting for any write to it made without the lock held to finish.
+// Caller must hold the client mutex lock.
+func (c *client) closeConn() error {
+	if c.conn == nil {
+		return nil
+	}
+	c.sendMu.Lock()
+	err := c.conn.Close()
+	c.sendMu.Unlock()
+	c.conn = nil
+	return err
+}
 
-	// Flush data if we have reach the buffer limit
-	if (c.size - c.buf.Len()) < len(format) {
+// send samples m according to its rate and adds it to the buffer.
+func (c *client) send(m Metric) error {
+	return c.record(metricRecord{op: opSend, m: m})
+}
+
+// metricOp specifies how a metricRecord is added to the buffer.
+type metricOp uint8
+
+const (
+	// opSend adds the metric as is.
+	opSend metricOp = iota
+
+	// opCounter adds a counter metric; see sendCounter.
+	opCounter
+
+	// opGauge adds an absolute gauge metric; see sendGauge.
+	opGauge
+
+	// opTimer adds a timer metric, or aggregates it if timer
+	// aggregation is enabled.
+	opTimer
+
+	// opSync does not add a metric; it is used to wait until
+	// the asynchronous queue has been processed.
+	opSync
+)
+
+// metricRecord holds a metric to be added to the buffer.
+type metricRecord struct {
+	op metricOp
+	m  Metric
+
+	// flag holds whether a counter records a zero count
+	// or a gauge records a negative value.
+	flag bool
+
+	// delta holds the value of a timer.
+	delta float64
+
+	// n holds the number of observations that a timer line
+	// stands for, if more than one.
+	n int
+
+	// done is closed when an opSync record is processed.
+	done chan struct{}
+}
+
+// record adds the metric held in r to the buffer, or queues it to be
+// added when the client is asynchronous.
+func (c *client) record(r metricRecord) error {
+	if c.discard.Load() {
+		return nil
+	}
+	r.m.Tags = c.limitTags(r.m.Tags)
+	if q := c.async.Load(); q != nil {
+		return c.enqueue(q, r)
+	}
+
+	c.m.Lock()
+	c.unlockWrites = true
+	err := c.appendRecord(&r)
+	c.unlockWrites = false
+	c.m.Unlock()
+	return err
+}
+
+// appendRecord adds the metric held in r to the buffer after
+// sanitizing and sampling it. Caller must hold the client mutex
+// lock.
+func (c *client) appendRecord(r *metricRecord) error {
+	if c.closed {
+		c.discardClosed()
+		return nil
+	}
+	m := &r.m
+	if err := c.sanitize(m); err != nil {
+		return err
+	}
+	if r.op == opTimer && c.timers != nil {
+		c.timers.add(m.Stat, m.Tags, r.delta, max(r.n, 1))
+		return nil
+	}
+	if !c.sample(m.Rate) {
+		return nil
+	}
+	if r.n > 1 {
+		// The server counts the line n times when its
+		// sample rate is divided by n.
+		m.Rate /= float64(r.n)
+	}
+	switch r.op {
+	case opCounter:
+		return c.appendCounter(r.flag, c.appendTo(nil, m))
+	case opGauge:
+		var reset []byte
+		if r.flag && c.negativeGaugeReset {
+			r := *m
+			r.Value = "0"
+			reset = c.appendTo(nil, &r)
+		}
+		return c.appendGauge(reset, c.appendTo(nil, m))
+	}
+	return c.append(c.appendTo(nil, m))
+}
+
+// appendTo appends the wire format of m to buf, including the
+// client's global tags in the client's tag format. Caller must
+// hold the client mutex lock.
+func (c *client) appendTo(buf []byte, m *Metric) []byte {
+	return appendClientMetric(c, buf, "", m.Stat, m)
+}
+
+// appendClientMetric is like c.appendTo but uses prefix followed by
+// stat as the bucket name instead of m.Stat. The client prefix, if
+// any, is added before both. Caller must hold the client mutex lock.
+func appendClientMetric[S string | []byte](c *client, buf []byte, prefix string, stat S, m *Metric) []byte {
+	if c.prefix != "" {
+		buf = append(buf, c.prefix...)
+	}
+	buf = append(buf, prefix...)
+	if len(c.tags) == 0 && c.containerID == "" {
+		return appendMetric(buf, stat, m, c.tagFormat)
+	}
+	m1 := *m
+	if len(c.tags) > 0 {
+		m1.Tags = c.tags
+		if len(m.Tags) > 0 {
+			m1.Tags = append(c.tags[:len(c.tags):len(c.tags)], m.Tags...)
+		}
+	}
+	m1.ContainerID = c.containerID
+	return appendMetric(buf, stat, &m1, c.tagFormat)
+}
+
+// sample reports whether a metric with the given sample rate
+// should be sent.
+func (c *client) sample(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	var f float64
+	if c.rand == nil {
+		f = rand.Float64()
+	} else {
+		c.randMu.Lock()
+		f = c.rand.Float64()
+		c.randMu.Unlock()
+	}
+	if f >= rate {
+		c.sampledOut.Add(1)
+		return false
+	}
+	return true
+}
+
+// append adds a formatted metric to the buffer, flushing first if the
+// metric would not fit in the current packet. Caller must hold the client
+// mutex lock.
+func (c *client) append(metric []byte) error {
+	if c.closed {
+		c.discardClosed()
+		return nil
+	}
+	if c.discard.Load() {
+		// Metrics queued or aggregated before the client
+		// was disabled are discarded too.
+		return nil
+	}
+	if c.headerLen()+len(metric) > c.size {
+		var err error
+		if metric, err = c.fitMetric(metric); err != nil {
+			return err
+		}
+	}
+
+	var err error
+
+	// Flush data if we have reach the buffer limit. Other
+	// goroutines may have added to the buffer if the lock was
+	// released while flushing, so check again afterwards.
+	for c.buf.Len() > 0 && c.buf.Len()+len("\n")+len(metric) > c.size {
 		err = c.flush()
 		if err != nil {
 			return err
@@ -170,15 +1625,23 @@ func (c *client) send(stat string, rate float64, format string, args ...interfac
 
 	// Buffer is not empty, start filling it
 	if c.buf.Len() > 0 {
-		_, err = fmt.Fprint(&c.buf, "\n")
-		if err != nil {
-			return err
+		c.buf.WriteByte('\n')
+	} else {
+		if c.linger > 0 {
+			c.startLinger()
+		}
+		if len(c.header) > 0 {
+			c.buf.Write(c.header)
+			c.buf.WriteByte('\n')
 		}
 	}
-	_, err = fmt.Fprintf(&c.buf, format, args...)
-	if err != nil {
-		return err
-	}
+	c.buf.Write(metric)
+	c.buffered++
+	c.recorded.Add(1)
 
+	// A metric larger than a packet is sent by itself.
+	if c.unbuffered || c.buf.Len() > c.size || c.flushThreshold > 0 && c.buffered >= c.flushThreshold {
+		return c.flush()
+	}
 	return nil
 }
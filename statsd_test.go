@@ -2,35 +2,68 @@ package statsd
 
 import (
 	"bytes"
-	"io"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"net"
-	"sync"
+	"os"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
 
+// halfSource is a rand.Source that makes sampling deterministic:
+// metrics are sent when the rate is greater than 0.5.
+type halfSource struct{}
+
+func (halfSource) Int63() int64 {
+	return 1 << 62
+}
+
+func (halfSource) Seed(int64) {}
+
 type testClient struct {
 	client *client
-	wg     sync.WaitGroup
 	buf    bytes.Buffer
 }
 
+// bufConn is a net.Conn that synchronously appends everything written to
+// it to buf.
+type bufConn struct {
+	net.Conn
+	buf *bytes.Buffer
+}
+
+func (c bufConn) Write(p []byte) (int, error) {
+	return c.buf.Write(p)
+}
+
+func (c bufConn) Close() error {
+	return nil
+}
+
+// discardConn is a net.Conn that discards everything written to it.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardConn) Close() error {
+	return nil
+}
+
 func newTestClient(t *testing.T) *testClient {
-	r, w := net.Pipe()
-	tc := &testClient{
-		client: &client{
-			size: defaultBufSize,
-			conn: w,
-		},
+	tc := &testClient{}
+	tc.client = &client{
+		size: defaultBufSize,
+		conn: bufConn{buf: &tc.buf},
+		rand: rand.New(halfSource{}),
 	}
-	go func() {
-		tc.wg.Add(1)
-		defer tc.wg.Done()
-		_, err := io.Copy(&tc.buf, r)
-		if err != nil {
-			t.Fatal(err)
-		}
-	}()
 	return tc
 }
 
@@ -46,7 +79,24 @@ func (tc *testClient) assertClose(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	tc.wg.Wait()
+}
+
+// waitBuffered waits until the client has some buffered data
+// and returns it.
+func (tc *testClient) waitBuffered(t *testing.T) string {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		tc.client.m.Lock()
+		out := tc.client.buf.String()
+		tc.client.m.Unlock()
+		if out != "" {
+			return out
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
 
 func assert(t *testing.T, value, control string) {
@@ -65,6 +115,84 @@ func TestIncrement(t *testing.T) {
 	assert(t, tc.buf.String(), "incr:1|c")
 }
 
+func TestIncrement64(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.increment64("incr", 1<<40, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.increment64("incr", -1<<40, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.increment64("incr", 12, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1099511627776|c\nincr:-1099511627776|c\nincr:12|c")
+}
+
+var incrementFloatTests = []struct {
+	delta   float64
+	control string
+}{{
+	delta:   0.1,
+	control: "incr:0.1|c",
+}, {
+	delta:   -2.5,
+	control: "incr:-2.5|c",
+}, {
+	delta:   1e-6,
+	control: "incr:0.000001|c",
+}, {
+	delta:   3,
+	control: "incr:3|c",
+}}
+
+func TestIncrementFloat(t *testing.T) {
+	for i, it := range incrementFloatTests {
+		tc := newTestClient(t)
+		err := tc.client.incrementFloat("incr", it.delta, 1)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), it.control)
+	}
+}
+
+func TestDropZeroCounts(t *testing.T) {
+	tc := newTestClient(t)
+	tc.client.setDropZeroCounts(true)
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(tc.client.increment("incr", 0, 1))
+	checkErr(tc.client.increment64("incr", 0, 1))
+	checkErr(tc.client.incrementFloat("incr", 0, 1))
+	checkErr(tc.client.decrement("decr", 0, 1))
+	checkErr(tc.client.increment("incr", 1, 1))
+	checkErr(tc.client.decrement("decr", 2, 1))
+	checkErr(tc.client.gauge("gauge", 0, 1))
+	checkErr(tc.client.timing("timing", 0, 1))
+	checkErr(tc.client.unique("unique", 0, 1))
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c\ndecr:-2|c\ngauge:0|g\ntiming:0|ms\nunique:0|s")
+}
+
+func TestZeroCounts(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.increment("incr", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:0|c")
+}
+
 func TestDecrement(t *testing.T) {
 	tc := newTestClient(t)
 	err := tc.client.decrement("decr", 1, 1)
@@ -85,6 +213,149 @@ func TestDuration(t *testing.T) {
 	assert(t, tc.buf.String(), "timing:123|ms")
 }
 
+func TestDurationSince(t *testing.T) {
+	tc := newTestClient(t)
+	clock := newFakeClock()
+	tc.client.clock = clock
+	func() {
+		defer tc.client.durationSince("timing", clock.Now(), 1)
+		clock.Advance(20 * time.Millisecond)
+	}()
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "timing:20|ms")
+}
+
+func TestDurations(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.durations("timing", []time.Duration{time.Second, 0, 123456789}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "timing:1000|ms\ntiming:0|ms\ntiming:123|ms")
+}
+
+func TestDurationsNegative(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.durations("timing", []time.Duration{time.Second, -5 * time.Millisecond}, 1)
+	if err == nil || err.Error() != "invalid timing value -5" {
+		t.Fatalf("got error %v, want invalid timing value", err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestDurationsOverflow(t *testing.T) {
+	tc := newTestClient(t)
+	ds := make([]time.Duration, 40)
+	for i := range ds {
+		ds[i] = 765 * time.Millisecond
+	}
+	err := tc.client.durations("timing", ds, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each metric is 13 bytes plus a newline separator, so 36
+	// of them fit in the first packet.
+	lines := make([]string, 36)
+	for i := range lines {
+		lines[i] = "timing:765|ms"
+	}
+	assert(t, tc.buf.String(), strings.Join(lines, "\n"))
+	tc.buf.Reset()
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join(lines[:4], "\n"))
+}
+
+func TestDurationsRate(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.durations("timing", []time.Duration{time.Second, time.Second}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+var durationNTests = []struct {
+	n       int
+	rate    float64
+	control string
+}{{
+	n:       1,
+	rate:    1,
+	control: "timing:765|ms",
+}, {
+	n:       4,
+	rate:    1,
+	control: "timing:765|ms|@0.25",
+}, {
+	n:       1000000,
+	rate:    1,
+	control: "timing:765|ms|@1e-06",
+}, {
+	n:       2,
+	rate:    0.99,
+	control: "timing:765|ms|@0.495",
+}, {
+	n:       0,
+	rate:    1,
+	control: "",
+}, {
+	n:       10,
+	rate:    0,
+	control: "",
+}}
+
+func TestDurationN(t *testing.T) {
+	for i, dt := range durationNTests {
+		tc := newTestClient(t)
+		err := tc.client.durationN("timing", 765*time.Millisecond, dt.n, dt.rate)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), dt.control)
+	}
+}
+
+func TestDurationNNegative(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.durationN("timing", time.Second, -1, 1)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+var durationFloatTests = []struct {
+	duration time.Duration
+	control  string
+}{{
+	duration: 123456789,
+	control:  "timing:123.456789|ms",
+}, {
+	duration: 213 * time.Microsecond,
+	control:  "timing:0.213|ms",
+}, {
+	duration: 1,
+	control:  "timing:0.000001|ms",
+}, {
+	duration: 5 * time.Second,
+	control:  "timing:5000|ms",
+}}
+
+func TestDurationFloat(t *testing.T) {
+	for i, dt := range durationFloatTests {
+		tc := newTestClient(t)
+		err := tc.client.durationFloat("timing", dt.duration, 1)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), dt.control)
+	}
+}
+
 func TestIncrementRate(t *testing.T) {
 	tc := newTestClient(t)
 	err := tc.client.increment("incr", 1, 0.99)
@@ -126,101 +397,1217 @@ func TestGauge(t *testing.T) {
 	assert(t, tc.buf.String(), "gauge:300|g")
 }
 
-func TestIncrementGauge(t *testing.T) {
+func TestGauge64(t *testing.T) {
 	tc := newTestClient(t)
-	err := tc.client.incrementGauge("gauge", 10, 1)
+	err := tc.client.gauge64("gauge", 5000000000, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	tc.assertClose(t)
-	assert(t, tc.buf.String(), "gauge:+10|g")
-}
-
-func TestDecrementGauge(t *testing.T) {
-	tc := newTestClient(t)
-	err := tc.client.decrementGauge("gauge", 4, 1)
+	err = tc.client.gauge64("gauge", -5000000000, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	tc.assertClose(t)
-	assert(t, tc.buf.String(), "gauge:-4|g")
-}
-
-func TestUnique(t *testing.T) {
-	tc := newTestClient(t)
-	err := tc.client.unique("unique", 765, 1)
+	err = tc.client.gauge64("gauge", -300, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
 	tc.assertClose(t)
-	assert(t, tc.buf.String(), "unique:765|s")
+	assert(t, tc.buf.String(), "gauge:5000000000|g\ngauge:-5000000000|g\ngauge:-300|g")
 }
 
-var millisecondTests = []struct {
-	duration time.Duration
-	control  int
+var gaugeFloat64Tests = []struct {
+	value   float64
+	control string
 }{{
-	duration: 350 * time.Millisecond,
-	control:  350,
+	value:   3.14,
+	control: "gauge:3.14|g",
 }, {
-	duration: 5 * time.Second,
-	control:  5000,
+	value:   0.1,
+	control: "gauge:0.1|g",
 }, {
-	duration: 50 * time.Nanosecond,
-	control:  0,
+	value:   -12.5,
+	control: "gauge:-12.5|g",
+}, {
+	value:   0.0000001,
+	control: "gauge:0.0000001|g",
+}, {
+	value:   1e9,
+	control: "gauge:1000000000|g",
+}, {
+	value:   0,
+	control: "gauge:0|g",
 }}
 
-func TestMilliseconds(t *testing.T) {
-	for i, mt := range millisecondTests {
-		value := millisecond(mt.duration)
-		if value != mt.control {
-			t.Errorf("%d: incorrect value, want %d, got %d", i, mt.control, value)
+func TestGaugeFloat64(t *testing.T) {
+	for i, gt := range gaugeFloat64Tests {
+		tc := newTestClient(t)
+		err := tc.client.gaugeFloat64("gauge", gt.value, 1)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
 		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), gt.control)
 	}
 }
 
-func TestTiming(t *testing.T) {
+func TestGaugeRate(t *testing.T) {
 	tc := newTestClient(t)
-	err := tc.client.timing("timing", 350, 1)
+	err := tc.client.gauge("gauge", 300, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.gauge("gauge", 400, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 	tc.assertClose(t)
-	assert(t, tc.buf.String(), "timing:350|ms")
+	assert(t, tc.buf.String(), "gauge:300|g|@0.99")
 }
 
-func TestTime(t *testing.T) {
+func TestNegativeGauge(t *testing.T) {
 	tc := newTestClient(t)
-	err := tc.client.time("time", 1, func() { time.Sleep(50e6) })
+	err := tc.client.gauge("gauge", -300, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "gauge:-300|g")
 }
 
-func TestMultiPacket(t *testing.T) {
+func TestNegativeGaugeReset(t *testing.T) {
 	tc := newTestClient(t)
-	err := tc.client.unique("unique", 765, 1)
+	tc.client.setNegativeGaugeReset(true)
+	err := tc.client.gauge("gauge", -300, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = tc.client.unique("unique", 765, 1)
+	err = tc.client.gauge64("gauge", -5000000000, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.gaugeFloat64("gauge", -0.5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.gauge("gauge", 300, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
 	tc.assertClose(t)
-	assert(t, tc.buf.String(), "unique:765|s\nunique:765|s")
+	assert(t, tc.buf.String(), "gauge:0|g\ngauge:-300|g\ngauge:0|g|@0.99\ngauge:-5000000000|g|@0.99\ngauge:0|g\ngauge:-0.5|g\ngauge:300|g")
 }
 
-func TestMultiPacketOverflow(t *testing.T) {
+func TestNegativeGaugeResetOverflow(t *testing.T) {
 	tc := newTestClient(t)
-	for i := 0; i < 40; i++ {
-		err := tc.client.unique("unique", 765, 1)
+	tc.client.setNegativeGaugeReset(true)
+	// Choose a stat name so that the reset and value lines
+	// together only just fit into an empty packet.
+	err := tc.client.unique("unique", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := strings.Repeat("x", 250)
+	err = tc.client.gauge(stat, -1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, tc.buf.String(), "unique:1|s")
+	tc.buf.Reset()
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), stat+":0|g\n"+stat+":-1|g")
+}
+
+type testGaugeCount uint16
+
+func TestGaugeValue(t *testing.T) {
+	checkSame := func(got, want func(c *client) error) {
+		t.Helper()
+		tc0 := newTestClient(t)
+		tc0.client.setNegativeGaugeReset(true)
+		if err := want(tc0.client); err != nil {
+			t.Fatal(err)
+		}
+		tc0.assertClose(t)
+		tc1 := newTestClient(t)
+		tc1.client.setNegativeGaugeReset(true)
+		if err := got(tc1.client); err != nil {
+			t.Fatal(err)
+		}
+		tc1.assertClose(t)
+		assert(t, tc1.buf.String(), tc0.buf.String())
+	}
+	for _, v := range []int{0, 1, -300, 1 << 30} {
+		checkSame(func(c *client) error {
+			return gaugeValue(c, "gauge", v, 1)
+		}, func(c *client) error {
+			return c.gauge("gauge", v, 1)
+		})
+		checkSame(func(c *client) error {
+			return gaugeValue(c, "gauge", int32(v), 1)
+		}, func(c *client) error {
+			return c.gauge("gauge", v, 1)
+		})
+	}
+	for _, v := range []float64{0, 2, -0.5, 3.14, 1e-7} {
+		checkSame(func(c *client) error {
+			return gaugeValue(c, "gauge", v, 1)
+		}, func(c *client) error {
+			return c.gaugeFloat64("gauge", v, 1)
+		})
+	}
+	tc := newTestClient(t)
+	checkErr := func(err error) {
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
-	assert(t, tc.buf.String(), "unique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s")
-	tc.buf.Reset()
+	checkErr(gaugeValue(tc.client, "gauge", uint64(1<<64-1), 1))
+	checkErr(gaugeValue(tc.client, "gauge", float32(0.1), 1))
+	checkErr(gaugeValue(tc.client, "gauge", testGaugeCount(7), 1))
+	checkErr(gaugeValue(tc.client, "gauge", int8(-5), 1))
 	tc.assertClose(t)
-	assert(t, tc.buf.String(), "unique:765|s")
+	assert(t, tc.buf.String(), "gauge:18446744073709551615|g\ngauge:0.1|g\ngauge:7|g\ngauge:-5|g")
+}
+
+func TestGaugeAt(t *testing.T) {
+	tc := newTestClient(t)
+	tc.client.setNegativeGaugeReset(true)
+	ts := time.Unix(1700000000, 999999999)
+	err := tc.client.gaugeAt("gauge", 30, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.gaugeAt("gauge", -30, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.gaugeAt("gauge", 30, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "gauge:30|g|T1700000000\ngauge:0|g|T1700000000\ngauge:-30|g|T1700000000\ngauge:30|g")
+}
+
+func TestGaugeBool(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.gaugeBool("up", true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.gaugeBool("up", false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "up:1|g\nup:0|g")
+}
+
+func TestIncrementGauge(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.incrementGauge("gauge", 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "gauge:+10|g")
+}
+
+var gaugeSignTests = []struct {
+	send    func(c *client, stat string, value int, rate float64, tags ...string) error
+	value   int
+	control string
+}{{
+	send:    (*client).gauge,
+	value:   0,
+	control: "gauge:0|g",
+}, {
+	send:    (*client).gauge,
+	value:   5,
+	control: "gauge:5|g",
+}, {
+	send:    (*client).gauge,
+	value:   -5,
+	control: "gauge:-5|g",
+}, {
+	send:    (*client).incrementGauge,
+	value:   0,
+	control: "gauge:+0|g",
+}, {
+	send:    (*client).incrementGauge,
+	value:   5,
+	control: "gauge:+5|g",
+}, {
+	send:    (*client).incrementGauge,
+	value:   -5,
+	control: "gauge:-5|g",
+}, {
+	send:    (*client).decrementGauge,
+	value:   0,
+	control: "gauge:+0|g",
+}, {
+	send:    (*client).decrementGauge,
+	value:   5,
+	control: "gauge:-5|g",
+}, {
+	send:    (*client).decrementGauge,
+	value:   -5,
+	control: "gauge:+5|g",
+}}
+
+func TestGaugeSign(t *testing.T) {
+	for i, gt := range gaugeSignTests {
+		tc := newTestClient(t)
+		err := gt.send(tc.client, "gauge", gt.value, 1)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), gt.control)
+	}
+}
+
+func TestDecrementGauge(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.decrementGauge("gauge", 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "gauge:-4|g")
+}
+
+func TestUnique(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.unique("unique", 765, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "unique:765|s")
+}
+
+func TestHistogram(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.histogram("histogram", 42, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.histogram("histogram", -0.125, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.histogram("histogram", 1234567.5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "histogram:42|h\nhistogram:-0.125|h\nhistogram:1234567.5|h")
+}
+
+func TestHistogramRate(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.histogram("histogram", 0.5, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.histogram("histogram", 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "histogram:0.5|h|@0.99")
+}
+
+var sizeTests = []struct {
+	unit    SizeUnit
+	bytes   int64
+	control string
+}{{
+	bytes:   1536,
+	control: "size:1536|h",
+}, {
+	unit:    Bytes,
+	bytes:   0,
+	control: "size:0|h",
+}, {
+	unit:    KiB,
+	bytes:   1536,
+	control: "size:2|h",
+}, {
+	unit:    KiB,
+	bytes:   1535,
+	control: "size:1|h",
+}, {
+	unit:    KiB,
+	bytes:   511,
+	control: "size:0|h",
+}, {
+	unit:    MiB,
+	bytes:   5 << 30,
+	control: "size:5120|h",
+}, {
+	unit:    GiB,
+	bytes:   1<<62 + 1<<29,
+	control: "size:4294967297|h",
+}}
+
+func TestSize(t *testing.T) {
+	for i, st := range sizeTests {
+		tc := newTestClient(t)
+		if st.unit != 0 {
+			err := tc.client.setSizeUnit(st.unit)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := tc.client.byteSize("size", st.bytes, 1)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), st.control)
+	}
+}
+
+func TestSizeRate(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setSizeUnit(KiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.byteSize("size", 4096, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.byteSize("size", 4096, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "size:4|h|@0.99")
+}
+
+func TestSizeInvalid(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.byteSize("size", -1, 1)
+	if err == nil {
+		t.Errorf("no error for negative size")
+	}
+	err = tc.client.setSizeUnit(0)
+	if err == nil {
+		t.Errorf("no error for zero unit")
+	}
+}
+
+var parseAddrTests = []struct {
+	addr    string
+	network string
+	address string
+	err     string
+}{
+	{addr: "localhost:8125", network: "udp", address: "localhost:8125"},
+	{addr: "[::1]:8125", network: "udp", address: "[::1]:8125"},
+	{addr: "localhost", network: "udp", address: "localhost"},
+	{addr: "udp://localhost:8125", network: "udp", address: "localhost:8125"},
+	{addr: "udp4://127.0.0.1:8125", network: "udp4", address: "127.0.0.1:8125"},
+	{addr: "udp6://[::1]:8125", network: "udp6", address: "[::1]:8125"},
+	{addr: "tcp://localhost:8125", network: "tcp", address: "localhost:8125"},
+	{addr: "tcp4://localhost:8125", network: "tcp4", address: "localhost:8125"},
+	{addr: "tcp6://localhost:8125", network: "tcp6", address: "localhost:8125"},
+	{addr: "/var/run/statsd.sock", network: "unixgram", address: "/var/run/statsd.sock"},
+	{addr: "./statsd.sock", network: "unixgram", address: "./statsd.sock"},
+	{addr: "unixgram:///var/run/dsd.sock", network: "unixgram", address: "/var/run/dsd.sock"},
+	{addr: "unix:///var/run/statsd.sock", network: "unixgram", address: "/var/run/statsd.sock"},
+	{addr: "http://localhost:8125", err: `unknown scheme "http" in address "http://localhost:8125"`},
+	{addr: "UDP://localhost:8125", err: `unknown scheme "UDP" in address "UDP://localhost:8125"`},
+	{addr: "://localhost:8125", err: `unknown scheme "" in address "://localhost:8125"`},
+	{addr: "udp://", err: `no address after scheme in "udp://"`},
+	{addr: "127.0.0.1:8125", network: "udp", address: "127.0.0.1:8125"},
+	{addr: "udp6://[fe80::1%eth0]:8125", network: "udp6", address: "[fe80::1%eth0]:8125"},
+	{addr: "::1:8125", err: `IPv6 address in "::1:8125" must be enclosed in brackets, as in [::1]:8125`},
+	{addr: "udp6://::1:8125", err: `IPv6 address in "udp6://::1:8125" must be enclosed in brackets, as in [::1]:8125`},
+}
+
+func TestParseAddr(t *testing.T) {
+	for _, test := range parseAddrTests {
+		network, address, err := parseAddr(test.addr)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("parseAddr(%q) returned error %v, want %q", test.addr, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAddr(%q) returned error %v", test.addr, err)
+			continue
+		}
+		if network != test.network || address != test.address {
+			t.Errorf("parseAddr(%q) = %q, %q, want %q, %q", test.addr, network, address, test.network, test.address)
+		}
+	}
+}
+
+func TestSetPacketSize(t *testing.T) {
+	var packets []string
+	c := NewClientWriter(packetWriter{&packets}, 0).c
+	if err := c.setPacketSize(0); err == nil {
+		t.Errorf("no error for zero packet size")
+	}
+	if err := c.setPacketSize(1024); err != nil {
+		t.Fatal(err)
+	}
+	metric := strings.Repeat("x", 600)
+	if err := c.increment(metric, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 0 {
+		t.Fatalf("unexpected packets %q", packets)
+	}
+	// Shrinking the packet size flushes metrics that no longer fit.
+	if err := c.setPacketSize(defaultBufSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.increment(metric, 1, 1); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := c.increment("incr", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, strings.Join(packets, "\n--\n"), metric+":1|c\nincr:1|c\n--\nincr:2|c")
+}
+
+func TestFlushThreshold(t *testing.T) {
+	var packets []string
+	c := NewClientWriter(packetWriter{&packets}, 0).c
+	if err := c.setFlushThreshold(-1); err == nil {
+		t.Errorf("no error for negative threshold")
+	}
+	if err := c.setFlushThreshold(3); err != nil {
+		t.Fatal(err)
+	}
+	c.increment("a", 1, 1)
+	c.increment("b", 1, 1)
+	if len(packets) != 0 {
+		t.Fatalf("flushed after 2 metrics: %q", packets)
+	}
+	c.increment("c", 1, 1)
+	assert(t, strings.Join(packets, "|"), "a:1|c\nb:1|c\nc:1|c")
+
+	// Metrics that do not fit in a packet are still split
+	// before the threshold is reached.
+	metric := strings.Repeat("x", 300)
+	c.increment(metric, 1, 1)
+	c.increment(metric, 2, 1)
+	c.increment("d", 1, 1)
+	assert(t, strings.Join(packets[1:], "|"), metric+":1|c")
+
+	// Lowering the threshold below the number of buffered
+	// metrics flushes them, and a threshold of 1 sends each
+	// metric immediately.
+	packets = nil
+	if err := c.setFlushThreshold(1); err != nil {
+		t.Fatal(err)
+	}
+	c.increment("e", 1, 1)
+	c.increment("f", 1, 1)
+	assert(t, strings.Join(packets, "|"), metric+":2|c\nd:1|c|e:1|c|f:1|c")
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamFraming(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		var packets []string
+		c := &client{
+			size:   20,
+			conn:   writerConn{packetWriter{&packets}},
+			stream: stream,
+		}
+		for _, stat := range []string{"a", "bb", "ccc", "dddd"} {
+			c.increment(stat, 1, 1)
+		}
+		c.m.Lock()
+		c.flush()
+		c.m.Unlock()
+		c.increment("e", 1, 1)
+		c.m.Lock()
+		c.flush()
+		c.m.Unlock()
+		if len(packets) < 3 {
+			t.Fatalf("got %d writes, want at least 3: %q", len(packets), packets)
+		}
+		if !stream {
+			// Datagrams are not terminated, as each
+			// is parsed separately.
+			for _, p := range packets {
+				if strings.HasSuffix(p, "\n") {
+					t.Errorf("datagram %q ends with newline", p)
+				}
+			}
+			continue
+		}
+		// A stream server sees the writes concatenated, so
+		// each must end with a newline for the lines to be
+		// parsed correctly.
+		got := strings.Split(strings.TrimSuffix(strings.Join(packets, ""), "\n"), "\n")
+		assert(t, strings.Join(got, " "), "a:1|c bb:1|c ccc:1|c dddd:1|c e:1|c")
+	}
+}
+
+func TestPacketHeader(t *testing.T) {
+	var packets []string
+	c := NewClientWriter(packetWriter{&packets}, 30).c
+	if err := c.setPacketHeader([]byte("#app=a\nb")); err == nil {
+		t.Fatal("expected error for header with newline")
+	}
+	if err := c.setPacketHeader([]byte(strings.Repeat("x", 29))); err == nil {
+		t.Fatal("expected error for header filling the packet")
+	}
+	header := "#app=x"
+	if err := c.setPacketHeader([]byte(header)); err != nil {
+		t.Fatal(err)
+	}
+	// The largest metric that fits after the header.
+	stat := strings.Repeat("s", 30-len(header)-len("\n:1|c"))
+	if err := c.increment(stat+"s", 1, 1); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := c.increment(stat, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, stat := range []string{"a", "b", "c", "d", "e"} {
+		c.increment(stat, 1, 1)
+	}
+	if err := c.setPacketSize(len(header) + 1); err == nil {
+		t.Fatal("expected error for packet size with no room for metrics")
+	}
+	c.close()
+	want := []string{
+		header + "\n" + stat + ":1|c",
+		header + "\na:1|c\nb:1|c\nc:1|c\nd:1|c",
+		header + "\ne:1|c",
+	}
+	assert(t, strings.Join(packets, " "), strings.Join(want, " "))
+	for _, p := range packets {
+		if len(p) > 30 {
+			t.Errorf("packet %q too big", p)
+		}
+	}
+}
+
+func TestPacketSizeLimit(t *testing.T) {
+	for _, size := range []int{PacketSizeSafe, PacketSizeLAN, PacketSizeJumbo} {
+		var packets []string
+		c := NewClientWriter(packetWriter{&packets}, size).c
+		// The largest metric that fits in a packet.
+		stat := strings.Repeat("x", size-len(":1|c"))
+		if err := c.increment(stat, 1, 1); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if err := c.increment(stat+"x", 1, 1); err != errTooBig {
+			t.Fatalf("size %d: unexpected error %v", size, err)
+		}
+		// Metrics fill each packet up to the size.
+		n := (size + 1) / len("incr:1|c\n")
+		for i := 0; i < n; i++ {
+			if err := c.increment("incr", 1, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.close(); err != nil {
+			t.Fatal(err)
+		}
+		if len(packets) != 2 {
+			t.Fatalf("size %d: got %d packets, want 2", size, len(packets))
+		}
+		if len(packets[0]) != size {
+			t.Errorf("size %d: got first packet of %d bytes", size, len(packets[0]))
+		}
+		if len(packets[1]) > size || len(packets[1]) < size-len("incr:1|c") {
+			t.Errorf("size %d: got second packet of %d bytes", size, len(packets[1]))
+		}
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	// Nothing reads from the other end of the pipe,
+	// so writes block until the deadline.
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := newClient()
+	c.conn = conn
+	if err := c.setWriteTimeout(-1); err == nil {
+		t.Fatalf("no error for negative timeout")
+	}
+	const timeout = 50 * time.Millisecond
+	if err := c.setWriteTimeout(timeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	flushed := make(chan error, 1)
+	go func() {
+		c.m.Lock()
+		defer c.m.Unlock()
+		close(started)
+		flushed <- c.flush()
+	}()
+	<-started
+	// Recording a metric waits for the flush, but
+	// no longer than the timeout.
+	start := time.Now()
+	if err := c.increment("incr", 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 20*timeout {
+		t.Errorf("increment took %v", d)
+	}
+	if err := <-flushed; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.distribution("dist", 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.increment("incr", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.distribution("dist", -2.75, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "dist:3|d\nincr:1|c\ndist:-2.75|d")
+}
+
+func TestDistributionRate(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.distribution("dist", 0.001, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "dist:0.001|d|@0.99")
+}
+
+func TestUniqueRate(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.unique("unique", 765, 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.uniqueString("unique", "x", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "unique:765|s|@0.99")
+}
+
+func TestUniqueString(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.uniqueString("unique", "user-42@example.com", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.uniqueString("unique", "100%", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "unique:user-42@example.com|s\nunique:100%|s")
+}
+
+func TestUniqueStringInvalid(t *testing.T) {
+	tc := newTestClient(t)
+	for _, value := range []string{"a|b", "a:b", "a\nb"} {
+		err := tc.client.uniqueString("unique", value, 1)
+		if err == nil {
+			t.Errorf("no error for value %q", value)
+		}
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestUniqueStringTooBig(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.uniqueString("unique", strings.Repeat("x", defaultBufSize), 1)
+	if err != errTooBig {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = tc.client.uniqueString("unique", strings.Repeat("x", defaultBufSize-len("unique:|s")), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "unique:"+strings.Repeat("x", defaultBufSize-len("unique:|s"))+"|s")
+}
+
+func TestKeyValue(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.keyValue("kv", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.increment("incr", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.keyValue("kv", -0.25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "kv:42|kv\nincr:1|c\nkv:-0.25|kv")
+}
+
+func TestSendRaw(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.sendRaw("kv", "42", "kv", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.sendRaw("dist", "1.5", "d", 0.99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "kv:42|kv\ndist:1.5|d|@0.99")
+}
+
+func TestSendRawInvalid(t *testing.T) {
+	tc := newTestClient(t)
+	for _, kind := range []string{"", "c|@0.1", "c\nfoo:1|c"} {
+		err := tc.client.sendRaw("stat", "1", kind, 1)
+		if err == nil {
+			t.Errorf("no error for kind %q", kind)
+		}
+	}
+	for _, value := range []string{"1|c", "1\nfoo:1"} {
+		err := tc.client.sendRaw("stat", value, "c", 1)
+		if err == nil {
+			t.Errorf("no error for value %q", value)
+		}
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+type testUserID uint64
+
+type testUUID [2]uint64
+
+func (u testUUID) String() string {
+	return fmt.Sprintf("%016x%016x", u[0], u[1])
+}
+
+func TestUniqueValue(t *testing.T) {
+	tc := newTestClient(t)
+	for _, value := range []interface{}{
+		765,
+		int64(-1 << 40),
+		"host-1",
+		testUserID(1<<64 - 1),
+		int16(-7),
+		testUUID{1, 2},
+	} {
+		err := tc.client.uniqueValue("unique", value, 1)
+		if err != nil {
+			t.Fatalf("%#v: %v", value, err)
+		}
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "unique:765|s\n"+
+		"unique:-1099511627776|s\n"+
+		"unique:host-1|s\n"+
+		"unique:18446744073709551615|s\n"+
+		"unique:-7|s\n"+
+		"unique:00000000000000010000000000000002|s")
+}
+
+func TestUniqueValueInvalid(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.uniqueValue("unique", 1.5, 1)
+	if err == nil || err.Error() != "unsupported set value type float64" {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = tc.client.uniqueValue("unique", "a|b", 1)
+	if err == nil {
+		t.Errorf("no error for invalid string")
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestBytesStat(t *testing.T) {
+	calls := []struct {
+		str   func(c *client) error
+		bytes func(c *client) error
+	}{{
+		str:   func(c *client) error { return c.increment("incr", 5, 1) },
+		bytes: func(c *client) error { return c.incrementBytes("", []byte("incr"), 5, 1) },
+	}, {
+		str:   func(c *client) error { return c.increment("incr", 0, 0) },
+		bytes: func(c *client) error { return c.incrementBytes("", []byte("incr"), 0, 0) },
+	}, {
+		str:   func(c *client) error { return c.gauge("gauge", -300, 1) },
+		bytes: func(c *client) error { return c.gaugeBytes("", []byte("gauge"), -300, 1) },
+	}, {
+		str:   func(c *client) error { return c.gauge("gauge", 300, 1) },
+		bytes: func(c *client) error { return c.gaugeBytes("", []byte("gauge"), 300, 1) },
+	}, {
+		str:   func(c *client) error { return c.duration("timing", 123456789, 1) },
+		bytes: func(c *client) error { return c.durationBytes("", []byte("timing"), 123456789, 1) },
+	}}
+	for _, reset := range []bool{false, true} {
+		tc0 := newTestClient(t)
+		tc0.client.setNegativeGaugeReset(reset)
+		tc1 := newTestClient(t)
+		tc1.client.setNegativeGaugeReset(reset)
+		for i, call := range calls {
+			if err := call.str(tc0.client); err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			if err := call.bytes(tc1.client); err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+		}
+		tc0.assertClose(t)
+		tc1.assertClose(t)
+		assert(t, tc1.buf.String(), tc0.buf.String())
+	}
+}
+
+func TestDurationBytesAggregated(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.durationBytes("", []byte("timing"), time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.setTimerAggregation(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "timing.max:1000|g\ntiming.count:1|c")
+}
+
+var millisecondTests = []struct {
+	duration time.Duration
+	control  int
+}{{
+	duration: 350 * time.Millisecond,
+	control:  350,
+}, {
+	duration: 5 * time.Second,
+	control:  5000,
+}, {
+	duration: 50 * time.Nanosecond,
+	control:  0,
+}}
+
+func TestMilliseconds(t *testing.T) {
+	for i, mt := range millisecondTests {
+		value := millisecond(mt.duration)
+		if value != mt.control {
+			t.Errorf("%d: incorrect value, want %d, got %d", i, mt.control, value)
+		}
+	}
+}
+
+func TestTiming(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.timing("timing", 350, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "timing:350|ms")
+}
+
+var timingFloatTests = []struct {
+	delta   float64
+	control string
+}{{
+	delta:   0,
+	control: "timing:0|ms",
+}, {
+	delta:   0.5,
+	control: "timing:0.5|ms",
+}, {
+	delta:   12.345,
+	control: "timing:12.345|ms",
+}, {
+	delta:   86400000,
+	control: "timing:86400000|ms",
+}}
+
+func TestTimingFloat(t *testing.T) {
+	for i, tt := range timingFloatTests {
+		tc := newTestClient(t)
+		err := tc.client.timingFloat("timing", tt.delta, 1)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		tc.assertClose(t)
+		assert(t, tc.buf.String(), tt.control)
+	}
+}
+
+func TestTimingFloatInvalid(t *testing.T) {
+	tc := newTestClient(t)
+	for _, delta := range []float64{-1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		err := tc.client.timingFloat("timing", delta, 1)
+		if err == nil {
+			t.Errorf("no error for %v", delta)
+		}
+	}
+	err := tc.client.timing("timing", -1, 1)
+	if err == nil {
+		t.Errorf("no error for negative integer timing")
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestTime(t *testing.T) {
+	tc := newTestClient(t)
+	clock := newFakeClock()
+	tc.client.clock = clock
+	err := tc.client.time("time", 1, func() { clock.Advance(50 * time.Millisecond) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "time:50|ms")
+}
+
+func TestTimeDuration(t *testing.T) {
+	tc := newTestClient(t)
+	clock := newFakeClock()
+	tc.client.clock = clock
+	called := false
+	d, err := tc.client.timeDuration("time", 0, func() {
+		called = true
+		clock.Advance(10 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("function not called when sampled out")
+	}
+	if d != 10*time.Millisecond {
+		t.Fatalf("unexpected duration %v", d)
+	}
+
+	d, err = tc.client.timeDuration("time", 1, func() { clock.Advance(10 * time.Millisecond) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 10*time.Millisecond {
+		t.Fatalf("unexpected duration %v", d)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "time:10|ms")
+}
+
+func TestTimeErr(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.timeErr("time", 1, func() error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ferr := errors.New("some error")
+	err = tc.client.timeErr("time", 1, func() error {
+		return ferr
+	})
+	if err != ferr {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "time:0|ms\ntime:0|ms")
+}
+
+func TestMultiPacket(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.unique("unique", 765, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.unique("unique", 765, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "unique:765|s\nunique:765|s")
+}
+
+func TestMultiPacketOverflow(t *testing.T) {
+	tc := newTestClient(t)
+	for i := 0; i < 40; i++ {
+		err := tc.client.unique("unique", 765, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert(t, tc.buf.String(), "unique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s\nunique:765|s")
+	tc.buf.Reset()
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "unique:765|s")
+}
+
+var benchDurations = []time.Duration{
+	1 * time.Millisecond,
+	20 * time.Millisecond,
+	300 * time.Millisecond,
+	4 * time.Second,
+}
+
+func BenchmarkDurationLoop(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, d := range benchDurations {
+				c.duration("timing", d, 1)
+			}
+		}
+	})
+}
+
+func BenchmarkDurations(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.durations("timing", benchDurations, 1)
+		}
+	})
+}
+
+func BenchmarkIncrement(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.increment("requests.total", 1, 1)
+	}
+}
+
+var benchStat = []byte("myservice.prod.http.requests.total")
+
+func BenchmarkIncrementStringConversion(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.increment(string(benchStat), 1, 1)
+	}
+}
+
+func BenchmarkIncrementBytes(b *testing.B) {
+	c := &client{
+		size: defaultBufSize,
+		conn: discardConn{},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.incrementBytes("", benchStat, 1, 1)
+	}
+}
+
+// slowWriter is a writer that takes a while to write each packet.
+type slowWriter struct{}
+
+func (slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(50 * time.Microsecond)
+	return len(p), nil
+}
+
+func (slowWriter) Close() error {
+	return nil
+}
+
+// BenchmarkIncrementFlushLoad measures the latency of incrementing
+// a counter while another goroutine keeps flushing, when packets
+// are written with the client lock held and when metrics are added
+// to one buffer while the other is written.
+func BenchmarkIncrementFlushLoad(b *testing.B) {
+	b.Run("locked", func(b *testing.B) {
+		benchmarkIncrementFlushLoad(b, false)
+	})
+	b.Run("unlocked", func(b *testing.B) {
+		benchmarkIncrementFlushLoad(b, true)
+	})
+}
+
+func benchmarkIncrementFlushLoad(b *testing.B, unlock bool) {
+	c := &client{
+		size: defaultBufSize,
+		conn: slowWriter{},
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c.m.Lock()
+			if c.buf.Len() > 0 {
+				c.unlockWrites = unlock
+				c.flush()
+				c.unlockWrites = false
+			}
+			c.m.Unlock()
+		}
+	}()
+	latencies := make([]time.Duration, b.N)
+	b.ResetTimer()
+	for i := range latencies {
+		t0 := time.Now()
+		c.incrementBytes("", benchStat, 1, 1)
+		latencies[i] = time.Since(t0)
+	}
+	b.StopTimer()
+	close(stop)
+	<-done
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
 }
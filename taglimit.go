@@ -0,0 +1,120 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// overflowTagValue replaces tag values beyond the limit
+	// set by SetMaxTagValues.
+	overflowTagValue = "__overflow__"
+
+	// maxTrackedTagValues bounds the total number of tag values
+	// remembered by a tagLimiter across all keys.
+	maxTrackedTagValues = 10000
+)
+
+// tagLimiter limits the number of distinct values sent for
+// each tag key.
+type tagLimiter struct {
+	max int
+
+	mu sync.Mutex
+
+	// values holds the values seen for each key.
+	values map[string]map[string]bool
+
+	// tracked holds the total number of values in values.
+	tracked int
+
+	// reported holds the keys for which an overflow
+	// has been reported.
+	reported map[string]bool
+}
+
+func newTagLimiter(max int) *tagLimiter {
+	return &tagLimiter{
+		max:      max,
+		values:   make(map[string]map[string]bool),
+		reported: make(map[string]bool),
+	}
+}
+
+// limit returns tags with any values that exceed the limit replaced
+// by overflowTagValue. The first time a key exceeds the limit, it also
+// returns an error describing it. The tags slice is not modified.
+func (l *tagLimiter) limit(tags []string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	copied := false
+	for i, tag := range tags {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok {
+			continue
+		}
+		values := l.values[key]
+		if values[value] {
+			continue
+		}
+		if len(values) < l.max && l.tracked < maxTrackedTagValues {
+			if values == nil {
+				values = make(map[string]bool)
+				l.values[key] = values
+			}
+			values[value] = true
+			l.tracked++
+			continue
+		}
+		if !copied {
+			tags = append([]string(nil), tags...)
+			copied = true
+		}
+		tags[i] = key + ":" + overflowTagValue
+		if !l.reported[key] && err == nil {
+			l.reported[key] = true
+			err = fmt.Errorf("tag %q has too many distinct values; sending %q instead", key, overflowTagValue)
+		}
+	}
+	return tags, err
+}
+
+// setMaxTagValues sets the maximum number of distinct values sent for
+// each tag key. See SetMaxTagValues for details.
+func (c *client) setMaxTagValues(perKey int) error {
+	if perKey < 0 {
+		return fmt.Errorf("negative tag value limit %d", perKey)
+	}
+	if perKey == 0 {
+		c.tagLimiter.Store(nil)
+		return nil
+	}
+	c.tagLimiter.Store(newTagLimiter(perKey))
+	return nil
+}
+
+// resetTagValues forgets all the tag values seen so far.
+func (c *client) resetTagValues() {
+	if l := c.tagLimiter.Load(); l != nil {
+		c.tagLimiter.CompareAndSwap(l, newTagLimiter(l.max))
+	}
+}
+
+// limitTags applies the tag value limit, if any, to tags, reporting
+// the first overflow of each key to the error function. Caller must
+// not hold the client mutex lock.
+func (c *client) limitTags(tags []string) []string {
+	if len(tags) == 0 {
+		return tags
+	}
+	l := c.tagLimiter.Load()
+	if l == nil {
+		return tags
+	}
+	tags, err := l.limit(tags)
+	c.reportError(err)
+	return tags
+}
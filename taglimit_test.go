@@ -0,0 +1,118 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMaxTagValues(t *testing.T) {
+	tc := newTestClient(t)
+	var errs []error
+	tc.client.setErrorFunc(func(err error) {
+		errs = append(errs, err)
+	})
+	if err := tc.client.setMaxTagValues(2); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"a", "b", "c", "a", "d", "b"} {
+		err := tc.client.increment("incr", 1, 1, "user:"+user, "code:200", "plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.client.gauge("gauge", 1, 1, "user:e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.timing("timing", 1, 1, "user:b"); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr:1|c|#user:a,code:200,plain",
+		"incr:1|c|#user:b,code:200,plain",
+		"incr:1|c|#user:__overflow__,code:200,plain",
+		"incr:1|c|#user:a,code:200,plain",
+		"incr:1|c|#user:__overflow__,code:200,plain",
+		"incr:1|c|#user:b,code:200,plain",
+		"gauge:1|g|#user:__overflow__",
+		"timing:1|ms|#user:b",
+	}, "\n"))
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+	assert(t, errs[0].Error(), `tag "user" has too many distinct values; sending "__overflow__" instead`)
+}
+
+func TestResetTagValues(t *testing.T) {
+	tc := newTestClient(t)
+	errCount := 0
+	tc.client.setErrorFunc(func(err error) {
+		errCount++
+	})
+	if err := tc.client.setMaxTagValues(1); err != nil {
+		t.Fatal(err)
+	}
+	send := func(user string) {
+		if err := tc.client.increment("incr", 1, 1, "user:"+user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send("a")
+	send("b")
+	tc.client.resetTagValues()
+	send("b")
+	send("a")
+	if err := tc.client.setMaxTagValues(0); err != nil {
+		t.Fatal(err)
+	}
+	send("c")
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr:1|c|#user:a",
+		"incr:1|c|#user:__overflow__",
+		"incr:1|c|#user:b",
+		"incr:1|c|#user:__overflow__",
+		"incr:1|c|#user:c",
+	}, "\n"))
+	if errCount != 2 {
+		t.Fatalf("got %d errors, want 2", errCount)
+	}
+}
+
+func TestMaxTagValuesMemoryCap(t *testing.T) {
+	l := newTagLimiter(maxTrackedTagValues)
+	for i := 0; i < maxTrackedTagValues; i++ {
+		tags, err := l.limit([]string{fmt.Sprintf("k%d:v%d", i%7, i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(tags[0], overflowTagValue) {
+			t.Fatalf("unexpected overflow at %d", i)
+		}
+	}
+	tags, err := l.limit([]string{"other:v"})
+	if err == nil {
+		t.Fatalf("no error after memory cap reached")
+	}
+	assert(t, tags[0], "other:__overflow__")
+	if l.tracked != maxTrackedTagValues {
+		t.Fatalf("tracking %d values, want %d", l.tracked, maxTrackedTagValues)
+	}
+}
+
+func TestMaxTagValuesDoesNotModifyTags(t *testing.T) {
+	l := newTagLimiter(1)
+	l.limit([]string{"k:a"})
+	tags := []string{"k:b"}
+	out, _ := l.limit(tags)
+	assert(t, tags[0], "k:b")
+	assert(t, out[0], "k:__overflow__")
+}
+
+func TestSetMaxTagValuesNegative(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setMaxTagValues(-1); err == nil {
+		t.Fatal("no error from negative limit")
+	}
+}
@@ -0,0 +1,457 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTags(t *testing.T) {
+	tc := newTestClient(t)
+	tc.client.setNegativeGaugeReset(true)
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(tc.client.increment("incr", 1, 1, "env:prod", "region:eu"))
+	checkErr(tc.client.increment("incr", 1, 0.99, "env:prod"))
+	checkErr(tc.client.decrement("decr", 1, 1, "a"))
+	checkErr(tc.client.duration("timing", time.Second, 1, "a"))
+	checkErr(tc.client.timing("timing", 5, 1, "a"))
+	checkErr(tc.client.gauge("gauge", -3, 1, "a"))
+	checkErr(tc.client.gaugeFloat64("gauge", 0.5, 1, "a"))
+	checkErr(tc.client.incrementGauge("gauge", 3, 1, "a"))
+	checkErr(tc.client.decrementGauge("gauge", 3, 1, "a"))
+	checkErr(tc.client.unique("unique", 765, 1, "a"))
+	checkErr(tc.client.histogram("hist", 1.5, 1, "a"))
+	checkErr(tc.client.distribution("dist", 1.5, 1, "a"))
+	checkErr(tc.client.increment("incr", 1, 1))
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr:1|c|#env:prod,region:eu",
+		"incr:1|c|@0.99|#env:prod",
+		"decr:-1|c|#a",
+		"timing:1000|ms|#a",
+		"timing:5|ms|#a",
+		"gauge:0|g|#a",
+		"gauge:-3|g|#a",
+		"gauge:0.5|g|#a",
+		"gauge:+3|g|#a",
+		"gauge:-3|g|#a",
+		"unique:765|s|#a",
+		"hist:1.5|h|#a",
+		"dist:1.5|d|#a",
+		"incr:1|c",
+	}, "\n"))
+}
+
+func TestInvalidTags(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setStrict(true); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"", "a|b", "a,b", "a\nb"} {
+		if err := tc.client.increment("incr", 1, 1, tag); err == nil {
+			t.Errorf("no error from increment for tag %q", tag)
+		}
+		if err := tc.client.gauge("gauge", 1, 1, "ok", tag); err == nil {
+			t.Errorf("no error from gauge for tag %q", tag)
+		}
+		if err := tc.client.duration("timing", time.Second, 1, tag); err == nil {
+			t.Errorf("no error from duration for tag %q", tag)
+		}
+		if err := tc.client.unique("unique", 1, 1, tag); err == nil {
+			t.Errorf("no error from unique for tag %q", tag)
+		}
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestTagsTooBig(t *testing.T) {
+	tc := newTestClient(t)
+	tag := strings.Repeat("x", defaultBufSize-len("incr:1|c|#"))
+	err := tc.client.increment("incr", 1, 1, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.client.increment("incr", 1, 1, tag+"x")
+	if err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c|#"+tag)
+}
+
+func TestTimerAggregationTags(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setTimerAggregation(time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tags := range [][]string{{"b"}, nil, {"a", "x"}, {"b"}} {
+		err := tc.client.timing("timing", 5, 1, tags...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tc.client.setTimerAggregation(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"timing.max:5|g",
+		"timing.count:1|c",
+		"timing.max:5|g|#a,x",
+		"timing.count:1|c|#a,x",
+		"timing.max:5|g|#b",
+		"timing.count:2|c|#b",
+	}, "\n"))
+}
+
+func TestGlobalTags(t *testing.T) {
+	tc := newTestClient(t)
+	tc.client.setNegativeGaugeReset(true)
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(tc.client.setGlobalTags([]string{"host:a", "env:prod"}))
+	checkErr(tc.client.increment("incr", 1, 1))
+	checkErr(tc.client.increment("incr", 1, 0.99, "x"))
+	checkErr(tc.client.gauge("gauge", -1, 1, "x"))
+	checkErr(tc.client.incrementBytes("", []byte("bytes"), 2, 1))
+	checkErr(tc.client.counter("ctr", 1).Add(3))
+	checkErr(tc.client.gaugeHandle("gh", 1).Set(-2))
+	checkErr(tc.client.setGlobalTags(nil))
+	checkErr(tc.client.increment("incr", 1, 1, "x"))
+	checkErr(tc.client.counter("ctr", 1).Add(3))
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr:1|c|#host:a,env:prod",
+		"incr:1|c|@0.99|#host:a,env:prod,x",
+		"gauge:0|g|#host:a,env:prod,x",
+		"gauge:-1|g|#host:a,env:prod,x",
+		"bytes:2|c|#host:a,env:prod",
+		"ctr:3|c|#host:a,env:prod",
+		"gh:0|g|#host:a,env:prod",
+		"gh:-2|g|#host:a,env:prod",
+		"incr:1|c|#x",
+		"ctr:3|c",
+	}, "\n"))
+}
+
+func TestInvalidGlobalTags(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setStrict(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setGlobalTags([]string{"ok", "a|b"}); err == nil {
+		t.Fatalf("no error from invalid global tag")
+	}
+	if err := tc.client.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c")
+}
+
+func TestGlobalTagsTooBig(t *testing.T) {
+	tc := newTestClient(t)
+	err := tc.client.setGlobalTags([]string{strings.Repeat("x", defaultBufSize-len("incr:1|c|#"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.increment("incr", 1, 1, "y"); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := tc.client.counter("incr", 1).Add(10); err != errTooBig {
+		t.Fatalf("unexpected error %v", err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c|#"+strings.Repeat("x", defaultBufSize-len("incr:1|c|#")))
+}
+
+var tagFormatTests = []struct {
+	about  string
+	format TagFormat
+	tags   []string
+	expect []string
+}{{
+	about:  "dogstatsd",
+	format: TagFormatDogStatsD,
+	tags:   []string{"host:a", "region:eu"},
+	expect: []string{
+		"incr:1|c|#host:a,region:eu",
+		"incr:1|c|@0.99|#host:a,region:eu",
+		"gauge:+3|g|#host:a,region:eu",
+		"timing:5|ms|#host:a,region:eu",
+		"gauge:1|g|#host:a,region:eu|T1700000000",
+		"untagged:1|c",
+	},
+}, {
+	about:  "influx",
+	format: TagFormatInflux,
+	tags:   []string{"host:a", "region:eu"},
+	expect: []string{
+		"incr,host=a,region=eu:1|c",
+		"incr,host=a,region=eu:1|c|@0.99",
+		"gauge,host=a,region=eu:+3|g",
+		"timing,host=a,region=eu:5|ms",
+		"gauge,host=a,region=eu:1|g|T1700000000",
+		"untagged:1|c",
+	},
+}, {
+	about:  "influx escaping",
+	format: TagFormatInflux,
+	tags:   []string{"a b:c,d", "e=f:g h"},
+	expect: []string{
+		`incr,a\ b=c\,d,e\=f=g\ h:1|c`,
+		`incr,a\ b=c\,d,e\=f=g\ h:1|c|@0.99`,
+		`gauge,a\ b=c\,d,e\=f=g\ h:+3|g`,
+		`timing,a\ b=c\,d,e\=f=g\ h:5|ms`,
+		`gauge,a\ b=c\,d,e\=f=g\ h:1|g|T1700000000`,
+		"untagged:1|c",
+	},
+}, {
+	about:  "graphite",
+	format: TagFormatGraphite,
+	tags:   []string{"host:a", "region:eu"},
+	expect: []string{
+		"incr;host=a;region=eu:1|c",
+		"incr;host=a;region=eu:1|c|@0.99",
+		"gauge;host=a;region=eu:+3|g",
+		"timing;host=a;region=eu:5|ms",
+		"gauge;host=a;region=eu:1|g|T1700000000",
+		"untagged:1|c",
+	},
+}, {
+	about:  "signalfx",
+	format: TagFormatSignalFx,
+	tags:   []string{"host:a", "region:eu"},
+	expect: []string{
+		"incr[host=a,region=eu]:1|c",
+		"incr[host=a,region=eu]:1|c|@0.99",
+		"gauge[host=a,region=eu]:+3|g",
+		"timing[host=a,region=eu]:5|ms",
+		"gauge[host=a,region=eu]:1|g|T1700000000",
+		"untagged:1|c",
+	},
+}, {
+	about:  "signalfx single tag",
+	format: TagFormatSignalFx,
+	tags:   []string{"dim_1-a:x.y"},
+	expect: []string{
+		"incr[dim_1-a=x.y]:1|c",
+		"incr[dim_1-a=x.y]:1|c|@0.99",
+		"gauge[dim_1-a=x.y]:+3|g",
+		"timing[dim_1-a=x.y]:5|ms",
+		"gauge[dim_1-a=x.y]:1|g|T1700000000",
+		"untagged:1|c",
+	},
+}}
+
+func TestTagFormat(t *testing.T) {
+	for _, test := range tagFormatTests {
+		t.Run(test.about, func(t *testing.T) {
+			tc := newTestClient(t)
+			checkErr := func(err error) {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkErr(tc.client.setTagFormat(test.format))
+			checkErr(tc.client.increment("incr", 1, 1, test.tags...))
+			checkErr(tc.client.increment("incr", 1, 0.99, test.tags...))
+			checkErr(tc.client.incrementGauge("gauge", 3, 1, test.tags...))
+			checkErr(tc.client.timing("timing", 5, 1, test.tags...))
+			checkErr(tc.client.send(Metric{Stat: "gauge", Value: "1", Kind: "g", Rate: 1, Tags: test.tags, Timestamp: time.Unix(1700000000, 0)}))
+			checkErr(tc.client.increment("untagged", 1, 1))
+			tc.assertClose(t)
+			assert(t, tc.buf.String(), strings.Join(test.expect, "\n"))
+		})
+	}
+}
+
+func TestTagFormatGlobalTags(t *testing.T) {
+	tc := newTestClient(t)
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(tc.client.setTagFormat(TagFormatInflux))
+	checkErr(tc.client.setGlobalTags([]string{"host:a"}))
+	checkErr(tc.client.increment("incr", 1, 1, "x:y"))
+	checkErr(tc.client.counter("ctr", 1).Add(2))
+	checkErr(tc.client.setTagFormat(TagFormatDogStatsD))
+	checkErr(tc.client.increment("incr", 1, 1, "x:y"))
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"incr,host=a,x=y:1|c",
+		"ctr,host=a:2|c",
+		"incr:1|c|#host:a,x:y",
+	}, "\n"))
+}
+
+func TestInvalidInfluxTags(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setStrict(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setTagFormat(TagFormatInflux); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"", "a", "a:", ":b", "a:b:c", "a|b:c", "a:b\n"} {
+		if err := tc.client.increment("incr", 1, 1, tag); err == nil {
+			t.Errorf("no error from increment for tag %q", tag)
+		}
+		if err := tc.client.timing("timing", 1, 1, tag); err == nil {
+			t.Errorf("no error from timing for tag %q", tag)
+		}
+	}
+	if err := tc.client.setGlobalTags([]string{"novalue"}); err == nil {
+		t.Errorf("no error from setGlobalTags")
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestInvalidGraphiteTags(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setStrict(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setTagFormat(TagFormatGraphite); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{
+		"", "a", "a:", ":b", "a:b:c", "a;b:c", "a!:b", "a^:b", "a=b:c", "a b:c",
+		"a:b;c", "a:b c", "a:~b", "a|b:c", "a:b\n",
+	} {
+		if err := tc.client.increment("incr", 1, 1, tag); err == nil {
+			t.Errorf("no error from increment for tag %q", tag)
+		}
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+// parseGraphite parses a metric line in Graphite tag format,
+// returning the bucket name, tags and the rest of the line.
+func parseGraphite(line string) (stat string, tags []string, rest string) {
+	name, rest, _ := strings.Cut(line, ":")
+	parts := strings.Split(name, ";")
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		tags = append(tags, key+":"+value)
+	}
+	return parts[0], tags, rest
+}
+
+func TestGraphiteRoundTrip(t *testing.T) {
+	tests := []struct {
+		stat string
+		tags []string
+	}{
+		{"my.metric", nil},
+		{"my.metric", []string{"region:eu"}},
+		{"my.metric", []string{"region:eu", "env:prod", "dc:a~b.c-d_e"}},
+	}
+	for _, test := range tests {
+		m := Metric{Stat: test.stat, Value: "1", Kind: "c", Tags: test.tags}
+		if err := checkTags(TagFormatGraphite, m.Tags); err != nil {
+			t.Fatal(err)
+		}
+		stat, tags, rest := parseGraphite(string(appendMetric(nil, m.Stat, &m, TagFormatGraphite)))
+		assert(t, stat, test.stat)
+		assert(t, strings.Join(tags, ","), strings.Join(test.tags, ","))
+		assert(t, rest, "1|c")
+	}
+}
+
+func TestGraphiteMixedPacket(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setTagFormat(TagFormatGraphite); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		var tags []string
+		if i%2 == 1 {
+			tags = []string{"env:prod"}
+		}
+		if err := tc.client.increment("incr", i, 1, tags...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// All the metrics should be held in the same packet.
+	want := "incr:0|c\nincr;env=prod:1|c\nincr:2|c\nincr;env=prod:3|c"
+	assert(t, tc.client.buf.String(), want)
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), want)
+}
+
+func TestInvalidSignalFxTags(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setStrict(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setTagFormat(TagFormatSignalFx); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{
+		"", "a", "a:", ":b", "1a:b", "_a:b", "a.b:c", "a b:c", "a:b,c", "a:b=c",
+		"a:b:c", "a:b]", "a:[b", "a|b:c", "a:b\n",
+	} {
+		if err := tc.client.increment("incr", 1, 1, tag); err == nil {
+			t.Errorf("no error from increment for tag %q", tag)
+		}
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "")
+}
+
+func TestSetTagFormatError(t *testing.T) {
+	tc := newTestClient(t)
+	if err := tc.client.setTagFormat(TagFormat(99)); err == nil {
+		t.Errorf("no error from unknown tag format")
+	}
+	if err := tc.client.setGlobalTags([]string{"novalue"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.client.setTagFormat(TagFormatInflux); err == nil {
+		t.Errorf("no error from tag format incompatible with global tags")
+	}
+	if err := tc.client.increment("incr", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), "incr:1|c|#novalue")
+}
+
+func TestTimerAggregationTagFormatChange(t *testing.T) {
+	tc := newTestClient(t)
+	checkErr := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkErr(tc.client.setTimerAggregation(time.Hour, nil))
+	checkErr(tc.client.timing("a", 1, 1, "novalue"))
+	checkErr(tc.client.timing("b", 2, 1, "k:v"))
+	checkErr(tc.client.setTagFormat(TagFormatInflux))
+	if err := tc.client.setTimerAggregation(0, nil); err == nil {
+		t.Fatalf("no error from timer with invalid tags")
+	}
+	tc.assertClose(t)
+	assert(t, tc.buf.String(), strings.Join([]string{
+		"b.max,k=v:2|g",
+		"b.count,k=v:1|c",
+	}, "\n"))
+}
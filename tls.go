@@ -0,0 +1,47 @@
+package statsd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// NewClientTLS returns a client that sends metrics over a TLS
+// connection to addr, using the newline-separated framing of TCP
+// addresses. The address may have a tcp, tcp4 or tcp6 scheme as
+// described for SetAddr; an address without a scheme is treated as
+// TCP. The connection is redialed after write errors as for TCP, and
+// the same TLS configuration is used for later addresses set with
+// SetAddr, which must also be TCP addresses.
+//
+// If cfg is nil, the default configuration is used. If cfg.ServerName
+// is empty, the host name in addr is used to verify the server's
+// certificate. An error is returned if the connection or the TLS
+// handshake fails.
+func NewClientTLS(addr string, cfg *tls.Config) (*Client, error) {
+	return New(addr, WithTLS(cfg))
+}
+
+// dialTLS connects to the given address on the named network
+// and performs a TLS handshake. It does not use any state guarded
+// by the client mutex lock.
+func (c *client) dialTLS(network, address string) (io.WriteCloser, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, fmt.Errorf("cannot use TLS over %s", network)
+	}
+	d := &tls.Dialer{Config: c.tlsConfig}
+	return d.Dial(network, address)
+}
+
+// socketConn returns the connection underlying conn
+// if it is a compressed or TLS connection, or conn otherwise.
+func socketConn(conn io.WriteCloser) io.WriteCloser {
+	if gz, ok := conn.(*gzipConn); ok {
+		conn = gz.conn
+	}
+	if conn, ok := conn.(*tls.Conn); ok {
+		return conn.NetConn()
+	}
+	return conn
+}
@@ -0,0 +1,147 @@
+package statsd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTLSServer returns a tcpServer that accepts TLS connections
+// using a self-signed certificate for 127.0.0.1, together with
+// a client configuration that trusts the certificate.
+func newTLSServer(t *testing.T) (*tcpServer, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "statsd test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return serveLines(ln), &tls.Config{RootCAs: roots}
+}
+
+func TestTLS(t *testing.T) {
+	s, cfg := newTLSServer(t)
+	defer s.close()
+	cl, err := NewClientTLS(s.ln.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if err := cl.SetPacketSize(20); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := cl.Increment("incr", i, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := cl.Gauge("gauge", i*10, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"incr:1|c", "gauge:10|g", "incr:2|c", "gauge:20|g", "incr:3|c", "gauge:30|g"} {
+		select {
+		case line := <-s.lines:
+			assert(t, line, want)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+func TestTLSHandshakeError(t *testing.T) {
+	s, _ := newTLSServer(t)
+	defer s.close()
+	// The default configuration does not trust the
+	// self-signed certificate.
+	_, err := NewClientTLS(s.ln.Addr().String(), nil)
+	if err == nil {
+		t.Fatal("expected handshake error")
+	}
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestTLSNotTCP(t *testing.T) {
+	_, err := NewClientTLS("udp://127.0.0.1:8125", nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	assert(t, err.Error(), "cannot use TLS over udp")
+}
+
+func TestTLSReconnect(t *testing.T) {
+	s, cfg := newTLSServer(t)
+	defer s.close()
+	cl, err := NewClientTLS(s.ln.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if err := cl.SetReconnectBackoff(time.Millisecond, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	cl.Increment("incr", 1, 1)
+	if err := cl.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.waitLine(t, "incr:1|c")
+
+	// Close the server side of the connection but keep
+	// listening. Once the write error is noticed, the client
+	// redials and metrics arrive again.
+	(<-s.conns).Close()
+	for i := 0; i < 500; i++ {
+		cl.Increment("incr", 2, 1)
+		cl.Flush()
+		select {
+		case line := <-s.lines:
+			if line == "incr:2|c" {
+				return
+			}
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatalf("metrics not sent after connection closed")
+}
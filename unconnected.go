@@ -0,0 +1,56 @@
+package statsd
+
+import (
+	"io"
+	"net"
+	"strings"
+)
+
+// setUnconnected sets whether UDP packets are sent with an
+// unconnected socket. See SetUnconnected for details.
+func (c *client) setUnconnected(unconnected bool) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.unconnected.Swap(unconnected) == unconnected || c.conn == nil || c.writer {
+		return nil
+	}
+	if network, _, err := parseAddr(c.addr); err != nil || !strings.HasPrefix(network, "udp") {
+		return nil
+	}
+	// Redial so that the setting applies to the current address.
+	return c.connect()
+}
+
+// dialUnconnected is like netDial, except that for UDP networks it
+// resolves address and returns an unconnected socket that sends to it.
+func dialUnconnected(network, address string) (io.WriteCloser, error) {
+	if !strings.HasPrefix(network, "udp") {
+		return netDial(network, address)
+	}
+	raddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &unconnectedConn{conn, raddr}, nil
+}
+
+// unconnectedConn is an unconnected UDP socket that
+// writes each packet to the same address.
+type unconnectedConn struct {
+	*net.UDPConn
+	raddr *net.UDPAddr
+}
+
+func (c *unconnectedConn) Write(p []byte) (int, error) {
+	return c.WriteToUDP(p, c.raddr)
+}
+
+// RemoteAddr returns the address that packets are written to.
+func (c *unconnectedConn) RemoteAddr() net.Addr {
+	return c.raddr
+}